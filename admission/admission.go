@@ -0,0 +1,169 @@
+/*
+Package admission decides on the accepted conns of an engine before they are registered,
+set Options.Admission to a Controller.
+*/
+package admission
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/moqsien/gknet/utils/cidr"
+	"github.com/moqsien/gknet/utils/errs"
+	"github.com/moqsien/gknet/utils/ratelimit"
+)
+
+type Config struct {
+	MaxConns   int // open conns, zero is unlimited
+	MaxPerIP   int // open conns from a source, zero is unlimited
+	IPv4Prefix int // sources are grouped by the prefix of this length, 32 by default
+	IPv6Prefix int // 128 by default
+	// Rate is the accepts per second refilling a token bucket of Burst(Rate) tokens, zero
+	// disables the limit.
+	Rate  float64
+	Burst int
+	// Only the sources in Allow are admitted unless it is empty, those in Deny are not.
+	// Both are CIDRs or addresses, and can be replaced by SetLists.
+	Allow []string
+	Deny  []string
+	Reset bool // close rejected sockets with a RST(SO_LINGER 0)
+}
+
+// Stats counts the decisions of a Controller.
+type Stats struct {
+	Active      int // admitted conns not closed yet
+	Admitted    uint64
+	Denied      uint64 // by the lists
+	OverLimit   uint64 // by MaxConns
+	OverIPLimit uint64 // by MaxPerIP
+	RateLimited uint64
+}
+
+type lists struct {
+	allow, deny []*net.IPNet
+}
+
+// Controller implements iface.Admission, it is safe for concurrent use.
+type Controller struct {
+	denied      uint64 // atomic
+	overLimit   uint64
+	overIPLimit uint64
+	rateLimited uint64
+	admitted    uint64
+	cfg         Config
+	lists       atomic.Value // *lists
+	lock        sync.Mutex
+	active      int
+	perIP       map[string]int
+	bucket      ratelimit.Bucket
+}
+
+func New(cfg Config) (*Controller, error) {
+	if cfg.IPv4Prefix <= 0 || cfg.IPv4Prefix > 8*net.IPv4len {
+		cfg.IPv4Prefix = 8 * net.IPv4len
+	}
+	if cfg.IPv6Prefix <= 0 || cfg.IPv6Prefix > 8*net.IPv6len {
+		cfg.IPv6Prefix = 8 * net.IPv6len
+	}
+	that := &Controller{cfg: cfg, perIP: make(map[string]int)}
+	that.bucket.Set(cfg.Rate, cfg.Burst)
+	if err := that.SetLists(cfg.Allow, cfg.Deny); err != nil {
+		return nil, err
+	}
+	return that, nil
+}
+
+// SetLists replaces the allow and deny lists, conns already admitted are kept.
+func (that *Controller) SetLists(allow, deny []string) error {
+	a, err := cidr.Parse(allow)
+	if err != nil {
+		return err
+	}
+	d, err := cidr.Parse(deny)
+	if err != nil {
+		return err
+	}
+	that.lists.Store(&lists{allow: a, deny: d})
+	return nil
+}
+
+func (that *Controller) RejectWithReset() bool {
+	return that.cfg.Reset
+}
+
+func (that *Controller) Admit(remote net.Addr) (release func(), err error) {
+	ip := cidr.IP(remote)
+	if ip != nil && !that.listed(ip) {
+		atomic.AddUint64(&that.denied, 1)
+		return nil, errs.ErrConnDenied
+	}
+	key := that.source(ip)
+
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	switch {
+	case that.cfg.MaxConns > 0 && that.active >= that.cfg.MaxConns:
+		atomic.AddUint64(&that.overLimit, 1)
+		return nil, errs.ErrTooManyConns
+	case key != "" && that.cfg.MaxPerIP > 0 && that.perIP[key] >= that.cfg.MaxPerIP:
+		atomic.AddUint64(&that.overIPLimit, 1)
+		return nil, errs.ErrTooManyFromIP
+	case !that.bucket.Allow(1):
+		atomic.AddUint64(&that.rateLimited, 1)
+		return nil, errs.ErrAcceptRate
+	}
+	that.active++
+	if key != "" {
+		that.perIP[key]++
+	}
+	atomic.AddUint64(&that.admitted, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { that.release(key) })
+	}, nil
+}
+
+func (that *Controller) release(key string) {
+	that.lock.Lock()
+	that.active--
+	if key != "" {
+		if that.perIP[key]--; that.perIP[key] <= 0 {
+			delete(that.perIP, key)
+		}
+	}
+	that.lock.Unlock()
+}
+
+func (that *Controller) listed(ip net.IP) bool {
+	l := that.lists.Load().(*lists)
+	if cidr.Contains(l.deny, ip) {
+		return false
+	}
+	return len(l.allow) == 0 || cidr.Contains(l.allow, ip)
+}
+
+// source returns the key of the group of ip for MaxPerIP.
+func (that *Controller) source(ip net.IP) string {
+	if ip == nil || that.cfg.MaxPerIP <= 0 {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return string(ip4.Mask(net.CIDRMask(that.cfg.IPv4Prefix, 8*net.IPv4len)))
+	}
+	return string(ip.Mask(net.CIDRMask(that.cfg.IPv6Prefix, 8*net.IPv6len)))
+}
+
+func (that *Controller) Stats() Stats {
+	that.lock.Lock()
+	active := that.active
+	that.lock.Unlock()
+	return Stats{
+		Active:      active,
+		Admitted:    atomic.LoadUint64(&that.admitted),
+		Denied:      atomic.LoadUint64(&that.denied),
+		OverLimit:   atomic.LoadUint64(&that.overLimit),
+		OverIPLimit: atomic.LoadUint64(&that.overIPLimit),
+		RateLimited: atomic.LoadUint64(&that.rateLimited),
+	}
+}
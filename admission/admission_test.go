@@ -0,0 +1,132 @@
+package admission
+
+import (
+	"net"
+	"testing"
+
+	"github.com/moqsien/gknet/utils/errs"
+)
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}
+}
+
+func TestLists(t *testing.T) {
+	cases := []struct {
+		name        string
+		allow, deny []string
+		addr        net.Addr
+		err         error
+	}{
+		{"no lists", nil, nil, tcpAddr("10.0.0.1"), nil},
+		{"allowed", []string{"10.0.0.0/8"}, nil, tcpAddr("10.0.0.1"), nil},
+		{"not allowed", []string{"10.0.0.0/8"}, nil, tcpAddr("11.0.0.1"), errs.ErrConnDenied},
+		{"denied", nil, []string{"10.0.0.1"}, tcpAddr("10.0.0.1"), errs.ErrConnDenied},
+		{"deny wins", []string{"10.0.0.0/8"}, []string{"10.0.0.0/24"}, tcpAddr("10.0.0.1"), errs.ErrConnDenied},
+		{"mapped address", nil, []string{"10.0.0.0/8"}, tcpAddr("::ffff:10.0.0.1"), errs.ErrConnDenied},
+		{"ipv6", []string{"fd00::/8"}, nil, tcpAddr("fd00::1"), nil},
+		{"unix is not checked", []string{"10.0.0.0/8"}, nil, &net.UnixAddr{Name: "/tmp/a.sock", Net: "unix"}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctl, err := New(Config{Allow: c.allow, Deny: c.deny})
+			if err != nil {
+				t.Fatal(err)
+			}
+			release, err := ctl.Admit(c.addr)
+			if err != c.err {
+				t.Fatalf("Admit returned %v, want %v", err, c.err)
+			}
+			if err == nil {
+				release()
+			}
+			s := ctl.Stats()
+			if denied := c.err != nil; (s.Denied == 1) != denied || (s.Admitted == 1) == denied || s.Active != 0 {
+				t.Fatalf("got stats %+v", s)
+			}
+		})
+	}
+}
+
+func TestSetLists(t *testing.T) {
+	ctl, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := tcpAddr("10.0.0.1")
+	release, err := ctl.Admit(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ctl.SetLists(nil, []string{"10.0.0.0/8", "bad"}); err == nil {
+		t.Fatal("an invalid list is accepted")
+	}
+	if _, err = ctl.Admit(addr); err != nil {
+		t.Fatalf("the lists are changed by a failed SetLists: %v", err)
+	}
+	if err = ctl.SetLists(nil, []string{"10.0.0.0/8"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ctl.Admit(addr); err != errs.ErrConnDenied {
+		t.Fatalf("Admit returned %v after SetLists", err)
+	}
+	release()
+	if s := ctl.Stats(); s.Active != 1 {
+		t.Fatalf("%d conns active, want 1", s.Active)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	cases := []struct {
+		name  string
+		cfg   Config
+		addrs []string
+		errs  []error
+	}{
+		{"max conns", Config{MaxConns: 2}, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			[]error{nil, nil, errs.ErrTooManyConns}},
+		{"max per ip", Config{MaxPerIP: 1}, []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"},
+			[]error{nil, nil, errs.ErrTooManyFromIP}},
+		{"ipv4 prefix", Config{MaxPerIP: 1, IPv4Prefix: 24}, []string{"10.0.0.1", "10.0.0.2", "10.0.1.1"},
+			[]error{nil, errs.ErrTooManyFromIP, nil}},
+		{"ipv6 prefix", Config{MaxPerIP: 1, IPv6Prefix: 64}, []string{"fd00::1", "fd00::2", "fd00:0:0:1::1"},
+			[]error{nil, errs.ErrTooManyFromIP, nil}},
+		{"rate", Config{Rate: 0.001, Burst: 2}, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			[]error{nil, nil, errs.ErrAcceptRate}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctl, err := New(c.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var releases []func()
+			for i, a := range c.addrs {
+				release, err := ctl.Admit(tcpAddr(a))
+				if err != c.errs[i] {
+					t.Fatalf("Admit(%s) returned %v, want %v", a, err, c.errs[i])
+				}
+				if err == nil {
+					releases = append(releases, release)
+				}
+			}
+			if s := ctl.Stats(); s.Active != len(releases) {
+				t.Fatalf("%d conns active, want %d", s.Active, len(releases))
+			}
+			for _, release := range releases {
+				release()
+				release()
+			}
+			if s := ctl.Stats(); s.Active != 0 || len(ctl.perIP) != 0 {
+				t.Fatalf("%d conns active after release, %d sources left", s.Active, len(ctl.perIP))
+			}
+			if c.cfg.Rate > 0 {
+				return
+			}
+			// the released conns make room again.
+			if _, err = ctl.Admit(tcpAddr(c.addrs[len(c.addrs)-1])); err != nil {
+				t.Fatalf("Admit after release returned %v", err)
+			}
+		})
+	}
+}
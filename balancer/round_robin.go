@@ -34,7 +34,7 @@ func (that *RoundRobin) Register(e iface.IELoop) {
 
 func (that *RoundRobin) Next(addr ...net.Addr) (e iface.IELoop) {
 	e = that.eloopList[that.nextIndex]
-	if that.nextIndex++; that.nextIndex > that.size {
+	if that.nextIndex++; that.nextIndex >= that.size {
 		that.nextIndex = 0
 	}
 	return
@@ -4,21 +4,25 @@ conn defines the Conn object which packs system file descriptor and performs rea
 package conn
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/moqsien/processes/logger"
 	"github.com/panjf2000/gnet/v2/pkg/buffer/elastic"
 
 	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/logging"
 	"github.com/moqsien/gknet/poll"
 	"github.com/moqsien/gknet/sys"
+	"github.com/moqsien/gknet/utils/budget"
 	"github.com/moqsien/gknet/utils/errs"
+	"github.com/moqsien/gknet/utils/ratelimit"
 )
 
 type Conn struct {
@@ -34,9 +38,39 @@ type Conn struct {
 	Ctx             *iface.Context
 	Opened          bool
 	Handler         iface.IEventHandler
+	Hook            FdHook // set to handle the fd events directly, see FdHook
 	WritevChunkSize int
 	ErrChan         chan error
 	lock            *sync.Mutex
+	detached        net.Conn    // blocking conn after Detach
+	files           []*fileSend // files waiting for the socket, see SendFile
+	asyncLock       sync.Mutex
+	asyncHooks      []asyncOp // pending AsyncWrite and AsyncWritev calls
+	asyncRunning    bool
+	readPaused      bool
+	writeWatched    bool
+	highWater       int
+	lowWater        int
+	writeLimit      int
+	aboveHigh       bool // reading is stopped by the high water mark
+	budget          *budget.Budget
+	held            int // bytes counted in budget
+	inLimit         int
+	reuse           bool           // put back into connPool when closed
+	gen             uint32         // see Generation
+	ctx             *iface.Context // kept for reuse, Ctx is nil after closing
+	mailbox         mailbox
+	trace           iface.ConnTrace
+	tlsConn         *tls.Conn // set by InitContext
+	stats           connStats // see Info
+	release         func()    // of Options.Admission
+	readRate        ratelimit.Bucket
+	trackRate       ratelimit.Bucket
+	throttled       bool        // reading is stopped by the rate limits
+	throttleTimer   *time.Timer // resumes reading
+	writeClosed     bool        // CloseWrite has been called
+	writeShut       bool        // the write side is shut down
+	readClosed      bool        // by CloseRead or the peer
 }
 
 type ConnOpts struct {
@@ -49,10 +83,27 @@ type ConnOpts struct {
 	WritevChunkSize   int
 	SocketWriteBuffer int
 	SocketReadBuffer  int
+	HighWaterMark     int
+	LowWaterMark      int
+	WriteBufferLimit  int
+	Budget            *budget.Budget
+	InBufferLimit     int
+	Reuse             bool // reuse the Conn after it is closed, see Ref
+	Trace             iface.ConnTrace
+	Release           func() // called by Finish
+	ReadRate          float64
+	ReadBurst         int
+	TrackRate         float64
+	TrackBurst        int
+	Socket            *iface.SocketOptions
 }
 
 // new Conn
 func NewTCPConn(fd int) (c *Conn) {
+	if c, _ = connPool.Get().(*Conn); c != nil {
+		c.Fd = fd
+		return
+	}
 	c = &Conn{
 		Fd:      fd,
 		ErrChan: make(chan error, 1),
@@ -78,7 +129,11 @@ func (that *Conn) SetConn(co *ConnOpts) {
 	if wbc <= 0 {
 		wbc = 1024
 	}
-	that.OutBuffer, _ = elastic.New(wbc)
+	if that.OutBuffer != nil {
+		that.OutBuffer.Reset(wbc)
+	} else {
+		that.OutBuffer, _ = elastic.New(wbc)
+	}
 	if co.Handler != nil {
 		that.Handler = co.Handler
 	}
@@ -86,17 +141,35 @@ func (that *Conn) SetConn(co *ConnOpts) {
 		co.WritevChunkSize = iface.DefaultWritevChunkSize
 	}
 	that.WritevChunkSize = co.WritevChunkSize
+	that.highWater, that.lowWater, that.writeLimit = co.HighWaterMark, co.LowWaterMark, co.WriteBufferLimit
+	if that.highWater > 0 && (that.lowWater <= 0 || that.lowWater >= that.highWater) {
+		that.lowWater = that.highWater / 2
+	}
+	that.budget, that.inLimit, that.reuse = co.Budget, co.InBufferLimit, co.Reuse
+	that.trace, that.release = co.Trace, co.Release
+	that.SetReadRate(co.ReadRate, co.ReadBurst)
+	that.SetTrackRate(co.TrackRate, co.TrackBurst)
 	if co.SocketReadBuffer > 0 {
 		sys.SetRecvBufferSize(that.Fd, co.SocketReadBuffer)
 	}
 	if co.SocketWriteBuffer > 0 {
 		sys.SetSendBufferSize(that.Fd, co.SocketWriteBuffer)
 	}
+	that.setSocketOptions(co.Socket)
 }
 
 /*
 private methods
 */
+// logger returns the logger of the loop, with the fd and the remote address of the conn.
+func (that *Conn) logger() iface.Logger {
+	var l iface.Logger
+	if that.Poller != nil {
+		l = that.Poller.Logger
+	}
+	return logging.Or(l).With("fd", that.Fd, "remote", that.AddrRemote)
+}
+
 func (that *Conn) releaseUDP() {
 	that.Ctx = nil
 	that.AddrLocal = nil
@@ -113,6 +186,16 @@ func (that *Conn) releaseTCP() {
 	that.AddrRemote = nil
 	that.InBuffer.Done()
 	that.OutBuffer.Release()
+	that.releaseFiles()
+	that.aboveHigh = false
+	that.stopThrottle()
+	that.writeClosed, that.writeShut, that.readClosed = false, false, false
+	that.Hook = nil
+	that.tlsConn = nil
+	that.stats.info.Store(nil)
+	that.budget.Add(-int64(that.held))
+	that.held = 0
+	atomic.AddUint32(&that.gen, 1)
 }
 
 /*
@@ -122,7 +205,24 @@ func (that *Conn) GetFd() int {
 	return that.Fd
 }
 
+// Finish ends the trace of the conn, gives back its admission and ends its goroutine, it
+// is called once the conn leaves the engine: closed, detached, or dropped before opening.
+func (that *Conn) Finish(err error) {
+	that.retire()
+	if that.trace != nil {
+		that.trace.End(err)
+		that.trace = nil
+	}
+	if that.release != nil {
+		that.release()
+		that.release = nil
+	}
+}
+
 func (that *Conn) Close() (rerr error) {
+	if that.detached != nil {
+		return that.detached.Close()
+	}
 	if addr := that.AddrLocal; addr != nil && strings.HasPrefix(that.AddrLocal.Network(), "udp") {
 		that.releaseUDP()
 		return
@@ -139,10 +239,11 @@ func (that *Conn) Close() (rerr error) {
 				iov = iov[:iface.IovMax]
 			}
 			if n, e := sys.Writev(that.Fd, iov); e != nil {
-				logger.Warningf("closeConn: error occurs when sending data back to peer, %v", e)
+				that.logger().Warn("failed to flush the output of a closing conn", "err", e)
 				break
 			} else {
 				that.OutBuffer.Discard(n)
+				that.CountWritten(n)
 			}
 		}
 	}
@@ -160,23 +261,34 @@ func (that *Conn) Close() (rerr error) {
 		}
 	}
 	that.Poller.Eloop.RemoveConn(that.Fd)
-	if that.Handler.OnClose(that.Ctx) != nil {
+	end := that.TraceStart(iface.TraceClose)
+	err := that.Handler.OnClose(that.Ctx)
+	end(err)
+	if err != nil {
 		rerr = errs.ErrEngineShutdown
 	}
+	that.Finish(rerr)
 	that.releaseTCP()
+	if that.reuse {
+		_ = that.Poller.AddTask(that.recycle, nil)
+	}
 	return
 }
 
 func (that *Conn) Open() error {
 	that.Opened = true
+	that.setOpenInfo()
 	var err error
-	data, _ := that.Handler.OnOpen(that.Ctx)
+	end := that.TraceStart(iface.TraceOpen)
+	data, e := that.Handler.OnOpen(that.Ctx)
+	end(e)
 	if data != nil {
 		if _, err = that.writeOnOpen(data); err != nil {
 			return err
 		}
 	}
 
+	that.account()
 	if !that.OutBuffer.IsEmpty() {
 		if err = that.Poller.AddWrite(that); err != nil {
 			return err
@@ -196,14 +308,32 @@ func (that *Conn) RemoteAddr() net.Addr {
 	return that.AddrRemote
 }
 
+// deadlines are only supported after Detach.
 func (that *Conn) SetDeadline(t time.Time) error {
+	if that.detached != nil {
+		return that.detached.SetDeadline(t)
+	}
 	return errs.ErrUnsupportedOp
 }
 
 func (that *Conn) SetReadDeadline(t time.Time) error {
+	if that.detached != nil {
+		return that.detached.SetReadDeadline(t)
+	}
 	return errs.ErrUnsupportedOp
 }
 
 func (that *Conn) SetWriteDeadline(t time.Time) error {
+	if that.detached != nil {
+		return that.detached.SetWriteDeadline(t)
+	}
 	return errs.ErrUnsupportedOp
 }
+
+// TCPInfo returns a snapshot of the TCP state of the conn, it may be called on any goroutine.
+func (that *Conn) TCPInfo() (*sys.TCPInfo, error) {
+	if that.detached != nil || that.IsUDP {
+		return nil, errs.ErrUnsupportedOp
+	}
+	return sys.GetTCPInfo(that.Fd)
+}
@@ -7,17 +7,25 @@ import (
 	"github.com/moqsien/gknet/utils"
 )
 
+// the async adapters write to the generation of the conn they were made for.
 type AsyncWriteConn struct {
 	*Conn
 	CallBack iface.AsyncCallback
+	gen      uint32
 }
 
+// Write copies data, it is sent later and callers such as bufio.Writer reuse their buffers.
 func (that *AsyncWriteConn) Write(data []byte) (n int, err error) {
 	n = len(data)
-	err = that.Conn.AsyncWrite(data, that.CallBack)
+	err = that.Conn.asyncWriteAt(that.gen, append([]byte(nil), data...), that.CallBack)
 	return
 }
 
+// CloseWrite shuts down the write side after the async writes made before.
+func (that *AsyncWriteConn) CloseWrite() error {
+	return that.Conn.closeWriteAt(that.gen)
+}
+
 type WritevConn struct {
 	*Conn
 }
@@ -32,18 +40,24 @@ func (that *WritevConn) Write(data []byte) (n int, err error) {
 type AsyncWritevConn struct {
 	*Conn
 	CallBack iface.AsyncCallback
+	gen      uint32
 }
 
 func (that *AsyncWritevConn) Write(data []byte) (n int, err error) {
 	n = len(data)
+	data = append([]byte(nil), data...)
 	if len(data) <= iface.DefaultWritevChunkSize {
-		err = that.Conn.AsyncWrite(data)
+		err = that.Conn.asyncWriteAt(that.gen, data)
 	} else {
-		err = that.Conn.AsyncWritev(utils.SplitDataForWritev(data, that.WritevChunkSize), that.CallBack)
+		err = that.Conn.asyncWritevAt(that.gen, utils.SplitDataForWritev(data, that.WritevChunkSize), that.CallBack)
 	}
 	return
 }
 
+func (that *AsyncWritevConn) CloseWrite() error {
+	return that.Conn.closeWriteAt(that.gen)
+}
+
 // Adapt adapts asyncwrite or writev to net.Conn interface.
 func (that *Conn) Adapt(adapter iface.ConnAdapter, callback ...iface.AsyncCallback) net.Conn {
 	var cb iface.AsyncCallback = nil
@@ -54,9 +68,9 @@ func (that *Conn) Adapt(adapter iface.ConnAdapter, callback ...iface.AsyncCallba
 	case iface.ConnNoneAdapter:
 		return that
 	case iface.ConnAsyncWriteAdapter:
-		return &AsyncWriteConn{Conn: that, CallBack: cb}
+		return &AsyncWriteConn{Conn: that, CallBack: cb, gen: that.Generation()}
 	case iface.ConnAsyncWritevAdapter:
-		return &AsyncWritevConn{Conn: that, CallBack: cb}
+		return &AsyncWritevConn{Conn: that, CallBack: cb, gen: that.Generation()}
 	default:
 		return that
 	}
@@ -0,0 +1,33 @@
+package conn
+
+import "github.com/moqsien/gknet/utils/errs"
+
+// account updates the pending output shown by Info, and the bytes of the conn counted in
+// the engine budget. The bufio buffers
+// of Ctx are held as long as the conn is open, the ring buffers only while they have data,
+// so drained ones are given back to their pool.
+func (that *Conn) account() {
+	that.stats.pending.Store(int64(that.Pending()))
+	if that.budget == nil {
+		return
+	}
+	held := that.Pending() + that.InBuffer.Buffered()
+	if held == 0 {
+		that.OutBuffer.Release()
+		that.InBuffer.Done()
+	}
+	if that.Ctx != nil {
+		held += that.Ctx.Reader.Size() + that.Ctx.ReadWriter.Writer.Size()
+	}
+	that.budget.Add(int64(held - that.held))
+	that.held = held
+}
+
+// checkInBuffer closes the conn if the handler keeps more unread input than the limit.
+func (that *Conn) checkInBuffer() error {
+	if that.inLimit <= 0 || that.InBuffer.Buffered() <= that.inLimit {
+		return nil
+	}
+	that.Close()
+	return errs.ErrReadOverflow
+}
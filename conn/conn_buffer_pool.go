@@ -2,17 +2,22 @@ package conn
 
 import "sync"
 
-var readBufferPool = sync.Pool{}
+var (
+	readBufferPool = sync.Pool{}
+	readBufferOnce sync.Once
+)
 
 func (that *Conn) GetBufferFromPool() []byte {
-	if readBufferPool.New == nil {
+	readBufferOnce.Do(func() {
+		// conns are reused, so New must not keep that.
+		size := that.Poller.ReadBufferSize
 		readBufferPool.New = func() interface{} {
-			return make([]byte, that.Poller.ReadBufferSize)
+			return make([]byte, size)
 		}
-	}
+	})
 	return readBufferPool.Get().([]byte)
 }
 
 func (that *Conn) PutBufferToPool(buf []byte) {
-	readBufferPool.Put(buf)
+	readBufferPool.Put(buf[:cap(buf)])
 }
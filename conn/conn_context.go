@@ -8,15 +8,29 @@ import (
 	"github.com/moqsien/gknet/iface"
 )
 
+// InitContext prepares the Context of the conn, the caller drops the conn if the TLS
+// handshake fails.
 func (that *Conn) InitContext(tconf *tls.Config, adapter iface.ConnAdapter, callback ...iface.AsyncCallback) (err error) {
 	var connection net.Conn = that.Adapt(adapter, callback...)
+	that.tlsConn = nil
 	if tconf != nil {
 		tlsConn := tls.Server(connection, tconf)
-		if err = tlsConn.Handshake(); err != nil {
-			that.Close()
+		end := that.TraceStart(iface.TraceHandshake)
+		err = tlsConn.Handshake()
+		end(err)
+		if err != nil {
 			return err
 		}
 		connection = tlsConn
+		that.tlsConn = tlsConn
+	}
+	if that.ctx != nil {
+		// a reused conn.
+		that.ctx.Reader.Reset(connection)
+		that.ctx.ReadWriter.Writer.Reset(connection)
+		that.ctx.RawConn, that.ctx.Conn = that, connection
+		that.Ctx = that.ctx
+		return
 	}
 	reader := bufio.NewReader(connection)
 	that.Ctx = &iface.Context{
@@ -25,5 +39,8 @@ func (that *Conn) InitContext(tconf *tls.Config, adapter iface.ConnAdapter, call
 		RawConn:    that,
 		Conn:       connection,
 	}
+	if that.reuse {
+		that.ctx = that.Ctx
+	}
 	return
 }
@@ -0,0 +1,96 @@
+package conn
+
+import (
+	"net"
+	"os"
+
+	"github.com/moqsien/gknet/sys"
+	"github.com/moqsien/gknet/utils/errs"
+)
+
+// prefixConn returns the bytes read by the event loop before the blocking conn.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (that *prefixConn) Read(p []byte) (n int, err error) {
+	if len(that.prefix) > 0 {
+		n = copy(p, that.prefix)
+		that.prefix = that.prefix[n:]
+		return
+	}
+	return that.Conn.Read(p)
+}
+
+// Detach removes the conn from its event loop and switches it to blocking I/O, the
+// handler will not be called anymore. Read, Write and deadlines of the conn (and of the
+// adapters or tls.Conn in Ctx) then work like those of a net.TCPConn.
+// Detach must be called on the event loop, e.g. in OnTrack. Async writes still queued
+// on the poller are written to the blocking conn when they run, and OnClose of the
+// handler is not called for a detached conn.
+func (that *Conn) Detach() (err error) {
+	if that.detached != nil {
+		return nil
+	}
+	if !that.Opened || that.IsUDP {
+		return errs.ErrConnNotOpened
+	}
+	if err = that.Poller.RemoveFd(that); err != nil {
+		return
+	}
+	that.stopThrottle()
+
+	// unread bytes of the current event.
+	var pending []byte
+	if n := that.InBuffer.Buffered(); n > 0 {
+		pending = make([]byte, n, n+len(that.Buffer))
+		that.InBuffer.Read(pending)
+	}
+	pending = append(pending, that.Buffer...)
+	that.Buffer = nil
+
+	f := os.NewFile(uintptr(that.Fd), "")
+	nc, err := net.FileConn(f)
+	// FileConn dups the fd.
+	f.Close()
+	if err != nil {
+		sys.CloseFd(that.Fd)
+	}
+	that.Poller.Eloop.RemoveConn(that.Fd)
+	that.Opened = false
+	that.stats.info.Store(nil)
+	that.Finish(err)
+	if err != nil {
+		that.OutBuffer.Release()
+		that.InBuffer.Done()
+		that.releaseFiles()
+		that.budget.Add(-int64(that.held))
+		that.held = 0
+		return
+	}
+
+	// data which the event loop has not sent yet goes first.
+	if !that.OutBuffer.IsEmpty() {
+		_, err = that.OutBuffer.WriteTo(nc)
+	}
+	if err == nil {
+		err = that.writeFilesTo(nc)
+	}
+	that.releaseFiles()
+	that.OutBuffer.Release()
+	that.InBuffer.Done()
+	that.budget.Add(-int64(that.held))
+	that.held = 0
+	if err != nil {
+		nc.Close()
+		return
+	}
+	that.detached = &prefixConn{Conn: nc, prefix: pending}
+	return
+}
+
+// Detached reports whether the conn has been detached from its event loop.
+func (that *Conn) Detached() bool {
+	return that.detached != nil
+}
@@ -0,0 +1,110 @@
+package conn
+
+import (
+	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/utils/errs"
+)
+
+// FdHook takes over the fd events of a conn. OnReadable is called instead of reading the fd
+// into Buffer and calling OnTrack, OnWritable instead of FlushOutput.
+type FdHook interface {
+	OnReadable(c *Conn) error
+	OnWritable(c *Conn) error
+}
+
+// PauseRead stops the read events of the conn until ResumeRead, pending output is still sent.
+func (that *Conn) PauseRead() error {
+	if that.readPaused {
+		return nil
+	}
+	that.readPaused = true
+	return that.updateEvents()
+}
+
+func (that *Conn) ResumeRead() error {
+	if !that.readPaused {
+		return nil
+	}
+	that.readPaused = false
+	return that.updateEvents()
+}
+
+func (that *Conn) ReadPaused() bool {
+	return that.readPaused
+}
+
+// Pending returns the bytes written to the conn and not yet taken by the socket, files
+// queued by SendFile are not counted.
+func (that *Conn) Pending() int {
+	n := that.OutBuffer.Buffered()
+	for _, fs := range that.files {
+		n += len(fs.tail)
+	}
+	return n
+}
+
+// Writable reports whether the pending output is below the high water mark.
+func (that *Conn) Writable() bool {
+	return !that.aboveHigh
+}
+
+// reserve is called before n bytes are queued, it closes the conn if they would exceed
+// the write buffer limit.
+func (that *Conn) reserve(n int) error {
+	if that.writeLimit <= 0 || that.Pending()+n <= that.writeLimit {
+		return nil
+	}
+	that.logger().Warn("closing the conn", "err", errs.ErrWriteOverflow)
+	that.Close()
+	return errs.ErrWriteOverflow
+}
+
+// checkWaterMarks stops reading when the pending output crosses the high water mark and
+// resumes it when the output falls to the low mark.
+func (that *Conn) checkWaterMarks() error {
+	if that.highWater <= 0 || !that.Opened {
+		return nil
+	}
+	pending := that.Pending()
+	switch {
+	case !that.aboveHigh && pending >= that.highWater:
+		that.aboveHigh = true
+	case that.aboveHigh && pending <= that.lowWater:
+		that.aboveHigh = false
+	default:
+		return nil
+	}
+	err := that.updateEvents()
+	if h, ok := that.Handler.(iface.IWritabilityHandler); ok {
+		h.OnWritable(that.Ctx, !that.aboveHigh)
+	}
+	return err
+}
+
+// WatchWrite waits for the fd to become writable or stops waiting. The conn does it by
+// itself for OutBuffer, hooks use it for data they keep elsewhere.
+func (that *Conn) WatchWrite(on bool) error {
+	that.writeWatched = on
+	return that.updateEvents()
+}
+
+func (that *Conn) updateEvents() error {
+	mb := &that.mailbox
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	if mb.disarmed {
+		// polled again with these flags once the mailbox is idle.
+		return nil
+	}
+	paused := that.readPaused || that.aboveHigh || that.throttled || that.readClosed
+	switch {
+	case that.writeWatched && paused:
+		return that.Poller.ModWrite(that)
+	case that.writeWatched:
+		return that.Poller.ModReadWrite(that)
+	case paused:
+		return that.Poller.ModNone(that)
+	default:
+		return that.Poller.ModRead(that)
+	}
+}
@@ -0,0 +1,118 @@
+package conn
+
+import (
+	"syscall"
+
+	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/utils/errs"
+)
+
+// closeWriteOp is queued behind the async writes by the async adapters.
+type closeWriteOp struct{}
+
+// CloseWrite shuts down the write side of the conn once the pending output has been sent,
+// the peer then reads EOF. Later writes fail with ErrWriteClosed. The conn is closed when
+// both sides are shut down.
+func (that *Conn) CloseWrite() error {
+	if that.detached != nil {
+		if cw, ok := that.detached.(interface{ CloseWrite() error }); ok {
+			return cw.CloseWrite()
+		}
+		return errs.ErrUnsupportedOp
+	}
+	if that.IsUDP {
+		return errs.ErrUnsupportedOp
+	}
+	if !that.Opened {
+		return errs.ErrConnNotOpened
+	}
+	if that.writeClosed {
+		return nil
+	}
+	that.writeClosed = true
+	if that.Pending() > 0 || len(that.files) > 0 {
+		// FlushOutput shuts it down when the output is gone.
+		return nil
+	}
+	return that.shutdownWrite()
+}
+
+// closeWriteAt queues CloseWrite behind the async writes of generation gen.
+func (that *Conn) closeWriteAt(gen uint32) error {
+	if that.Generation() != gen {
+		return errs.ErrStaleConn
+	}
+	if that.detached != nil || that.IsUDP {
+		return that.CloseWrite()
+	}
+	return that.queueAsync(gen, closeWriteOp{})
+}
+
+// CloseRead shuts down the read side of the conn, the handler is not called for input
+// anymore.
+func (that *Conn) CloseRead() error {
+	if that.detached != nil {
+		if cr, ok := that.detached.(interface{ CloseRead() error }); ok {
+			return cr.CloseRead()
+		}
+		return errs.ErrUnsupportedOp
+	}
+	if that.IsUDP {
+		return errs.ErrUnsupportedOp
+	}
+	if !that.Opened {
+		return errs.ErrConnNotOpened
+	}
+	if that.readClosed {
+		return nil
+	}
+	that.readClosed = true
+	_ = syscall.Shutdown(that.Fd, syscall.SHUT_RD)
+	if that.writeShut {
+		return that.Close()
+	}
+	return that.updateEvents()
+}
+
+// WriteClosed reports whether CloseWrite has been called.
+func (that *Conn) WriteClosed() bool {
+	return that.writeClosed
+}
+
+// ReadClosed reports whether the read side is shut down, or the peer has shut down its
+// write side.
+func (that *Conn) ReadClosed() bool {
+	return that.readClosed
+}
+
+func (that *Conn) shutdownWrite() error {
+	if that.writeShut {
+		return nil
+	}
+	that.writeShut = true
+	if err := syscall.Shutdown(that.Fd, syscall.SHUT_WR); err != nil {
+		return that.Close()
+	}
+	if that.readClosed {
+		return that.Close()
+	}
+	return nil
+}
+
+// readEOF handles the end of the input. The conn is closed, unless the handler wants to
+// know about half-closes and the write side is still open.
+func (that *Conn) readEOF() error {
+	h, ok := that.Handler.(iface.IReadEOFHandler)
+	if !ok || that.readClosed || that.writeShut {
+		return that.Close()
+	}
+	that.readClosed = true
+	if err := that.updateEvents(); err != nil {
+		return that.Close()
+	}
+	if err := h.OnReadEOF(that.Ctx); err != nil {
+		that.Close()
+		return err
+	}
+	return nil
+}
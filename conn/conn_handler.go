@@ -3,8 +3,6 @@ package conn
 import (
 	"sync"
 
-	"github.com/moqsien/processes/logger"
-
 	"github.com/moqsien/gknet/iface"
 	"github.com/moqsien/gknet/sys"
 	"github.com/moqsien/gknet/utils/errs"
@@ -22,27 +20,53 @@ func (that *Conn) sendErr(err error) {
 			return
 		}
 	default:
-		logger.Println("[AsyncHandleEvents]", err)
+		that.logger().Warn("error occurs in handling events", "err", err)
 	}
 }
 
 func (that *Conn) ReadFromFd() error {
+	if that.Hook != nil {
+		return that.Hook.OnReadable(that)
+	}
 	buf := that.GetBufferFromPool()
+	if b := that.readRate.Burst(); that.readRate.Enabled() && b < len(buf) {
+		// no more than a burst at once.
+		buf = buf[:b]
+	}
 	n, err := sys.Read(that.Fd, buf)
 	if err != nil || n == 0 {
 		if err == sys.EAGAIN {
+			that.Poller.Metrics.ReadAgain()
 			return nil
 		}
-		// conn closed by client.
-		if n == 0 {
-			err = sys.ECONNRESET
+		if err == nil {
+			// the peer has shut down its write side.
+			that.PutBufferToPool(buf)
+			return that.readEOF()
 		}
 		return that.Close()
 	}
+	that.CountRead(n)
 	that.Buffer = buf[:n]
+	end := that.TraceStart(iface.TraceTrack)
 	err = that.Handler.OnTrack(that.Ctx)
+	end(err)
+	if !that.Opened {
+		// closed or detached by the handler.
+		that.PutBufferToPool(buf)
+		return err
+	}
+	// unread bytes are kept for the next event, Buffer goes back to the pool.
 	that.InBuffer.Write(that.Buffer)
+	that.Buffer = nil
 	that.PutBufferToPool(buf)
+	if e := that.checkInBuffer(); e != nil {
+		return e
+	}
+	that.account()
+	if e := that.throttle(n); e != nil {
+		return e
+	}
 	return err
 }
 
@@ -50,25 +74,7 @@ func (that *Conn) AsyncReadFromFd() {
 	that.Poller.Pool.Submit(func() {
 		that.lock.Lock()
 		defer that.lock.Unlock()
-		buf := that.GetBufferFromPool()
-		n, err := sys.Read(that.Fd, buf)
-		if err != nil || n == 0 {
-			if err == sys.EAGAIN {
-				return
-			}
-			// conn closed by client.
-			if n == 0 {
-				err = sys.ECONNRESET
-			}
-			err = that.Close()
-			that.sendErr(err)
-			return
-		}
-		that.Buffer = buf[:n]
-		err = that.Handler.OnTrack(that.Ctx)
-		that.InBuffer.Write(that.Buffer)
-		that.PutBufferToPool(buf)
-		that.sendErr(err)
+		that.sendErr(that.ReadFromFd())
 	})
 }
 
@@ -78,29 +84,25 @@ func (that *Conn) AsyncReadFromFdAndWait(wg *sync.WaitGroup) {
 		that.lock.Lock() // can be removed.
 		defer that.lock.Unlock()
 		defer wg.Done()
-		buf := that.GetBufferFromPool()
-		n, err := sys.Read(that.Fd, buf)
-		if err != nil || n == 0 {
-			if err == sys.EAGAIN {
-				return
-			}
-			// conn closed by client.
-			if n == 0 {
-				err = sys.ECONNRESET
-			}
-			err = that.Close()
-			that.sendErr(err)
-			return
-		}
-		that.Buffer = buf[:n]
-		err = that.Handler.OnTrack(that.Ctx)
-		that.InBuffer.Write(that.Buffer)
-		that.PutBufferToPool(buf)
-		that.sendErr(err)
+		that.sendErr(that.ReadFromFd())
 	})
 }
 
 func (that *Conn) WriteToFd() error {
+	if that.Hook != nil {
+		return that.Hook.OnWritable(that)
+	}
+	return that.FlushOutput()
+}
+
+// FlushOutput writes OutBuffer and the pending files to the fd as far as it can take.
+func (that *Conn) FlushOutput() error {
+	if that.OutBuffer.IsEmpty() {
+		if err := that.flushFiles(); err != nil {
+			return that.Close()
+		}
+		return nil
+	}
 	iov := that.OutBuffer.Peek(-1)
 	var (
 		n   int
@@ -115,16 +117,24 @@ func (that *Conn) WriteToFd() error {
 		n, err = sys.Write(that.Fd, iov[0])
 	}
 	that.OutBuffer.Discard(n)
+	that.CountWritten(n)
 	switch err {
 	case nil:
 	case sys.EAGAIN:
+		that.Poller.Metrics.WriteAgain()
 		return nil
 	default:
 		return that.Close()
 	}
+	that.account()
+	if err = that.checkWaterMarks(); err != nil {
+		return err
+	}
 
 	if that.OutBuffer.IsEmpty() {
-		that.Poller.ModRead(that)
+		if err = that.flushFiles(); err != nil {
+			return that.Close()
+		}
 	}
 	return nil
 }
@@ -133,34 +143,7 @@ func (that *Conn) AsyncWriteToFd() {
 	that.Poller.Pool.Submit(func() {
 		that.lock.Lock()
 		defer that.lock.Unlock()
-		iov := that.OutBuffer.Peek(-1)
-		var (
-			n   int
-			err error
-		)
-		if len(iov) > 1 {
-			if len(iov) > iface.IovMax {
-				iov = iov[:iface.IovMax]
-			}
-			n, err = sys.Writev(that.Fd, iov)
-		} else {
-			n, err = sys.Write(that.Fd, iov[0])
-		}
-		that.OutBuffer.Discard(n)
-		switch err {
-		case nil:
-		case sys.EAGAIN:
-			return
-		default:
-			err = that.Close()
-			that.sendErr(err)
-			return
-		}
-
-		if that.OutBuffer.IsEmpty() {
-			that.Poller.ModRead(that)
-		}
-		return
+		that.sendErr(that.WriteToFd())
 	})
 }
 
@@ -170,34 +153,7 @@ func (that *Conn) AsyncWriteToFdAndWait(wg *sync.WaitGroup) {
 		that.lock.Lock()
 		defer that.lock.Unlock()
 		defer wg.Done()
-		iov := that.OutBuffer.Peek(-1)
-		var (
-			n   int
-			err error
-		)
-		if len(iov) > 1 {
-			if len(iov) > iface.IovMax {
-				iov = iov[:iface.IovMax]
-			}
-			n, err = sys.Writev(that.Fd, iov)
-		} else {
-			n, err = sys.Write(that.Fd, iov[0])
-		}
-		that.OutBuffer.Discard(n)
-		switch err {
-		case nil:
-		case sys.EAGAIN:
-			return
-		default:
-			err = that.Close()
-			that.sendErr(err)
-			return
-		}
-
-		if that.OutBuffer.IsEmpty() {
-			that.Poller.ModRead(that)
-		}
-		return
+		that.sendErr(that.WriteToFd())
 	})
 }
 
@@ -0,0 +1,91 @@
+package conn
+
+import (
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/moqsien/gknet/iface"
+)
+
+var lastID uint64
+
+// openInfo does not change while the conn is open, so it can be read from any goroutine.
+type openInfo struct {
+	id            uint64
+	fd            int
+	opened        time.Time
+	local, remote net.Addr
+	tls           *tls.Conn
+}
+
+// connStats is updated by the goroutine handling the conn, and read by Info.
+type connStats struct {
+	info    atomic.Pointer[openInfo]
+	in, out atomic.Uint64
+	pending atomic.Int64
+}
+
+func (that *Conn) setOpenInfo() {
+	that.stats.in.Store(0)
+	that.stats.out.Store(0)
+	that.stats.pending.Store(0)
+	that.stats.info.Store(&openInfo{
+		id:     atomic.AddUint64(&lastID, 1),
+		fd:     that.Fd,
+		opened: time.Now(),
+		local:  that.AddrLocal,
+		remote: that.AddrRemote,
+		tls:    that.tlsConn,
+	})
+}
+
+// CountRead records n bytes read from the conn, in its stats and the metrics of its loop.
+func (that *Conn) CountRead(n int) {
+	if n > 0 {
+		that.stats.in.Add(uint64(n))
+	}
+	that.Poller.Metrics.Read(n)
+}
+
+// CountWritten records n bytes written to the conn.
+func (that *Conn) CountWritten(n int) {
+	if n > 0 {
+		that.stats.out.Add(uint64(n))
+	}
+	that.Poller.Metrics.Written(n)
+}
+
+// ID returns the id of the conn given when it was opened, 0 if it is not open.
+func (that *Conn) ID() uint64 {
+	if info := that.stats.info.Load(); info != nil {
+		return info.id
+	}
+	return 0
+}
+
+// Info returns a snapshot of the conn, ok is false if it is not open. Unlike the other
+// methods it can be called from any goroutine, Loop is left for the caller.
+func (that *Conn) Info() (ci iface.ConnInfo, ok bool) {
+	info := that.stats.info.Load()
+	if info == nil {
+		return ci, false
+	}
+	ci = iface.ConnInfo{
+		ID:          info.id,
+		Fd:          info.fd,
+		LocalAddr:   info.local,
+		RemoteAddr:  info.remote,
+		Opened:      info.opened,
+		Age:         time.Since(info.opened),
+		BytesIn:     that.stats.in.Load(),
+		BytesOut:    that.stats.out.Load(),
+		BufferedOut: int(that.stats.pending.Load()),
+	}
+	if info.tls != nil {
+		state := info.tls.ConnectionState()
+		ci.TLS = &state
+	}
+	return ci, true
+}
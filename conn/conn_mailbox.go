@@ -0,0 +1,198 @@
+package conn
+
+import (
+	"sync"
+
+	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/sys"
+	"github.com/moqsien/gknet/utils/errs"
+)
+
+// mailbox serialises the work of a conn: the events reported by the poller and the
+// functions posted to it run one at a time, in order, where Poller.Mode says, so different
+// conns are handled in parallel while one conn never is. Events reported while the conn
+// is busy are merged into the next run, so one readiness is not handled twice.
+type mailbox struct {
+	lock     sync.Mutex
+	events   uint32
+	posts    []func() error
+	waits    int // dispatches waiting on wg
+	wg       *sync.WaitGroup
+	running  bool
+	disarmed bool          // the fd is not polled until the work is done, in DispatchPerConn mode
+	wake     chan struct{} // wakes the goroutine of the conn, in DispatchPerConn mode
+	retired  bool          // the conn has left the engine, its goroutine is gone
+	recycle  bool          // the conn is recycled once the work is done
+}
+
+// Dispatch hands events of the fd to the mailbox, wg is done once they have been handled.
+// In DispatchPerConn mode the loop does not wait, the fd is not polled until the conn is
+// idle instead, so a blocking handler holds up no other conn.
+func (that *Conn) Dispatch(events uint32, wg *sync.WaitGroup) {
+	mb := &that.mailbox
+	mb.lock.Lock()
+	mb.events |= events
+	if that.Poller.Mode == iface.DispatchPerConn {
+		if !mb.disarmed {
+			mb.disarmed = true
+			_ = that.Poller.ModNone(that)
+		}
+	} else if wg != nil {
+		wg.Add(1)
+		mb.waits++
+		mb.wg = wg
+	}
+	that.schedule(true)
+}
+
+// Post runs fn after the work already queued for the conn, never at the same time as its
+// handlers, with the lock of the handlers held. fn must not block.
+func (that *Conn) Post(fn func() error) error {
+	return that.postAt(that.Generation(), fn)
+}
+
+// postAt queues fn if the conn is still at generation gen and has not been recycled, which
+// is checked under the lock of the mailbox, so recycle never races with it.
+func (that *Conn) postAt(gen uint32, fn func() error) error {
+	mb := &that.mailbox
+	mb.lock.Lock()
+	if that.Generation() != gen || that.Poller == nil {
+		mb.lock.Unlock()
+		return errs.ErrStaleConn
+	}
+	mb.posts = append(mb.posts, fn)
+	return that.schedule(false)
+}
+
+// schedule starts the work unless it is running, it is called with mb.lock held.
+func (that *Conn) schedule(fromLoop bool) error {
+	mb := &that.mailbox
+	if mb.running {
+		mb.lock.Unlock()
+		return nil
+	}
+	mb.running = true
+	if that.Poller.Mode == iface.DispatchPerConn {
+		that.wakeWorker()
+		mb.lock.Unlock()
+		return nil
+	}
+	mb.lock.Unlock()
+	err := that.Poller.Dispatch(that.drain, fromLoop)
+	if err == nil {
+		return nil
+	}
+	mb.lock.Lock()
+	waits, wg := mb.waits, mb.wg
+	mb.events, mb.posts, mb.waits = 0, nil, 0
+	if err == errs.ErrDispatchFull {
+		// the conn is shed, the close runs as a task of the poller.
+		mb.posts = []func() error{that.Close}
+		mb.lock.Unlock()
+		_ = that.Poller.AddTask(func(_ iface.PollTaskArg) error {
+			that.drain()
+			return nil
+		}, nil)
+	} else {
+		// the pool is closed, nothing will run.
+		mb.running = false
+		mb.lock.Unlock()
+	}
+	for ; waits > 0; waits-- {
+		wg.Done()
+	}
+	return err
+}
+
+// wakeWorker hands the work to the goroutine of the conn, which is started by the first
+// work and ends once the conn has left the engine. It is called with mb.lock held.
+func (that *Conn) wakeWorker() {
+	mb := &that.mailbox
+	if mb.retired {
+		// posted after the conn has left, e.g. through a Ref.
+		go that.drain()
+		return
+	}
+	if mb.wake == nil {
+		mb.wake = make(chan struct{}, 1)
+		go that.work(mb.wake)
+	}
+	// never blocks, the work is not scheduled again until drain is done.
+	mb.wake <- struct{}{}
+}
+
+func (that *Conn) work(wake chan struct{}) {
+	for range wake {
+		that.drain()
+	}
+}
+
+// retire ends the goroutine of the conn after the work it is doing.
+func (that *Conn) retire() {
+	mb := &that.mailbox
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	if mb.wake != nil {
+		close(mb.wake)
+		mb.wake = nil
+	}
+	mb.retired = true
+}
+
+func (that *Conn) drain() {
+	mb := &that.mailbox
+	for {
+		mb.lock.Lock()
+		events, posts, waits, wg := mb.events, mb.posts, mb.waits, mb.wg
+		mb.events, mb.posts, mb.waits = 0, nil, 0
+		if events == 0 && len(posts) == 0 {
+			if !mb.disarmed {
+				mb.running = false
+				recycle := mb.recycle
+				mb.recycle = false
+				mb.lock.Unlock()
+				if recycle {
+					_ = that.Poller.AddTask(that.recycle, nil)
+				}
+				return
+			}
+			// poll the fd again, and look for the work which arrives meanwhile.
+			mb.disarmed = false
+			mb.lock.Unlock()
+			that.lock.Lock()
+			if that.Opened || that.Hook != nil {
+				_ = that.updateEvents()
+			}
+			that.lock.Unlock()
+			continue
+		}
+		mb.lock.Unlock()
+
+		// posts first, they were queued by the work handled before these events.
+		that.lock.Lock()
+		for _, fn := range posts {
+			that.sendErr(fn())
+		}
+		if events != 0 {
+			that.sendErr(that.handleEvents(events))
+		}
+		that.lock.Unlock()
+		for ; waits > 0; waits-- {
+			wg.Done()
+		}
+	}
+}
+
+// handleEvents handles merged events like sys.AsyncHandleEvents, the readiness left is
+// reported again by the level-triggered poller.
+func (that *Conn) handleEvents(events uint32) error {
+	switch {
+	case events&sys.ClosedFdEvents != 0: // only for darwin.
+		return that.Close()
+	case events&sys.OutEvents != 0:
+		return that.WriteToFd()
+	case events&sys.InEvents != 0:
+		return that.ReadFromFd()
+	}
+	return nil
+}
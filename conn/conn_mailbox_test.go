@@ -0,0 +1,205 @@
+package conn
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/poll"
+	"github.com/moqsien/gknet/sys"
+)
+
+// readEvents are the read events without the error events, which are handled as writes.
+var readEvents = uint32(sys.InEvents &^ sys.OutEvents)
+
+// testPool runs each task on a new goroutine, or queues it until run if hold is set.
+type testPool struct {
+	lock  sync.Mutex
+	hold  bool
+	tasks []func()
+}
+
+func (that *testPool) Submit(task func()) error {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	if that.hold {
+		that.tasks = append(that.tasks, task)
+		return nil
+	}
+	go task()
+	return nil
+}
+
+func (that *testPool) run() {
+	that.lock.Lock()
+	tasks := that.tasks
+	that.tasks = nil
+	that.lock.Unlock()
+	for _, task := range tasks {
+		task()
+	}
+}
+
+func (that *testPool) Running() int { return 0 }
+func (that *testPool) Cap() int     { return 0 }
+func (that *testPool) Release()     {}
+
+// testHook records the readable events of a conn, and checks that they never overlap
+// with each other or with posts.
+type testHook struct {
+	busy     int32
+	overlaps int32
+	log      []string
+	readable func(c *Conn)
+}
+
+func (that *testHook) enter() {
+	if !atomic.CompareAndSwapInt32(&that.busy, 0, 1) {
+		atomic.AddInt32(&that.overlaps, 1)
+	}
+}
+
+func (that *testHook) leave() {
+	atomic.StoreInt32(&that.busy, 0)
+}
+
+func (that *testHook) OnReadable(c *Conn) error {
+	that.enter()
+	defer that.leave()
+	that.log = append(that.log, "readable")
+	if that.readable != nil {
+		that.readable(c)
+	}
+	return nil
+}
+
+func (that *testHook) OnWritable(c *Conn) error {
+	return nil
+}
+
+func (that *testHook) post(c *Conn, name string) {
+	_ = c.Post(func() error {
+		that.enter()
+		defer that.leave()
+		that.log = append(that.log, name)
+		return nil
+	})
+}
+
+func TestMailboxOrder(t *testing.T) {
+	cases := []struct {
+		name string
+		// queue runs before the drain, readable inside the first readable event.
+		queue, readable func(h *testHook, c *Conn)
+		want            string
+	}{
+		{"event", nil, nil, "[readable]"},
+		{"posts before events", func(h *testHook, c *Conn) {
+			h.post(c, "a")
+			h.post(c, "b")
+		}, nil, "[a b readable]"},
+		{"events are merged", func(h *testHook, c *Conn) {
+			c.Dispatch(readEvents, nil)
+			h.post(c, "a")
+		}, nil, "[a readable]"},
+		{"posted by a handler", nil, func(h *testHook, c *Conn) {
+			h.post(c, "a")
+		}, "[readable a]"},
+		{"events while posts run", func(h *testHook, c *Conn) {
+			_ = c.Post(func() error {
+				c.Dispatch(readEvents, nil)
+				h.log = append(h.log, "a")
+				return nil
+			})
+		}, nil, "[a readable readable]"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pool := &testPool{hold: true}
+			conn := newTestConn(&poll.Poller{Mode: iface.DispatchPooled, Pool: pool})
+			h := &testHook{}
+			conn.Hook = h
+			if c.readable != nil {
+				h.readable = func(cn *Conn) {
+					h.readable = nil
+					c.readable(h, cn)
+				}
+			}
+			wg := &sync.WaitGroup{}
+			conn.Dispatch(readEvents, wg)
+			if c.queue != nil {
+				c.queue(h, conn)
+			}
+			pool.run()
+			wg.Wait()
+			if got := fmt.Sprint(h.log); got != c.want {
+				t.Fatalf("ran %s, want %s", got, c.want)
+			}
+			if conn.mailbox.running || len(pool.tasks) != 0 {
+				t.Fatal("the mailbox is not idle")
+			}
+		})
+	}
+}
+
+func TestMailboxWakeups(t *testing.T) {
+	const posters, posts = 8, 500
+	cases := []struct {
+		name string
+		mode iface.DispatchMode
+	}{
+		{"pooled", iface.DispatchPooled},
+		{"per conn", iface.DispatchPerConn},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := newTestConn(&poll.Poller{Mode: c.mode, Pool: &testPool{}})
+			h := &testHook{}
+			conn.Hook = h
+			var ran int32
+			var wg sync.WaitGroup
+			for i := 0; i < posters; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < posts; j++ {
+						if j%10 == 0 {
+							conn.Dispatch(readEvents, nil)
+						}
+						err := conn.Post(func() error {
+							h.enter()
+							defer h.leave()
+							atomic.AddInt32(&ran, 1)
+							return nil
+						})
+						if err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				conn.mailbox.lock.Lock()
+				idle := !conn.mailbox.running
+				conn.mailbox.lock.Unlock()
+				if idle && atomic.LoadInt32(&ran) == posters*posts {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("%d of %d posts ran, running %v", atomic.LoadInt32(&ran), posters*posts, !idle)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			conn.retire()
+			if n := atomic.LoadInt32(&h.overlaps); n != 0 {
+				t.Fatalf("the work of the conn overlapped %d times", n)
+			}
+		})
+	}
+}
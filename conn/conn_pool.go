@@ -0,0 +1,129 @@
+package conn
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/utils/errs"
+)
+
+// conns closed with ConnOpts.Reuse set, with their OutBuffer, Context and bufio buffers.
+var connPool = sync.Pool{}
+
+// Generation changes each time the conn is closed, a Conn with ConnOpts.Reuse set may be
+// reused for another client afterwards.
+func (that *Conn) Generation() uint32 {
+	return atomic.LoadUint32(&that.gen)
+}
+
+// Ref is a reference to the current client of a conn. Once the conn is closed, the methods
+// of Ref fail with errs.ErrStaleConn even if the Conn has been reused meanwhile.
+type Ref struct {
+	c   *Conn
+	gen uint32
+}
+
+// Ref should be taken on the event loop, e.g. in OnOpen or OnTrack, by code which keeps
+// the conn beyond the event, such as goroutines writing to it.
+func (that *Conn) Ref() Ref {
+	return Ref{c: that, gen: that.Generation()}
+}
+
+func (that Ref) Valid() bool {
+	return that.c != nil && that.c.Generation() == that.gen
+}
+
+// Conn returns the conn, or nil if it has been closed.
+func (that Ref) Conn() *Conn {
+	if !that.Valid() {
+		return nil
+	}
+	return that.c
+}
+
+func (that Ref) AsyncWrite(data []byte, cb ...iface.AsyncCallback) error {
+	if that.c == nil {
+		return errs.ErrStaleConn
+	}
+	return that.c.asyncWriteAt(that.gen, data, cb...)
+}
+
+func (that Ref) AsyncWritev(bs [][]byte, cb ...iface.AsyncCallback) error {
+	if that.c == nil {
+		return errs.ErrStaleConn
+	}
+	return that.c.asyncWritevAt(that.gen, bs, cb...)
+}
+
+// Post runs fn in the mailbox of the conn like Conn.Post, fn is dropped if the conn has
+// been closed by then.
+func (that Ref) Post(fn func() error) error {
+	if that.c == nil {
+		return errs.ErrStaleConn
+	}
+	return that.c.postAt(that.gen, func() error {
+		if !that.Valid() {
+			return nil
+		}
+		return fn()
+	})
+}
+
+// Close closes the conn in its mailbox unless it is closed already.
+func (that Ref) Close() error {
+	return that.Post(func() error {
+		return that.c.Close()
+	})
+}
+
+// recycle puts a closed conn back into the pool. It runs as a poller task, after the
+// event that closed the conn, and waits for the lock of the handlers. A conn whose async
+// writes are still running is left to the gc.
+func (that *Conn) recycle(_ iface.PollTaskArg) error {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	that.asyncLock.Lock()
+	busy := that.asyncRunning
+	that.asyncHooks = nil
+	that.asyncLock.Unlock()
+	if busy || that.Opened || that.detached != nil {
+		return nil
+	}
+	mb := &that.mailbox
+	mb.lock.Lock()
+	if mb.running {
+		// done by drain once the work queued before the close has run.
+		mb.recycle = true
+		mb.lock.Unlock()
+		return nil
+	}
+	// from now on Post drops work, see postAt.
+	that.Poller = nil
+	mb.events, mb.posts, mb.waits, mb.wg = 0, nil, 0, nil
+	mb.disarmed, mb.retired, mb.recycle = false, false, false
+	mb.lock.Unlock()
+	select {
+	case <-that.ErrChan:
+	default:
+	}
+	that.Fd = -1
+	that.Handler = nil
+	that.Hook = nil
+	that.IsUDP = false
+	that.files = nil
+	that.readPaused = false
+	that.writeWatched = false
+	that.aboveHigh = false
+	that.budget = nil
+	that.reuse = false
+	if that.ctx != nil {
+		that.ctx.Conn = nil
+		that.ctx.RawConn = nil
+		that.ctx.Proxy = nil
+		that.ctx.Reader.Reset(nil)
+		that.ctx.ReadWriter.Writer.Reset(nil)
+	}
+	connPool.Put(that)
+	return nil
+}
@@ -0,0 +1,92 @@
+package conn
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/poll"
+	"github.com/moqsien/gknet/utils/errs"
+)
+
+func newTestConn(p *poll.Poller) *Conn {
+	return &Conn{Fd: -1, ErrChan: make(chan error, 1), lock: &sync.Mutex{}, Poller: p}
+}
+
+func TestRefPost(t *testing.T) {
+	cases := []struct {
+		name string
+		// before runs between taking the Ref and Post, during runs while the post is queued.
+		before, during func(c *Conn)
+		err            error
+		ran            bool
+	}{
+		{"current", nil, nil, nil, true},
+		{"closed", func(c *Conn) { atomic.AddUint32(&c.gen, 1) }, nil, errs.ErrStaleConn, false},
+		{"recycled", func(c *Conn) { atomic.AddUint32(&c.gen, 1); c.Poller = nil }, nil, errs.ErrStaleConn, false},
+		{"closed while queued", nil, func(c *Conn) { atomic.AddUint32(&c.gen, 1) }, nil, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := newTestConn(&poll.Poller{Mode: iface.DispatchPooled})
+			ref := conn.Ref()
+			if c.before != nil {
+				c.before(conn)
+			}
+			// hold the mailbox, so the post is queued.
+			conn.mailbox.running = true
+			ran := false
+			err := ref.Post(func() error {
+				ran = true
+				return nil
+			})
+			if err != c.err {
+				t.Fatalf("Post returned %v, want %v", err, c.err)
+			}
+			if c.during != nil {
+				c.during(conn)
+			}
+			conn.drain()
+			if ran != c.ran {
+				t.Fatalf("ran %v, want %v", ran, c.ran)
+			}
+		})
+	}
+}
+
+func TestRecycleResetsMailbox(t *testing.T) {
+	cases := []struct {
+		name     string
+		running  bool
+		recycled bool
+	}{
+		{"idle", false, true},
+		{"draining", true, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := newTestConn(&poll.Poller{Mode: iface.DispatchPerConn})
+			mb := &conn.mailbox
+			mb.running = c.running
+			mb.events = 1
+			mb.posts = []func() error{func() error { return nil }}
+			mb.disarmed, mb.retired = true, true
+			if err := conn.recycle(nil); err != nil {
+				t.Fatal(err)
+			}
+			if c.recycled {
+				if conn.Poller != nil || mb.running || mb.events != 0 || mb.posts != nil || mb.disarmed || mb.retired {
+					t.Fatalf("mailbox is not reset: %+v", mb)
+				}
+				if err := conn.Post(func() error { return nil }); err != errs.ErrStaleConn {
+					t.Fatalf("Post on a recycled conn returned %v", err)
+				}
+				return
+			}
+			if conn.Poller == nil || !mb.recycle {
+				t.Fatal("a draining conn is recycled before its work is done")
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package conn
+
+import (
+	"time"
+
+	"github.com/moqsien/gknet/iface"
+)
+
+// SetReadRate limits the bytes read from the conn to rate per second, in bursts of burst
+// (rate) bytes, rate 0 removes the limit. Called in OnOpen, it overrides
+// Options.ReadRateLimit.
+func (that *Conn) SetReadRate(rate float64, burst int) {
+	that.readRate.Set(rate, burst)
+}
+
+// SetTrackRate limits the calls of OnTrack to rate per second, in bursts of burst(rate).
+func (that *Conn) SetTrackRate(rate float64, burst int) {
+	that.trackRate.Set(rate, burst)
+}
+
+// Throttled reports whether reading is stopped by the rate limits.
+func (that *Conn) Throttled() bool {
+	return that.throttled
+}
+
+// throttle accounts n bytes read and one OnTrack. Once a limit is exceeded, the fd is not
+// read until the buckets have refilled, the data waits in the socket meanwhile.
+func (that *Conn) throttle(n int) error {
+	if !that.readRate.Enabled() && !that.trackRate.Enabled() {
+		return nil
+	}
+	that.readRate.Take(float64(n))
+	that.trackRate.Take(1)
+	delay := that.readRate.Delay(1)
+	if d := that.trackRate.Delay(1); d > delay {
+		delay = d
+	}
+	if delay <= 0 || !that.Opened {
+		return nil
+	}
+	that.throttled = true
+	gen := that.Generation()
+	resume := func() error {
+		if that.Generation() != gen || !that.throttled {
+			// closed meanwhile.
+			return nil
+		}
+		that.throttled, that.throttleTimer = false, nil
+		return that.updateEvents()
+	}
+	// through the loop, which does not run the task once the engine has stopped. The conn
+	// may have been recycled when the timer fires, its poller is taken now. Recycling runs
+	// on the same loop, so the conn is still whole if the generation holds in the task.
+	poller := that.Poller
+	that.throttleTimer = time.AfterFunc(delay, func() {
+		if that.Generation() != gen {
+			return
+		}
+		poller.AddTask(func(_ iface.PollTaskArg) error {
+			if that.Generation() != gen {
+				return nil
+			}
+			return that.Post(resume)
+		}, nil)
+	})
+	return that.updateEvents()
+}
+
+func (that *Conn) stopThrottle() {
+	if that.throttleTimer != nil {
+		that.throttleTimer.Stop()
+		that.throttleTimer = nil
+	}
+	that.throttled = false
+}
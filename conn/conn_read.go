@@ -5,6 +5,9 @@ import (
 )
 
 func (that *Conn) Read(p []byte) (n int, err error) {
+	if that.detached != nil {
+		return that.detached.Read(p)
+	}
 	if that.InBuffer.IsEmpty() {
 		n = copy(p, that.Buffer)
 		that.Buffer = that.Buffer[n:]
@@ -0,0 +1,162 @@
+package conn
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/moqsien/gknet/sys"
+	"github.com/moqsien/gknet/utils/errs"
+)
+
+// maxSendfileSize limits the bytes of one sendfile call.
+const maxSendfileSize = 4 << 20
+
+// fileSend is a file region waiting for the socket, tail holds the data written after it.
+type fileSend struct {
+	fd     int
+	off    int64
+	remain int64
+	tail   []byte
+}
+
+func (that *fileSend) send(fd int) error {
+	for that.remain > 0 {
+		size := that.remain
+		if size > maxSendfileSize {
+			size = maxSendfileSize
+		}
+		n, err := sys.Sendfile(fd, that.fd, &that.off, int(size))
+		that.remain -= int64(n)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// the file is shorter than expected.
+			return io.ErrUnexpectedEOF
+		}
+	}
+	return nil
+}
+
+// SendFile sends n bytes of f starting at off with sendfile(2), ordered after the data
+// already written. Like Write, it must be called on the event loop. What the socket can
+// not take at once is sent on EPOLLOUT, f may be closed as soon as SendFile returns.
+func (that *Conn) SendFile(f *os.File, off, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	if that.detached != nil {
+		_, err := io.Copy(that.detached, io.NewSectionReader(f, off, n))
+		return err
+	}
+	if that.IsUDP || !that.Opened {
+		return syscall.EINVAL
+	}
+	if that.writeClosed {
+		return errs.ErrWriteClosed
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return err
+	}
+	that.files = append(that.files, &fileSend{fd: fd, off: off, remain: n})
+	if !that.OutBuffer.IsEmpty() || len(that.files) > 1 {
+		// EPOLLOUT is registered already.
+		return nil
+	}
+	switch err = that.sendFiles(); err {
+	case nil:
+		return nil
+	case sys.EAGAIN:
+		return that.WatchWrite(true)
+	default:
+		that.Close()
+		return err
+	}
+}
+
+// sendFiles sends the queued files while OutBuffer is empty. The tail of a finished file
+// moves into OutBuffer, which is flushed before the next file.
+func (that *Conn) sendFiles() error {
+	for len(that.files) > 0 {
+		fs := that.files[0]
+		remain := fs.remain
+		err := fs.send(that.Fd)
+		that.CountWritten(int(remain - fs.remain))
+		if err == sys.EAGAIN {
+			that.Poller.Metrics.WriteAgain()
+		}
+		if err != nil {
+			return err
+		}
+		that.files[0] = nil
+		that.files = that.files[1:]
+		sys.CloseFd(fs.fd)
+		if len(fs.tail) > 0 {
+			_, _ = that.OutBuffer.Write(fs.tail)
+			return nil
+		}
+	}
+	that.files = nil
+	return nil
+}
+
+// flushFiles is called by the write handlers when OutBuffer has been drained.
+func (that *Conn) flushFiles() error {
+	switch err := that.sendFiles(); err {
+	case nil:
+	case sys.EAGAIN:
+		return nil
+	default:
+		return err
+	}
+	if !that.OutBuffer.IsEmpty() {
+		return nil
+	}
+	if err := that.WatchWrite(false); err != nil || !that.writeClosed {
+		return err
+	}
+	// all output is gone after CloseWrite.
+	return that.shutdownWrite()
+}
+
+// queueTail keeps data written while files are pending, it reports whether data was queued.
+func (that *Conn) queueTail(data ...[]byte) bool {
+	if len(that.files) == 0 {
+		return false
+	}
+	fs := that.files[len(that.files)-1]
+	for _, b := range data {
+		fs.tail = append(fs.tail, b...)
+	}
+	return true
+}
+
+// writeFilesTo copies the pending files and their tails to w, it is used by Detach.
+func (that *Conn) writeFilesTo(w io.Writer) (err error) {
+	for _, fs := range that.files {
+		if err == nil {
+			f := os.NewFile(uintptr(fs.fd), "")
+			_, err = io.Copy(w, io.NewSectionReader(f, fs.off, fs.remain))
+			f.Close()
+		} else {
+			sys.CloseFd(fs.fd)
+		}
+		if err == nil && len(fs.tail) > 0 {
+			_, err = w.Write(fs.tail)
+		}
+	}
+	that.files = nil
+	return
+}
+
+func (that *Conn) releaseFiles() {
+	for _, fs := range that.files {
+		sys.CloseFd(fs.fd)
+	}
+	that.files = nil
+}
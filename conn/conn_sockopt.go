@@ -0,0 +1,117 @@
+package conn
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/sys"
+	"github.com/moqsien/gknet/utils/errs"
+)
+
+// sockopt calls set with the fd of the conn, or with that of the detached conn.
+func (that *Conn) sockopt(set func(fd int) error) error {
+	if that.detached == nil {
+		if that.IsUDP {
+			return errs.ErrUnsupportedOp
+		}
+		return set(that.Fd)
+	}
+	sc, ok := that.detached.(syscall.Conn)
+	if !ok {
+		return errs.ErrUnsupportedOp
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if cerr := rc.Control(func(fd uintptr) { err = set(int(fd)) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+func (that *Conn) SetNoDelay(on bool) error {
+	return that.sockopt(func(fd int) error { return sys.SetNoDelay(fd, on) })
+}
+
+// SetCork holds back partial frames until it is turned off, which sends them at once.
+func (that *Conn) SetCork(on bool) error {
+	return that.sockopt(func(fd int) error { return sys.SetCork(fd, on) })
+}
+
+func (that *Conn) SetUserTimeout(timeout time.Duration) error {
+	return that.sockopt(func(fd int) error { return sys.SetUserTimeout(fd, timeout) })
+}
+
+// SetKeepAlivePeriod enables keepalive with the probes sent every period.
+func (that *Conn) SetKeepAlivePeriod(period time.Duration) error {
+	return that.sockopt(func(fd int) error { return sys.SetKeepAlive(fd, period) })
+}
+
+func (that *Conn) SetKeepAliveCount(n int) error {
+	return that.sockopt(func(fd int) error { return sys.SetKeepAliveCount(fd, n) })
+}
+
+// SetLinger works as net.TCPConn.SetLinger: negative secs turn it off, 0 closes with a RST.
+func (that *Conn) SetLinger(secs int) error {
+	return that.sockopt(func(fd int) error { return sys.SetLinger(fd, secs) })
+}
+
+func (that *Conn) SetTOS(tos int) error {
+	return that.sockopt(func(fd int) error { return sys.SetTOS(fd, tos) })
+}
+
+func (that *Conn) SetQuickAck(on bool) error {
+	return that.sockopt(func(fd int) error { return sys.SetQuickAck(fd, on) })
+}
+
+func (that *Conn) SetNotSentLowat(bytes int) error {
+	return that.sockopt(func(fd int) error { return sys.SetNotSentLowat(fd, bytes) })
+}
+
+// setSocketOptions applies o to a TCP conn, the options the platform lacks are skipped.
+func (that *Conn) setSocketOptions(o *iface.SocketOptions) {
+	if o == nil {
+		return
+	}
+	if _, ok := that.AddrLocal.(*net.TCPAddr); !ok {
+		return
+	}
+	var setters []func() error
+	if o.NoDelay {
+		setters = append(setters, func() error { return that.SetNoDelay(true) })
+	}
+	if o.Cork {
+		setters = append(setters, func() error { return that.SetCork(true) })
+	}
+	if o.UserTimeout > 0 {
+		setters = append(setters, func() error { return that.SetUserTimeout(o.UserTimeout) })
+	}
+	if o.KeepAliveCount > 0 {
+		setters = append(setters, func() error { return that.SetKeepAliveCount(o.KeepAliveCount) })
+	}
+	if o.Linger != 0 {
+		secs := 0
+		if o.Linger > 0 {
+			secs = int((o.Linger + time.Second - 1) / time.Second)
+		}
+		setters = append(setters, func() error { return that.SetLinger(secs) })
+	}
+	if o.TOS != 0 {
+		setters = append(setters, func() error { return that.SetTOS(o.TOS) })
+	}
+	if o.QuickAck {
+		setters = append(setters, func() error { return that.SetQuickAck(true) })
+	}
+	if o.NotSentLowat > 0 {
+		setters = append(setters, func() error { return that.SetNotSentLowat(o.NotSentLowat) })
+	}
+	for _, set := range setters {
+		if err := set(); err != nil && !errors.Is(err, errs.ErrUnsupportedSockOpt) {
+			that.logger().Warn("failed to set a socket option", "err", err)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package conn
+
+import (
+	"github.com/moqsien/gknet/iface"
+)
+
+func noTrace(error) {}
+
+// TraceStart starts a stage of the trace of the conn, the returned function ends it.
+func (that *Conn) TraceStart(stage iface.TraceStage) func(error) {
+	if that.trace == nil {
+		return noTrace
+	}
+	return that.trace.Start(stage)
+}
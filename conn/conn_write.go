@@ -1,6 +1,8 @@
 package conn
 
 import (
+	"net"
+
 	"github.com/moqsien/gknet/iface"
 	"github.com/moqsien/gknet/sys"
 	"github.com/moqsien/gknet/utils/errs"
@@ -10,23 +12,47 @@ func (that *Conn) writeUdp(data []byte) error {
 	return sys.WriteUdp(that.Fd, data, 0, that.Sock)
 }
 
+// queue keeps data until the fd is writable, behind the pending files if any.
+func (that *Conn) queue(data ...[]byte) error {
+	var size int
+	for _, b := range data {
+		size += len(b)
+	}
+	if err := that.reserve(size); err != nil {
+		return err
+	}
+	if !that.queueTail(data...) {
+		_, _ = that.OutBuffer.Writev(data)
+	}
+	that.account()
+	return that.checkWaterMarks()
+}
+
 func (that *Conn) write(data []byte) (n int, err error) {
+	if that.writeClosed {
+		return 0, errs.ErrWriteClosed
+	}
 	n = len(data)
-	if !that.OutBuffer.IsEmpty() {
-		return that.OutBuffer.Write(data)
+	if len(that.files) > 0 || !that.OutBuffer.IsEmpty() {
+		if err = that.queue(data); err == errs.ErrWriteOverflow {
+			n = -1
+		}
+		return
 	}
 	var sent int
 	if sent, err = sys.Write(that.Fd, data); err != nil {
-		if err == sys.EAGAIN {
-			_, _ = that.OutBuffer.Write(data)
-			err = that.Poller.ModReadWrite(that)
-			return
+		if err != sys.EAGAIN {
+			return -1, that.Close()
 		}
-		return -1, that.Close()
+		that.Poller.Metrics.WriteAgain()
+		sent = 0
 	}
+	that.CountWritten(sent)
 	if sent < n {
-		_, _ = that.OutBuffer.Write(data[sent:])
-		err = that.Poller.ModReadWrite(that)
+		if err = that.queue(data[sent:]); err == errs.ErrWriteOverflow {
+			return -1, err
+		}
+		err = that.WatchWrite(true)
 	}
 	return
 }
@@ -36,13 +62,17 @@ func (that *Conn) writeOnOpen(data []byte) (n int, err error) {
 }
 
 func (that *Conn) asyncWrite(arg iface.PollTaskArg) (err error) {
-	if !that.Opened {
+	if !that.Opened && that.detached == nil {
 		return
 	}
 
 	hook, ok := arg.(*iface.AsyncWriteHook)
 	if ok {
-		_, err = that.write(hook.Data)
+		if that.detached != nil {
+			_, err = that.detached.Write(hook.Data)
+		} else {
+			_, err = that.write(hook.Data)
+		}
 		if hook.Go != nil {
 			hook.Go(that)
 		}
@@ -51,6 +81,9 @@ func (that *Conn) asyncWrite(arg iface.PollTaskArg) (err error) {
 }
 
 func (that *Conn) Write(p []byte) (int, error) {
+	if that.detached != nil {
+		return that.detached.Write(p)
+	}
 	if that.IsUDP {
 		if err := that.writeUdp(p); err != nil {
 			return 0, err
@@ -61,6 +94,14 @@ func (that *Conn) Write(p []byte) (int, error) {
 }
 
 func (that *Conn) AsyncWrite(data []byte, cb ...iface.AsyncCallback) error {
+	return that.asyncWriteAt(that.Generation(), data, cb...)
+}
+
+// asyncWriteAt writes data if the conn is still at generation gen when the write runs.
+func (that *Conn) asyncWriteAt(gen uint32, data []byte, cb ...iface.AsyncCallback) error {
+	if that.Generation() != gen {
+		return errs.ErrStaleConn
+	}
 	var callback iface.AsyncCallback
 	if len(cb) > 0 {
 		callback = cb[0]
@@ -74,32 +115,97 @@ func (that *Conn) AsyncWrite(data []byte, cb ...iface.AsyncCallback) error {
 		}()
 		return that.writeUdp(data)
 	}
+	if that.detached != nil {
+		_, err := that.detached.Write(data)
+		if callback != nil {
+			callback(that)
+		}
+		return err
+	}
 
-	return that.Poller.AddTask(that.asyncWrite, &iface.AsyncWriteHook{
+	return that.queueAsync(gen, &iface.AsyncWriteHook{
 		Go:   callback,
 		Data: data,
 	})
 }
 
+// asyncOp is an async write queued at generation gen of the conn.
+type asyncOp struct {
+	hook iface.PollTaskArg
+	gen  uint32
+}
+
+// queueAsync queues an async write. The queue is drained in the mailbox of the conn, so
+// async writes reach the socket in the order they were made and never run at the same
+// time as the handlers. Writes of an earlier generation are dropped.
+func (that *Conn) queueAsync(gen uint32, hook iface.PollTaskArg) error {
+	that.asyncLock.Lock()
+	that.asyncHooks = append(that.asyncHooks, asyncOp{hook: hook, gen: gen})
+	if that.asyncRunning {
+		that.asyncLock.Unlock()
+		return nil
+	}
+	that.asyncRunning = true
+	that.asyncLock.Unlock()
+	err := that.Post(func() error { return that.runAsyncWrites(nil) })
+	if err != nil {
+		that.asyncLock.Lock()
+		that.asyncHooks, that.asyncRunning = nil, false
+		that.asyncLock.Unlock()
+	}
+	return err
+}
+
+func (that *Conn) runAsyncWrites(_ iface.PollTaskArg) (err error) {
+	for {
+		that.asyncLock.Lock()
+		hooks := that.asyncHooks
+		that.asyncHooks = nil
+		if len(hooks) == 0 {
+			that.asyncRunning = false
+			that.asyncLock.Unlock()
+			return
+		}
+		that.asyncLock.Unlock()
+		for _, op := range hooks {
+			if op.gen != that.Generation() {
+				continue
+			}
+			switch op.hook.(type) {
+			case *iface.AsyncWriteHook:
+				err = that.asyncWrite(op.hook)
+			case *iface.AsyncWritevHook:
+				err = that.asyncWritev(op.hook)
+			case closeWriteOp:
+				err = that.CloseWrite()
+			}
+		}
+	}
+}
+
 func (that *Conn) writev(data [][]byte) (n int, err error) {
+	if that.writeClosed {
+		return 0, errs.ErrWriteClosed
+	}
 	for _, b := range data {
 		n += len(b)
 	}
-
-	if !that.OutBuffer.IsEmpty() {
-		_, _ = that.OutBuffer.Writev(data)
+	if len(that.files) > 0 || !that.OutBuffer.IsEmpty() {
+		if err = that.queue(data...); err == errs.ErrWriteOverflow {
+			n = -1
+		}
 		return
 	}
 
 	var sent int
 	if sent, err = sys.Writev(that.Fd, data); err != nil {
-		if err == sys.EAGAIN {
-			_, _ = that.OutBuffer.Writev(data)
-			err = that.Poller.ModReadWrite(that)
-			return
+		if err != sys.EAGAIN {
+			return -1, that.Close()
 		}
-		return -1, that.Close()
+		that.Poller.Metrics.WriteAgain()
+		sent = 0
 	}
+	that.CountWritten(sent)
 
 	if sent < n {
 		var pos int
@@ -112,19 +218,25 @@ func (that *Conn) writev(data [][]byte) (n int, err error) {
 			}
 			sent -= bn
 		}
-		_, _ = that.OutBuffer.Writev(data[pos:])
-		err = that.Poller.ModReadWrite(that)
+		if err = that.queue(data[pos:]...); err == errs.ErrWriteOverflow {
+			return -1, err
+		}
+		err = that.WatchWrite(true)
 	}
 	return
 }
 
 func (that *Conn) asyncWritev(arg iface.PollTaskArg) (err error) {
-	if !that.Opened {
+	if !that.Opened && that.detached == nil {
 		return nil
 	}
 
 	hook := arg.(*iface.AsyncWritevHook)
-	_, err = that.writev(hook.Data)
+	if that.detached != nil {
+		_, err = that.Writev(hook.Data)
+	} else {
+		_, err = that.writev(hook.Data)
+	}
 	if hook.Go != nil {
 		err = hook.Go(that)
 	}
@@ -135,10 +247,22 @@ func (that *Conn) Writev(bs [][]byte) (int, error) {
 	if that.IsUDP {
 		return 0, errs.ErrUnsupportedOp
 	}
+	if that.detached != nil {
+		bufs := net.Buffers(bs)
+		n, err := bufs.WriteTo(that.detached)
+		return int(n), err
+	}
 	return that.writev(bs)
 }
 
 func (that *Conn) AsyncWritev(bs [][]byte, cb ...iface.AsyncCallback) error {
+	return that.asyncWritevAt(that.Generation(), bs, cb...)
+}
+
+func (that *Conn) asyncWritevAt(gen uint32, bs [][]byte, cb ...iface.AsyncCallback) error {
+	if that.Generation() != gen {
+		return errs.ErrStaleConn
+	}
 	var callback iface.AsyncCallback
 	if len(cb) > 0 {
 		callback = cb[0]
@@ -146,5 +270,12 @@ func (that *Conn) AsyncWritev(bs [][]byte, cb ...iface.AsyncCallback) error {
 	if that.IsUDP {
 		return errs.ErrUnsupportedOp
 	}
-	return that.Poller.AddTask(that.asyncWritev, &iface.AsyncWritevHook{Go: callback, Data: bs})
+	if that.detached != nil {
+		_, err := that.Writev(bs)
+		if callback != nil {
+			callback(that)
+		}
+		return err
+	}
+	return that.queueAsync(gen, &iface.AsyncWritevHook{Go: callback, Data: bs})
 }
@@ -0,0 +1,194 @@
+package eloop
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/moqsien/gknet/conn"
+	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/socket"
+	"github.com/moqsien/gknet/sys"
+	"github.com/moqsien/gknet/utils/errs"
+)
+
+// DialFunc gets the conn opened by Dial, or the error which failed it.
+type DialFunc func(c *conn.Conn, err error)
+
+// Dial connects to address and registers the conn on this loop, its events are handled
+// by handler. Dial does not block: the connection, and the lookup of a host name, go on
+// in the background, then done is called with the opened conn or the error. done runs on
+// the loop or in the handlers of the new conn, callers Post the work of their own conns.
+// It is not called when Dial returns an error. A timeout of 0 means no timeout. The conn
+// is always plaintext and uses the ConnAsyncWriteAdapter.
+func (that *Eloop) Dial(network, address string, handler iface.IEventHandler, timeout time.Duration, done DialFunc) error {
+	addr, literal, err := socket.ParseAddr(network, address)
+	if err != nil {
+		return err
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if literal {
+		return that.connect(addr, handler, deadline, done)
+	}
+	go func() {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if !deadline.IsZero() {
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+		}
+		addr, err := socket.LookupAddr(ctx, network, address)
+		cancel()
+		// nothing runs once the loop is stopped.
+		_ = that.Poller.AddTask(func(_ iface.PollTaskArg) error {
+			if err == nil {
+				err = that.connect(addr, handler, deadline, done)
+			}
+			if err != nil {
+				done(nil, err)
+			}
+			return nil
+		}, nil)
+	}()
+	return nil
+}
+
+// dialer is the conn.FdHook of a dialed conn until its connection is established.
+type dialer struct {
+	loop  *Eloop
+	c     *conn.Conn
+	done  DialFunc
+	timer *time.Timer
+	over  bool
+	lock  sync.Mutex
+}
+
+// connect starts a nonblocking connection to addr, the fd is polled until it is done.
+func (that *Eloop) connect(addr net.Addr, handler iface.IEventHandler, deadline time.Time, done DialFunc) error {
+	var timeout time.Duration
+	if !deadline.IsZero() {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return errs.ErrDialTimeout
+		}
+	}
+	fd, err := socket.Connect(addr)
+	if err != nil {
+		return err
+	}
+	opts := that.Engine.GetOptions()
+	if _, ok := addr.(*net.TCPAddr); ok {
+		sys.SetKeepAlive(fd, opts.ConnKeepAlive)
+	}
+	c := conn.NewTCPConn(fd)
+	c.SetConn(&conn.ConnOpts{
+		Poller:            that.Poller,
+		RemoteAddr:        addr,
+		Handler:           handler,
+		WriteBufferCap:    opts.WriteBuffer,
+		WritevChunkSize:   opts.WritevChunkSize,
+		SocketWriteBuffer: opts.SocketWriteBuffer,
+		SocketReadBuffer:  opts.SocketReadBuffer,
+		HighWaterMark:     opts.WriteHighWaterMark,
+		LowWaterMark:      opts.WriteLowWaterMark,
+		WriteBufferLimit:  opts.WriteBufferLimit,
+		Budget:            that.Engine.GetBudget(),
+		InBufferLimit:     opts.ConnInBufferLimit,
+		Reuse:             opts.ReuseConns,
+		Socket:            &opts.Socket,
+	})
+	d := &dialer{loop: that, c: c, done: done}
+	// the events may be handled before the timer is set.
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	c.Hook = d
+	that.addConn(c)
+	if err = c.Poller.AddRead(c); err == nil {
+		// errors and hangups are reported as readable, see OnReadable.
+		err = c.WatchWrite(true)
+	}
+	if err != nil {
+		c.Hook = nil
+		that.dropConn(fd)
+		c.Poller.RemoveFd(c)
+		sys.CloseFd(fd)
+		return err
+	}
+	if timeout > 0 {
+		d.timer = time.AfterFunc(timeout, func() {
+			that.Poller.AddTask(d.expire, nil)
+		})
+	}
+	return nil
+}
+
+func (that *dialer) expire(_ iface.PollTaskArg) error {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	if !that.over {
+		that.fail(errs.ErrDialTimeout)
+	}
+	return nil
+}
+
+// fail drops a conn which has not been opened.
+func (that *dialer) fail(err error) {
+	that.over = true
+	if that.timer != nil {
+		that.timer.Stop()
+	}
+	c := that.c
+	c.Hook = nil
+	that.loop.dropConn(c.Fd)
+	c.Poller.RemoveFd(c)
+	sys.CloseFd(c.Fd)
+	c.Finish(err)
+	that.done(nil, err)
+}
+
+// OnWritable opens the conn once the connection is established.
+func (that *dialer) OnWritable(c *conn.Conn) error {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	if that.over {
+		return nil
+	}
+	ok, err := sys.Connected(c.Fd)
+	if err == nil && ok {
+		c.AddrLocal, err = socket.LocalAddr(c.Fd)
+	}
+	if err == nil && ok {
+		err = c.WatchWrite(false)
+	}
+	if err == nil && ok {
+		err = c.InitContext(nil, iface.ConnAsyncWriteAdapter)
+	}
+	if err != nil {
+		that.fail(err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	that.over = true
+	if that.timer != nil {
+		that.timer.Stop()
+	}
+	c.Hook = nil
+	that.loop.AddConnCount(1)
+	if err = c.Open(); err == nil && !c.Opened {
+		err = errs.ErrConnNotOpened
+	}
+	if err != nil {
+		that.done(nil, err)
+		return err
+	}
+	that.done(c, nil)
+	return nil
+}
+
+// OnReadable gets the errors and hangups of the connection.
+func (that *dialer) OnReadable(c *conn.Conn) error {
+	return that.OnWritable(c)
+}
@@ -7,9 +7,6 @@ import (
 	"errors"
 	"sync"
 
-	"github.com/moqsien/processes/logger"
-
-	"github.com/moqsien/gknet/conn"
 	"github.com/moqsien/gknet/sys"
 )
 
@@ -24,12 +21,12 @@ func (that *EloopEventAccept) Callback(fd int, events uint32) error {
 }
 
 func (that *EloopEventAccept) AsyncCallback(fd int, events uint32) (errChan chan error) {
-	logger.Println("Not implemented!")
+	that.Eloop.Poller.Logger.Warn("async callbacks are not implemented for accepting", "fd", fd)
 	return
 }
 
 func (that *EloopEventAccept) AsyncWaitCallback(fd int, events uint32, wg *sync.WaitGroup) (errChan chan error) {
-	logger.Println("Not implemented!")
+	that.Eloop.Poller.Logger.Warn("async callbacks are not implemented for accepting", "fd", fd)
 	return
 }
 
@@ -46,8 +43,8 @@ func (that *EloopEventHandleConn) Callback(fd int, events uint32) error {
 		sys.Read(fd, pollEvBufffer)
 		return nil
 	}
-	if connection, found := that.Eloop.ConnList[fd]; found {
-		return sys.HandleEvents(events, connection.(*conn.Conn))
+	if c, found := that.Eloop.getConn(fd); found {
+		return sys.HandleEvents(events, c)
 	}
 	return errors.New("Connection not found!")
 }
@@ -57,12 +54,11 @@ func (that *EloopEventHandleConn) AsyncCallback(fd int, events uint32) (errChan
 		sys.Read(fd, pollEvBufffer)
 		return
 	}
-	if connection, found := that.Eloop.ConnList[fd]; found {
-		c := connection.(*conn.Conn)
-		sys.AsyncHandleEvents(events, c)
+	if c, found := that.Eloop.getConn(fd); found {
+		c.Dispatch(events, nil)
 		return c.ErrChan
 	}
-	logger.Warningf("Fd not found: %d", fd)
+	that.Eloop.Poller.Logger.Warn("fd not found", "fd", fd)
 	return
 }
 
@@ -71,11 +67,11 @@ func (that *EloopEventHandleConn) AsyncWaitCallback(fd int, events uint32, wg *s
 		sys.Read(fd, pollEvBufffer)
 		return
 	}
-	if connection, found := that.Eloop.ConnList[fd]; found {
-		c := connection.(*conn.Conn)
-		sys.AsyncHandleEventsAndWait(events, c, wg)
+	if c, found := that.Eloop.getConn(fd); found {
+		// the events of one conn are handled in order by its mailbox.
+		c.Dispatch(events, wg)
 		return c.ErrChan
 	}
-	logger.Warningf("Fd not found: %d", fd)
+	that.Eloop.Poller.Logger.Warn("fd not found", "fd", fd)
 	return
 }
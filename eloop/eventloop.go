@@ -6,42 +6,79 @@ package eloop
 import (
 	"net"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"syscall"
 
 	"github.com/moqsien/gknet/conn"
 	"github.com/moqsien/gknet/iface"
 	"github.com/moqsien/gknet/poll"
+	"github.com/moqsien/gknet/proxyproto"
 	"github.com/moqsien/gknet/socket"
 	"github.com/moqsien/gknet/sys"
+	"github.com/moqsien/gknet/utils/cidr"
 	"github.com/moqsien/gknet/utils/errs"
 )
 
 type Eloop struct {
-	Listener  iface.IListener  // net listener
-	Index     int              // index of worker loop
-	Poller    *poll.Poller     // poller
-	Engine    iface.IEngine    // engine
-	Balancer  iface.IBalancer  // balancer
-	ConnCount int32            // number of connections
-	ConnList  map[int]net.Conn // list of connections
+	Listener     iface.IListener  // net listener
+	Index        int              // index of worker loop
+	Poller       *poll.Poller     // poller
+	Engine       iface.IEngine    // engine
+	Balancer     iface.IBalancer  // balancer
+	ConnCount    int32            // number of connections
+	ConnList     map[int]net.Conn // list of connections
+	connLock     sync.RWMutex     // ConnList is changed by the workers closing conns
+	ProxyTrusted []*net.IPNet     // sources allowed to send PROXY protocol headers
 }
 
 func (that *Eloop) RegisterConn(arg iface.PollTaskArg) error {
 	c := arg.(*conn.Conn)
+	end := c.TraceStart(iface.TraceRegister)
 	var err error
+	switch mode := that.Engine.GetOptions().ProxyProtocol; {
+	case mode == iface.ProxyProtocolOff:
+	case cidr.Contains(that.ProxyTrusted, cidr.IP(c.AddrRemote)):
+		err = that.readProxyHeader(c)
+		end(err)
+		if err != nil {
+			c.Finish(err)
+		}
+		return err
+	case mode == iface.ProxyProtocolRequired:
+		end(nil)
+		c.Finish(nil)
+		return syscall.Close(c.Fd)
+	}
 	if err = c.Poller.AddRead(c); err != nil {
 		_ = syscall.Close(c.Fd)
+		end(err)
+		c.Finish(err)
 		return err
 	}
+	end(nil)
+	return that.openConn(c, nil)
+}
+
+// openConn prepares the context of a registered conn and opens it.
+func (that *Eloop) openConn(c *conn.Conn, header *proxyproto.Header) (err error) {
 	// tls handshaking and context preparation.
 	err = c.InitContext(that.Engine.GetOptions().TLSConfig,
 		that.Engine.GetOptions().ConnAdapter,
 		that.Engine.GetOptions().ConnAsyncCallback)
-	that.ConnList[c.Fd] = c
+	if err != nil {
+		// never opened, so Close would leave the fd to us.
+		that.dropConn(c.Fd)
+		_ = c.Poller.RemoveFd(c)
+		_ = sys.CloseFd(c.Fd)
+		c.Finish(err)
+		return err
+	}
+	c.Ctx.Proxy = header
+	that.addConn(c)
 	err = c.Open()
 	if err == nil {
-		that.ConnCount = that.AddConnCount(1)
+		that.AddConnCount(1)
 	}
 	return err
 }
@@ -53,8 +90,7 @@ func (that *Eloop) chooseEloop(addrLocal net.Addr) iface.IELoop {
 	return that.Balancer.Next(addrLocal)
 }
 
-func (that *Eloop) packTcpConn(nfd int, sock syscall.Sockaddr) (c *conn.Conn) {
-	remoteAddr := socket.SockaddrToTCPOrUnixAddr(sock)
+func (that *Eloop) packTcpConn(nfd int, sock syscall.Sockaddr, remoteAddr net.Addr, trace iface.ConnTrace, release func()) (c *conn.Conn) {
 	c = conn.NewTCPConn(nfd)
 	c.SetConn(&conn.ConnOpts{
 		SockAddr:          sock,
@@ -65,20 +101,60 @@ func (that *Eloop) packTcpConn(nfd int, sock syscall.Sockaddr) (c *conn.Conn) {
 		WritevChunkSize:   that.Engine.GetOptions().WritevChunkSize,
 		SocketWriteBuffer: that.Engine.GetOptions().SocketWriteBuffer,
 		SocketReadBuffer:  that.Engine.GetOptions().SocketReadBuffer,
+		HighWaterMark:     that.Engine.GetOptions().WriteHighWaterMark,
+		LowWaterMark:      that.Engine.GetOptions().WriteLowWaterMark,
+		WriteBufferLimit:  that.Engine.GetOptions().WriteBufferLimit,
+		Budget:            that.Engine.GetBudget(),
+		InBufferLimit:     that.Engine.GetOptions().ConnInBufferLimit,
+		Reuse:             that.Engine.GetOptions().ReuseConns,
+		Trace:             trace,
+		Release:           release,
+		ReadRate:          that.Engine.GetOptions().ReadRateLimit,
+		ReadBurst:         that.Engine.GetOptions().ReadRateBurst,
+		TrackRate:         that.Engine.GetOptions().TrackRateLimit,
+		TrackBurst:        that.Engine.GetOptions().TrackRateBurst,
+		Socket:            &that.Engine.GetOptions().Socket,
 	})
 	loop := that.chooseEloop(c.AddrLocal).(*Eloop)
 	c.Poller = loop.Poller
-	that.Poller.AddPriorTask(loop.RegisterConn, c)
+	// registered by the loop of the conn, so no event of the conn is handled before OnOpen.
+	loop.Poller.AddPriorTask(loop.RegisterConn, c)
 	return
 }
 
 func (that *Eloop) Accept(_ int, _ uint32) error {
 	nfd, sock, err := sys.Accept(that.Listener.GetFd(), that.Engine.GetOptions().ConnKeepAlive)
 	if err != nil {
+		that.Poller.Metrics.AcceptError()
 		return errs.ErrAcceptSocket
 	}
-	c := that.packTcpConn(nfd, sock)
+	that.Poller.Metrics.Accept()
+	if b := that.Engine.GetBudget(); b.Exhausted() {
+		// no room for the buffers of another conn.
+		b.Reject()
+		return sys.CloseFd(nfd)
+	}
+	remoteAddr := socket.SockaddrToTCPOrUnixAddr(sock)
+	var release func()
+	if a := that.Engine.GetOptions().Admission; a != nil {
+		if release, err = a.Admit(remoteAddr); err != nil {
+			that.Poller.Metrics.AcceptRejected()
+			if a.RejectWithReset() {
+				_ = sys.SetLinger(nfd, 0)
+			}
+			return sys.CloseFd(nfd)
+		}
+	}
+	var trace iface.ConnTrace
+	end := func(error) {}
+	if t := that.Engine.GetOptions().Tracer; t != nil {
+		trace = t.TraceConn(that.Listener.Addr(), remoteAddr)
+		end = trace.Start(iface.TraceAccept)
+	}
+	c := that.packTcpConn(nfd, sock, remoteAddr, trace, release)
+	// c may be registered and even closed on its loop from here on.
 	err = that.Engine.GetHandler().OnAccept(c)
+	end(err)
 	return err
 }
 
@@ -100,6 +176,7 @@ func (that *Eloop) StartAsSubLoop(l bool) {
 }
 
 func (that *Eloop) AddConnCount(i int32) int32 {
+	that.Poller.Metrics.Conns(int(i))
 	return atomic.AddInt32(&that.ConnCount, i)
 }
 
@@ -107,21 +184,110 @@ func (that *Eloop) GetConnCount() int32 {
 	return atomic.LoadInt32(&that.ConnCount)
 }
 
-func (that *Eloop) RemoveConn(fd int) {
+func (that *Eloop) addConn(c *conn.Conn) {
+	that.connLock.Lock()
+	that.ConnList[c.Fd] = c
+	that.connLock.Unlock()
+}
+
+func (that *Eloop) getConn(fd int) (*conn.Conn, bool) {
+	that.connLock.RLock()
+	c, ok := that.ConnList[fd]
+	that.connLock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return c.(*conn.Conn), true
+}
+
+// dropConn removes a conn which has not been counted.
+func (that *Eloop) dropConn(fd int) {
+	that.connLock.Lock()
 	delete(that.ConnList, fd)
-	that.ConnCount = that.AddConnCount(-1)
+	that.connLock.Unlock()
+}
+
+func (that *Eloop) RemoveConn(fd int) {
+	that.dropConn(fd)
+	that.AddConnCount(-1)
 }
 
 func (that *Eloop) CloseAllConn() {
-	for _, c := range that.ConnList {
+	for _, c := range that.conns() {
 		c.Close()
 	}
 }
 
+// GetConnList returns a copy of ConnList.
 func (that *Eloop) GetConnList() map[int]net.Conn {
-	return that.ConnList
+	that.connLock.RLock()
+	defer that.connLock.RUnlock()
+	conns := make(map[int]net.Conn, len(that.ConnList))
+	for fd, c := range that.ConnList {
+		conns[fd] = c
+	}
+	return conns
+}
+
+func (that *Eloop) conns() []*conn.Conn {
+	that.connLock.RLock()
+	conns := make([]*conn.Conn, 0, len(that.ConnList))
+	for _, c := range that.ConnList {
+		conns = append(conns, c.(*conn.Conn))
+	}
+	that.connLock.RUnlock()
+	return conns
+}
+
+// Connections returns snapshots of the open conns of the loop.
+func (that *Eloop) Connections() []iface.ConnInfo {
+	conns := that.conns()
+	infos := make([]iface.ConnInfo, 0, len(conns))
+	for _, c := range conns {
+		if info, ok := c.Info(); ok {
+			info.Loop = that.Index
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// CloseConn closes the conn with the id on its own goroutine, it reports whether the conn
+// was found.
+func (that *Eloop) CloseConn(id uint64) bool {
+	for _, c := range that.conns() {
+		if c.ID() != id {
+			continue
+		}
+		c := c
+		c.Post(func() error {
+			if c.ID() != id {
+				// closed meanwhile.
+				return nil
+			}
+			return c.Close()
+		})
+		return true
+	}
+	return false
 }
 
 func (that *Eloop) GetPoller() iface.IPoller {
 	return that.Poller
 }
+
+// SampleTCPInfo records the RTTs of the conns of the loop in its metrics.
+func (that *Eloop) SampleTCPInfo() {
+	that.connLock.RLock()
+	fds := make([]int, 0, len(that.ConnList))
+	for fd := range that.ConnList {
+		fds = append(fds, fd)
+	}
+	that.connLock.RUnlock()
+	for _, fd := range fds {
+		// not a TCP socket if it fails.
+		if info, err := sys.GetTCPInfo(fd); err == nil && info.RTT > 0 {
+			that.Poller.Metrics.ObserveRTT(info.RTT)
+		}
+	}
+}
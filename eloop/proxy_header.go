@@ -0,0 +1,129 @@
+package eloop
+
+import (
+	"sync"
+	"time"
+
+	"github.com/moqsien/gknet/conn"
+	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/proxyproto"
+	"github.com/moqsien/gknet/sys"
+)
+
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+// proxyHeaderReader is the conn.FdHook of a conn until its PROXY protocol header is read.
+type proxyHeaderReader struct {
+	loop     *Eloop
+	c        *conn.Conn
+	required bool
+	buf      []byte
+	timer    *time.Timer
+	done     bool
+	lock     sync.Mutex
+}
+
+// readProxyHeader registers c and opens it once the header has been read.
+func (that *Eloop) readProxyHeader(c *conn.Conn) error {
+	opts := that.Engine.GetOptions()
+	r := &proxyHeaderReader{
+		loop:     that,
+		c:        c,
+		required: opts.ProxyProtocol == iface.ProxyProtocolRequired,
+	}
+	c.Hook = r
+	that.addConn(c)
+	if err := c.Poller.AddRead(c); err != nil {
+		that.dropConn(c.Fd)
+		sys.CloseFd(c.Fd)
+		return err
+	}
+	timeout := opts.ProxyProtocolTimeout
+	if timeout <= 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	r.lock.Lock()
+	r.timer = time.AfterFunc(timeout, func() {
+		that.Poller.AddTask(r.expire, nil)
+	})
+	r.lock.Unlock()
+	return nil
+}
+
+func (that *proxyHeaderReader) expire(_ iface.PollTaskArg) error {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	if !that.done {
+		that.fail()
+	}
+	return nil
+}
+
+// fail drops a conn which has not been opened.
+func (that *proxyHeaderReader) fail() {
+	that.done = true
+	that.timer.Stop()
+	c := that.c
+	c.Hook = nil
+	that.loop.dropConn(c.Fd)
+	c.Poller.RemoveFd(c)
+	sys.CloseFd(c.Fd)
+	c.Finish(nil)
+}
+
+func (that *proxyHeaderReader) OnReadable(c *conn.Conn) error {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	if that.done {
+		return nil
+	}
+	buf := c.GetBufferFromPool()
+	n, err := sys.Read(c.Fd, buf)
+	if err == sys.EAGAIN {
+		c.PutBufferToPool(buf)
+		c.Poller.Metrics.ReadAgain()
+		return nil
+	}
+	c.CountRead(n)
+	if err != nil || n == 0 {
+		c.PutBufferToPool(buf)
+		that.fail()
+		return nil
+	}
+	that.buf = append(that.buf, buf[:n]...)
+	c.PutBufferToPool(buf)
+
+	header, size, err := proxyproto.Parse(that.buf)
+	switch {
+	case err == proxyproto.ErrIncomplete:
+		return nil
+	case err == proxyproto.ErrNoHeader && !that.required:
+	case err != nil:
+		that.fail()
+		return nil
+	}
+	that.done = true
+	that.timer.Stop()
+	c.Hook = nil
+	if header != nil && !header.Local && header.Source != nil {
+		c.AddrRemote, c.AddrLocal = header.Source, header.Destination
+	}
+	rest := that.buf[size:]
+	if len(rest) > 0 {
+		_, _ = c.InBuffer.Write(rest)
+	}
+	that.buf = nil
+	if err = that.loop.openConn(c, header); err != nil || !c.Opened {
+		return err
+	}
+	if len(rest) > 0 {
+		// the data sent along with the header.
+		return c.Handler.OnTrack(c.Ctx)
+	}
+	return nil
+}
+
+// OnWritable gets the errors and hangups of the conn, which reading reports.
+func (that *proxyHeaderReader) OnWritable(c *conn.Conn) error {
+	return that.OnReadable(c)
+}
@@ -6,29 +6,45 @@ package engine
 import (
 	"net"
 	"runtime"
+	"sort"
 	"sync"
+	"time"
 
-	"github.com/moqsien/processes/logger"
 	"github.com/panjf2000/ants/v2"
 
 	"github.com/moqsien/gknet/balancer"
 	"github.com/moqsien/gknet/eloop"
 	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/logging"
+	"github.com/moqsien/gknet/metrics"
 	"github.com/moqsien/gknet/poll"
+	"github.com/moqsien/gknet/utils/budget"
+	"github.com/moqsien/gknet/utils/cidr"
 	"github.com/moqsien/gknet/utils/errs"
 )
 
 type Engine struct {
-	Listener  iface.IListener
-	Balancer  iface.IBalancer
-	MainLoop  *eloop.Eloop
-	Handler   iface.IEventHandler
-	IsClosing int32
-	Options   *iface.Options
-	Pool      *ants.Pool
-	wg        sync.WaitGroup
-	cond      *sync.Cond
-	once      sync.Once
+	Listener     iface.IListener
+	Balancer     iface.IBalancer
+	MainLoop     *eloop.Eloop
+	Handler      iface.IEventHandler
+	IsClosing    int32
+	Options      *iface.Options
+	Pool         iface.WorkerPool // shared by the loops unless Options.PoolPerLoop
+	queue        *poll.WorkQueue  // queue in front of Pool in DispatchPooled mode
+	poolLock     sync.Mutex
+	pools        []iface.WorkerPool
+	owned        []iface.WorkerPool // released when the engine stops
+	queues       []*poll.WorkQueue
+	proxyTrusted []*net.IPNet       // parsed Options.ProxyProtocolTrusted
+	dispatch     iface.DispatchMode // Options.DispatchMode, unless the listener has its own
+	budget       *budget.Budget
+	metrics      *metrics.Registry
+	logger       iface.Logger // Options.Logger with the listener as a field
+	wg           sync.WaitGroup
+	done         chan struct{} // closed when the engine stops
+	cond         *sync.Cond
+	once         sync.Once
 }
 
 func New() *Engine {
@@ -49,14 +65,40 @@ func (that *Engine) Serve(handler iface.IEventHandler, ln iface.IListener, opt *
 	if opt.GoroutineSize <= 0 {
 		opt.GoroutineSize = iface.DefaultGoroutineSize
 	}
+	if opt.ProxyProtocol != iface.ProxyProtocolOff {
+		if len(opt.ProxyProtocolTrusted) == 0 {
+			// anyone could claim any address.
+			return errs.ErrNoTrustedProxy
+		}
+		if that.proxyTrusted, err = cidr.Parse(opt.ProxyProtocolTrusted); err != nil {
+			return err
+		}
+	}
+	that.dispatch = opt.DispatchMode
+	if dl, ok := ln.(iface.IDispatchListener); ok {
+		that.dispatch = dl.DispatchMode()
+	}
+	that.budget = budget.New(opt.BufferBudget)
+	that.metrics = that.newMetrics(ln)
+	that.logger = logging.Or(opt.Logger)
+	if addr := ln.Addr(); addr != nil {
+		that.logger = that.logger.With("listener", addr.String())
+	}
 	that.Listener = ln
 	that.Handler = handler
 	that.Options = opt
-	that.Pool, err = ants.NewPool(opt.GoroutineSize)
-	if err != nil {
-		logger.Println(err)
-		return err
+	switch {
+	case opt.WorkerPool != nil:
+		that.Pool = opt.WorkerPool
+		that.queue = that.addPool(that.Pool, false)
+	case !opt.PoolPerLoop:
+		if that.Pool, err = ants.NewPool(opt.GoroutineSize); err != nil {
+			that.logger.Error("failed to create the worker pool", "err", err)
+			return err
+		}
+		that.queue = that.addPool(that.Pool, true)
 	}
+	that.done = make(chan struct{})
 	that.cond = sync.NewCond(&sync.Mutex{})
 	that.wg = sync.WaitGroup{}
 	that.once = sync.Once{}
@@ -95,12 +137,13 @@ func (that *Engine) waitForStopSignal() {
 func (that *Engine) stop() (err error) {
 	// wait until that.Stop() is called.
 	that.waitForStopSignal()
+	close(that.done)
 
 	// close all connections.
 	that.Balancer.Iterator(func(key int, val iface.IELoop) bool {
 		err := val.GetPoller().AddPriorTask(func(_ iface.PollTaskArg) error { return errs.ErrEngineShutdown }, nil)
 		if err != nil {
-			logger.Errorf("failed to call UrgentTrigger on sub event-loop when stopping engine: %v", err)
+			that.logger.Error("failed to stop a sub loop", "loop", key, "err", err)
 		}
 		return true
 	})
@@ -122,7 +165,11 @@ func (that *Engine) stop() (err error) {
 	if that.MainLoop != nil {
 		err = that.MainLoop.Poller.Close()
 	}
-	that.Pool.Release()
+	that.poolLock.Lock()
+	for _, pool := range that.owned {
+		pool.Release()
+	}
+	that.poolLock.Unlock()
 	return err
 }
 
@@ -135,10 +182,15 @@ func (that *Engine) startReactors(numOfLoops int) error {
 			p.ReadBufferSize = that.Options.ReadBuffer
 			p.Eloop = loop
 			p.ErrForStop = make(chan error, 2)
-			p.Pool = that.Pool
+			if err = that.setDispatch(p, i); err != nil {
+				return err
+			}
+			p.Metrics = that.metrics.NewLoop(i, func() int { return int(loop.GetConnCount()) }, p.TaskCount)
+			p.Logger = that.logger.With("loop", i)
 			loop.Poller = p
 			loop.Engine = that
 			loop.ConnList = make(map[int]net.Conn)
+			loop.ProxyTrusted = that.proxyTrusted
 			that.Balancer.Register(loop)
 		} else {
 			return err
@@ -147,6 +199,9 @@ func (that *Engine) startReactors(numOfLoops int) error {
 
 	// Start sub reactors in background.
 	that.startSubReactors()
+	if that.Options.TCPInfoInterval > 0 {
+		go that.sampleTCPInfo(that.Options.TCPInfoInterval)
+	}
 
 	if p, err := poll.New(); err == nil {
 		loop := new(eloop.Eloop)
@@ -155,7 +210,11 @@ func (that *Engine) startReactors(numOfLoops int) error {
 		p.ReadBufferSize = that.Options.ReadBuffer
 		p.Eloop = loop
 		p.ErrForStop = make(chan error, 2)
-		p.Pool = that.Pool
+		if err = that.setDispatch(p, -1); err != nil {
+			return err
+		}
+		p.Metrics = that.metrics.NewLoop(-1, nil, p.TaskCount)
+		p.Logger = that.logger.With("loop", "main")
 		loop.Poller = p
 		loop.Engine = that
 		if err = loop.Poller.AddRead(loop.Listener); err != nil {
@@ -175,6 +234,69 @@ func (that *Engine) startReactors(numOfLoops int) error {
 	return nil
 }
 
+// setDispatch makes the poller of loop run the work of conns in the dispatch mode of the
+// engine. Tasks of the poller run on the loop in DispatchInline mode, and on the main loop
+// if the loops have pools of their own.
+func (that *Engine) setDispatch(p *poll.Poller, loop int) error {
+	opt := that.Options
+	p.Mode = that.dispatch
+	if p.Mode == iface.DispatchInline {
+		return nil
+	}
+	if !opt.PoolPerLoop || opt.WorkerPool != nil || loop < 0 {
+		p.Pool, p.Queue = that.Pool, that.queue
+		return nil
+	}
+	newPool := opt.NewWorkerPool
+	if newPool == nil {
+		newPool = func(_ int) (iface.WorkerPool, error) {
+			return ants.NewPool(opt.GoroutineSize)
+		}
+	}
+	pool, err := newPool(loop)
+	if err != nil {
+		return err
+	}
+	p.Pool, p.Queue = pool, that.addPool(pool, true)
+	return nil
+}
+
+// addPool records a pool for PoolStats, and returns the dispatch queue in front of it in
+// DispatchPooled mode.
+func (that *Engine) addPool(pool iface.WorkerPool, owned bool) (q *poll.WorkQueue) {
+	opt := that.Options
+	if that.dispatch == iface.DispatchPooled {
+		q = poll.NewWorkQueue(pool, opt.DispatchQueueSize, opt.DispatchOverflow)
+	}
+	that.poolLock.Lock()
+	defer that.poolLock.Unlock()
+	that.pools = append(that.pools, pool)
+	if owned {
+		that.owned = append(that.owned, pool)
+	}
+	if q != nil {
+		that.queues = append(that.queues, q)
+	}
+	return
+}
+
+// sampleTCPInfo feeds the RTTs of the conns into the metrics until the engine stops.
+func (that *Engine) sampleTCPInfo(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-that.done:
+			return
+		case <-t.C:
+		}
+		that.eachLoop(func(loop *eloop.Eloop) bool {
+			loop.SampleTCPInfo()
+			return true
+		})
+	}
+}
+
 func (that *Engine) startSubReactors() {
 	that.Balancer.Iterator(func(i int, loop iface.IELoop) bool {
 		that.wg.Add(1)
@@ -197,3 +319,90 @@ func (that *Engine) GetBalancer() iface.IBalancer {
 func (that *Engine) GetHandler() iface.IEventHandler {
 	return that.Handler
 }
+
+func (that *Engine) GetBudget() *budget.Budget {
+	return that.budget
+}
+
+// BufferUsage returns the bytes held in the buffers of the conns and Options.BufferBudget,
+// both are 0 if no budget is set.
+func (that *Engine) BufferUsage() (used, limit int64) {
+	return that.budget.Used(), that.budget.Limit()
+}
+
+// PoolStats returns the saturation of the worker pools, Cap is -1 if a pool is unbounded.
+func (that *Engine) PoolStats() (s iface.PoolStats) {
+	that.poolLock.Lock()
+	defer that.poolLock.Unlock()
+	for _, pool := range that.pools {
+		s.Running += pool.Running()
+		if c := pool.Cap(); c <= 0 || s.Cap < 0 {
+			s.Cap = -1
+		} else {
+			s.Cap += c
+		}
+	}
+	for _, q := range that.queues {
+		s.Queued += q.Len()
+		s.Rejected += q.Rejected()
+	}
+	return
+}
+
+// Connections returns snapshots of the open conns of all loops, ordered by id.
+func (that *Engine) Connections() []iface.ConnInfo {
+	var infos []iface.ConnInfo
+	that.eachLoop(func(loop *eloop.Eloop) bool {
+		infos = append(infos, loop.Connections()...)
+		return true
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// CloseConnection closes the conn with the id, it reports whether the conn was found.
+func (that *Engine) CloseConnection(id uint64) (found bool) {
+	that.eachLoop(func(loop *eloop.Eloop) bool {
+		found = loop.CloseConn(id)
+		return !found
+	})
+	return
+}
+
+func (that *Engine) eachLoop(f func(loop *eloop.Eloop) bool) {
+	if that.Balancer == nil {
+		return
+	}
+	that.Balancer.Iterator(func(_ int, l iface.IELoop) bool {
+		if loop, ok := l.(*eloop.Eloop); ok {
+			return f(loop)
+		}
+		return true
+	})
+}
+
+// Metrics returns the registry of the counters of the engine, it serves them over http.
+func (that *Engine) Metrics() *metrics.Registry {
+	return that.metrics
+}
+
+func (that *Engine) newMetrics(ln iface.IListener) *metrics.Registry {
+	var r *metrics.Registry
+	if addr := ln.Addr(); addr != nil {
+		r = metrics.NewRegistry("listener", addr.String())
+	} else {
+		r = metrics.NewRegistry()
+	}
+	pool := func(get func(s iface.PoolStats) float64) func() float64 {
+		return func() float64 { return get(that.PoolStats()) }
+	}
+	r.GaugeFunc("gknet_pool_running", "Goroutines of the worker pools running.",
+		pool(func(s iface.PoolStats) float64 { return float64(s.Running) }))
+	r.GaugeFunc("gknet_pool_capacity", "Capacity of the worker pools, -1 if unbounded.",
+		pool(func(s iface.PoolStats) float64 { return float64(s.Cap) }))
+	r.GaugeFunc("gknet_dispatch_queued", "Work waiting in the dispatch queues.",
+		pool(func(s iface.PoolStats) float64 { return float64(s.Queued) }))
+	r.CounterFunc("gknet_dispatch_rejected_total", "Work refused by the dispatch queues.",
+		pool(func(s iface.PoolStats) float64 { return float64(s.Rejected) }))
+	return r
+}
@@ -0,0 +1,317 @@
+package gkhttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	contentEncoding       = "Content-Encoding"
+	acceptEncoding        = "Accept-Encoding"
+	vary                  = "Vary"
+	etag                  = "Etag"
+	defaultCompressMinLen = 1024
+)
+
+// Encoder is a compressing writer which can be reset and reused.
+type Encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// EncoderFactory returns a new Encoder with the compression level, 0 means the default level.
+type EncoderFactory func(level int) Encoder
+
+var (
+	encoders     = map[string]EncoderFactory{}
+	encoderLock  sync.RWMutex
+	encoderPools = sync.Map{}
+)
+
+func init() {
+	RegisterEncoder("gzip", func(level int) Encoder {
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		w, err := gzip.NewWriterLevel(nil, level)
+		if err != nil {
+			w = gzip.NewWriter(nil)
+		}
+		return w
+	})
+	// the "deflate" content coding is the zlib format, see RFC 9110 section 8.4.1.2.
+	RegisterEncoder("deflate", func(level int) Encoder {
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		w, err := zlib.NewWriterLevel(nil, level)
+		if err != nil {
+			w = zlib.NewWriter(nil)
+		}
+		return w
+	})
+}
+
+// RegisterEncoder registers a content coding, e.g. "br" with a brotli writer.
+// Registering an existing name replaces it.
+func RegisterEncoder(name string, factory EncoderFactory) {
+	encoderLock.Lock()
+	encoders[strings.ToLower(name)] = factory
+	encoderLock.Unlock()
+}
+
+type encoderKey struct {
+	name  string
+	level int
+}
+
+func assignEncoderPool(name string, level int) *sync.Pool {
+	key := encoderKey{name, level}
+	if p, ok := encoderPools.Load(key); ok {
+		return p.(*sync.Pool)
+	}
+	encoderLock.RLock()
+	factory := encoders[name]
+	encoderLock.RUnlock()
+	p, _ := encoderPools.LoadOrStore(key, &sync.Pool{New: func() interface{} {
+		return factory(level)
+	}})
+	return p.(*sync.Pool)
+}
+
+// CompressOptions enables response compression when set on Opts.
+type CompressOptions struct {
+	Level        int      // compression level passed to the encoders, 0 means the default level
+	MinLength    int      // bodies shorter than this are not compressed, 0 means 1024
+	ContentTypes []string // allowed media types, "text/*" matches a main type, nil means DefaultCompressTypes
+	Encodings    []string // content codings in server preference, nil means "br", "gzip", "deflate"
+}
+
+var DefaultCompressTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+var defaultEncodings = []string{"br", "gzip", "deflate"}
+
+func (that *CompressOptions) minLength() int {
+	if that.MinLength <= 0 {
+		return defaultCompressMinLen
+	}
+	return that.MinLength
+}
+
+// allowType reports whether the media type of ct may be compressed.
+func (that *CompressOptions) allowType(ct string) bool {
+	if ct == emptyString {
+		return false
+	}
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	ct = strings.ToLower(strings.TrimSpace(ct))
+	types := that.ContentTypes
+	if types == nil {
+		types = DefaultCompressTypes
+	}
+	for _, t := range types {
+		if strings.HasSuffix(t, "/*") {
+			if strings.HasPrefix(ct, t[:len(t)-1]) {
+				return true
+			}
+		} else if ct == t {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate returns the registered coding with the highest q-value in accept, ties are
+// broken by the server preference. It returns "" if the body should not be encoded.
+func (that *CompressOptions) negotiate(accept string) string {
+	if accept == emptyString {
+		return emptyString
+	}
+	codings := that.Encodings
+	if codings == nil {
+		codings = defaultEncodings
+	}
+	best, bestQ := emptyString, 0.0
+	encoderLock.RLock()
+	defer encoderLock.RUnlock()
+	for _, name := range codings {
+		if _, ok := encoders[name]; !ok {
+			continue
+		}
+		if q := acceptQuality(accept, name); q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value of coding in an Accept-Encoding header.
+func acceptQuality(accept, coding string) float64 {
+	q, wildcard := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.TrimSpace(name)
+		v := 1.0
+		for _, param := range strings.Split(params, ";") {
+			k, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil {
+					v = f
+				}
+			}
+		}
+		switch {
+		case strings.EqualFold(name, coding):
+			q = v
+		case name == "*":
+			wildcard = v
+		}
+	}
+	if q < 0 {
+		q = wildcard
+	}
+	return q
+}
+
+// addVary adds value to the Vary header unless it is there already.
+func addVary(h http.Header, value string) {
+	v := h.Get(vary)
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "*" || strings.EqualFold(s, value) {
+			return
+		}
+	}
+	if v == emptyString {
+		h.Set(vary, value)
+	} else {
+		h.Set(vary, v+", "+value)
+	}
+}
+
+// weakenETag marks a strong ETag as weak, an encoded body is not byte-equal to the one it
+// was computed for, see RFC 9110 section 8.8.3.3.
+func weakenETag(h http.Header) {
+	if v := h.Get(etag); v != emptyString && !strings.HasPrefix(v, "W/") {
+		h.Set(etag, "W/"+v)
+	}
+}
+
+// chunkSink writes the output of an encoder as body chunks.
+type chunkSink struct {
+	cw *chunkWriter
+}
+
+func (s chunkSink) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return s.cw.writeFrame(p)
+}
+
+// negotiateEncoding chooses the content coding of the body before the header is written.
+// p is the whole body if the handler has finished, which is then compressed at once so
+// Content-Length stays exact. Otherwise the body is compressed into chunks.
+// encoding returns the encoding of a body starting with p, whole tells whether p is all of
+// it. Empty name means the body is sent as is, vary whether it depends on Accept-Encoding.
+func (cw *chunkWriter) encoding(p []byte, isHEAD, whole bool) (name, ct string, vary bool) {
+	w := cw.res
+	opts := w.compress
+	if ct = w.setHeader.contentType; ct == emptyString {
+		ct = w.handlerHeader.Get(contentType)
+	}
+	if ct == emptyString && len(p) > 0 {
+		ct = http.DetectContentType(p)
+	}
+	if !bodyAllowedForStatus(w.status) || w.status == http.StatusPartialContent || !opts.allowType(ct) ||
+		w.handlerHeader.Get(contentEncoding) != emptyString || w.handlerHeader.Get("Content-Range") != emptyString {
+		return emptyString, ct, false
+	}
+	size := len(p)
+	if isHEAD {
+		// a HEAD gets the headers of the GET, whose body is compressed at once if it fits
+		// into the buffer.
+		if n, err := strconv.Atoi(w.handlerHeader.Get(contentLength)); err == nil {
+			size, whole = n, n <= bufferBeforeChunkingSize
+		}
+	}
+	if (whole && size < opts.minLength()) || (!whole && len(w.handlerHeader.Get(contentLength)) > 0) {
+		return emptyString, ct, true
+	}
+	return opts.negotiate(w.req.Header.Get(acceptEncoding)), ct, true
+}
+
+func (cw *chunkWriter) negotiateEncoding(p []byte, isHEAD bool) {
+	w := cw.res
+	opts := w.compress
+	whole := w.handlerDone.isSet() && !w.noCache && !cw.chunking
+	name, ct, vary := cw.encoding(p, isHEAD, whole)
+	if w.status == http.StatusNotModified && (ct == emptyString || opts.allowType(ct)) &&
+		opts.negotiate(w.req.Header.Get(acceptEncoding)) != emptyString {
+		// the ETag must be the one of the encoded body the client has.
+		weakenETag(w.handlerHeader)
+		vary = true
+	}
+	if vary {
+		addVary(w.handlerHeader, acceptEncoding)
+	}
+	if name == emptyString {
+		return
+	}
+	w.handlerHeader.Set(contentEncoding, name)
+	weakenETag(w.handlerHeader)
+	w.setHeader.contentType = ct
+	if isHEAD {
+		// the length of the encoded body is not known without encoding it.
+		w.setHeader.contentLength = emptyString
+		return
+	}
+	pool := assignEncoderPool(name, opts.Level)
+	enc := pool.Get().(Encoder)
+	if whole {
+		buf := bytes.NewBuffer(make([]byte, 0, len(p)/2+64))
+		enc.Reset(buf)
+		enc.Write(p)
+		enc.Close()
+		pool.Put(enc)
+		cw.encoded = buf.Bytes()
+		w.setHeader.contentLength = strconv.Itoa(len(cw.encoded))
+		return
+	}
+	w.setHeader.contentLength = emptyString
+	if !cw.chunking {
+		cw.chunking = true
+		w.setHeader.transferEncoding = chunked
+	}
+	enc.Reset(chunkSink{cw})
+	cw.enc, cw.encPool = enc, pool
+}
+
+// closeEncoder writes the end of the compressed stream and returns the encoder to its pool.
+func (cw *chunkWriter) closeEncoder(flush bool) {
+	if cw.enc == nil {
+		return
+	}
+	if flush {
+		cw.enc.Close()
+	}
+	cw.enc.Reset(nil)
+	cw.encPool.Put(cw.enc)
+	cw.enc, cw.encPool = nil, nil
+}
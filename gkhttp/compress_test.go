@@ -0,0 +1,151 @@
+package gkhttp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcceptQuality(t *testing.T) {
+	cases := []struct {
+		accept, coding string
+		want           float64
+	}{
+		{"gzip", "gzip", 1},
+		{"GZIP", "gzip", 1},
+		{"gzip;q=0.5", "gzip", 0.5},
+		{"gzip ; q=0.5 , br", "gzip", 0.5},
+		{"gzip;q=0", "gzip", 0},
+		{"br", "gzip", -1},
+		{"*", "gzip", 1},
+		{"*;q=0.2, br", "gzip", 0.2},
+		{"gzip;q=0, *", "gzip", 0},
+		{"gzip;q=bad", "gzip", 1},
+		{"", "gzip", -1},
+	}
+	for _, c := range cases {
+		if got := acceptQuality(c.accept, c.coding); got != c.want {
+			t.Errorf("acceptQuality(%q, %q) = %v, want %v", c.accept, c.coding, got, c.want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		name      string
+		encodings []string
+		accept    string
+		want      string
+	}{
+		{"empty", nil, "", ""},
+		{"gzip", nil, "gzip", "gzip"},
+		{"br is not registered", nil, "br, gzip", "gzip"},
+		{"server preference on ties", nil, "deflate, gzip", "gzip"},
+		{"client q-value first", nil, "gzip;q=0.5, deflate", "deflate"},
+		{"refused", nil, "gzip;q=0, deflate;q=0", ""},
+		{"wildcard", nil, "*", "gzip"},
+		{"identity only", nil, "identity", ""},
+		{"configured codings", []string{"deflate"}, "gzip, deflate", "deflate"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := &CompressOptions{Encodings: c.encodings}
+			if got := opts.negotiate(c.accept); got != c.want {
+				t.Fatalf("negotiate(%q) = %q, want %q", c.accept, got, c.want)
+			}
+		})
+	}
+}
+
+// serveCompressed runs h on a Response with compression and parses what it wrote.
+func serveCompressed(t *testing.T, req *http.Request, h http.HandlerFunc) (*http.Response, []byte) {
+	var out bytes.Buffer
+	rw := bufio.NewReadWriter(bufio.NewReader(strings.NewReader("")), bufio.NewWriter(&out))
+	res := NewResponse(req, nil, rw)
+	res.compress = &CompressOptions{}
+	h(res, req)
+	res.FinishRequest()
+	resp, err := http.ReadResponse(bufio.NewReader(&out), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestCompressedResponseHeaders(t *testing.T) {
+	small := strings.Repeat("compressible text ", 80) // above the minimum length, buffered whole
+	large := strings.Repeat("compressible text ", 400)
+	cases := []struct {
+		name     string
+		method   string
+		accept   string
+		ifNone   string
+		body     string
+		status   int
+		encoding string
+		etag     string
+		length   bool // Content-Length is sent
+	}{
+		{"get", http.MethodGet, "gzip", "", small, http.StatusOK, "gzip", `W/"v1"`, true},
+		{"head", http.MethodHead, "gzip", "", small, http.StatusOK, "gzip", `W/"v1"`, false},
+		{"get without accept", http.MethodGet, "", "", small, http.StatusOK, "", `"v1"`, true},
+		{"head without accept", http.MethodHead, "", "", small, http.StatusOK, "", `"v1"`, true},
+		{"get too short", http.MethodGet, "gzip", "", "short", http.StatusOK, "", `"v1"`, true},
+		{"head too short", http.MethodHead, "gzip", "", "short", http.StatusOK, "", `"v1"`, true},
+		{"get streamed with a length", http.MethodGet, "gzip", "", large, http.StatusOK, "", `"v1"`, true},
+		{"head streamed with a length", http.MethodHead, "gzip", "", large, http.StatusOK, "", `"v1"`, true},
+		{"not modified", http.MethodGet, "gzip", `W/"v1"`, small, http.StatusNotModified, "", `W/"v1"`, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, "/a.txt", nil)
+			if c.accept != "" {
+				req.Header.Set(acceptEncoding, c.accept)
+			}
+			if c.ifNone != "" {
+				req.Header.Set("If-None-Match", c.ifNone)
+			}
+			resp, body := serveCompressed(t, req, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(etag, `"v1"`)
+				http.ServeContent(w, r, "a.txt", time.Time{}, strings.NewReader(c.body))
+			})
+			if resp.StatusCode != c.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, c.status)
+			}
+			if got := resp.Header.Get(contentEncoding); got != c.encoding {
+				t.Fatalf("Content-Encoding %q, want %q", got, c.encoding)
+			}
+			if got := resp.Header.Get(etag); got != c.etag {
+				t.Fatalf("ETag %q, want %q", got, c.etag)
+			}
+			if got := resp.Header.Get(contentLength) != ""; got != c.length {
+				t.Fatalf("Content-Length %q, want it sent: %v", resp.Header.Get(contentLength), c.length)
+			}
+			if c.method != http.MethodGet || c.status != http.StatusOK {
+				return
+			}
+			if c.encoding == "gzip" {
+				zr, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = io.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(body) != c.body {
+				t.Fatalf("got a body of %d bytes, want %d", len(body), len(c.body))
+			}
+		})
+	}
+}
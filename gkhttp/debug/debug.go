@@ -0,0 +1,139 @@
+/*
+Package debug serves the conns of engines as JSON for troubleshooting:
+
+	GET  <prefix>/conns             lists the open conns
+	POST <prefix>/conns/close?id=N  closes a conn
+
+Mount it with http.StripPrefix, behind some authentication.
+*/
+package debug
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moqsien/gknet/iface"
+)
+
+// Source is an engine or a gkhttp.Server.
+type Source interface {
+	Connections() []iface.ConnInfo
+	CloseConnection(id uint64) bool
+}
+
+type connJSON struct {
+	ID          uint64   `json:"id"`
+	Fd          int      `json:"fd"`
+	Loop        int      `json:"loop"`
+	LocalAddr   string   `json:"local_addr"`
+	RemoteAddr  string   `json:"remote_addr"`
+	Opened      string   `json:"opened"`
+	AgeSeconds  float64  `json:"age_seconds"`
+	BytesIn     uint64   `json:"bytes_in"`
+	BytesOut    uint64   `json:"bytes_out"`
+	BufferedOut int      `json:"buffered_out"`
+	TLS         *tlsJSON `json:"tls,omitempty"`
+}
+
+type tlsJSON struct {
+	Version           string `json:"version"`
+	CipherSuite       string `json:"cipher_suite"`
+	ServerName        string `json:"server_name,omitempty"`
+	NegotiatedProto   string `json:"negotiated_protocol,omitempty"`
+	HandshakeComplete bool   `json:"handshake_complete"`
+	Resumed           bool   `json:"resumed"`
+}
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// Handler serves the conns of the sources.
+func Handler(sources ...Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := strings.TrimSuffix(r.URL.Path, "/"); {
+		case strings.HasSuffix(path, "/conns/close"):
+			closeConn(w, r, sources)
+		case strings.HasSuffix(path, "/conns") || path == "conns":
+			listConns(w, sources)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func listConns(w http.ResponseWriter, sources []Source) {
+	conns := []connJSON{}
+	for _, s := range sources {
+		for _, c := range s.Connections() {
+			conns = append(conns, toJSON(c))
+		}
+	}
+	writeJSON(w, http.StatusOK, conns)
+}
+
+func closeConn(w http.ResponseWriter, r *http.Request, sources []Source) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+		return
+	}
+	for _, s := range sources {
+		if s.CloseConnection(id) {
+			writeJSON(w, http.StatusOK, map[string]uint64{"closed": id})
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "conn not found"})
+}
+
+func toJSON(c iface.ConnInfo) connJSON {
+	j := connJSON{
+		ID:          c.ID,
+		Fd:          c.Fd,
+		Loop:        c.Loop,
+		Opened:      c.Opened.Format(time.RFC3339Nano),
+		AgeSeconds:  c.Age.Seconds(),
+		BytesIn:     c.BytesIn,
+		BytesOut:    c.BytesOut,
+		BufferedOut: c.BufferedOut,
+	}
+	if c.LocalAddr != nil {
+		j.LocalAddr = c.LocalAddr.String()
+	}
+	if c.RemoteAddr != nil {
+		j.RemoteAddr = c.RemoteAddr.String()
+	}
+	if s := c.TLS; s != nil {
+		j.TLS = &tlsJSON{
+			Version:           tlsVersions[s.Version],
+			CipherSuite:       tls.CipherSuiteName(s.CipherSuite),
+			ServerName:        s.ServerName,
+			NegotiatedProto:   s.NegotiatedProtocol,
+			HandshakeComplete: s.HandshakeComplete,
+			Resumed:           s.DidResume,
+		}
+		if j.TLS.Version == "" {
+			j.TLS.Version = "0x" + strconv.FormatUint(uint64(s.Version), 16)
+		}
+	}
+	return j
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,68 @@
+package gkhttp
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type fileHandler struct {
+	root    http.FileSystem
+	fileSrv http.Handler
+}
+
+// FileServer returns a handler that serves HTTP requests with the contents of the file system
+// rooted at root, like http.FileServer. File bodies are sent with sendfile on plaintext conns
+// and copied under TLS. Range, If-Modified-Since and ETag(If-None-Match, If-Match, If-Range)
+// are supported, directories are served by http.FileServer.
+func FileServer(root http.FileSystem) http.Handler {
+	return &fileHandler{root: root, fileSrv: http.FileServer(root)}
+}
+
+// fileETag builds a strong ETag from the modification time and the size of a file.
+func fileETag(fi fs.FileInfo) string {
+	return "\"" + strconv.FormatInt(fi.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(fi.Size(), 16) + "\""
+}
+
+func (that *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if res := responseOf(w); res != nil {
+		// sendfile needs the *Response itself.
+		w = res
+	}
+	upath := r.URL.Path
+	if !strings.HasPrefix(upath, "/") {
+		upath = "/" + upath
+	}
+	if strings.HasSuffix(upath, "/") || strings.HasSuffix(upath, "/index.html") {
+		// redirects, index files and listings.
+		that.fileSrv.ServeHTTP(w, r)
+		return
+	}
+	f, err := that.root.Open(path.Clean(upath))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			status = http.StatusNotFound
+		case errors.Is(err, fs.ErrPermission):
+			status = http.StatusForbidden
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if fi.IsDir() {
+		that.fileSrv.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Etag", fileETag(fi))
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
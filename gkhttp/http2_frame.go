@@ -0,0 +1,238 @@
+package gkhttp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	http2ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+	http2NextProto     = "h2"
+	http2CleartextName = "h2c"
+	http2SettingsName  = "HTTP2-Settings"
+	http2FrameHeadLen  = 9
+)
+
+// frame types, see RFC 7540 section 6.
+const (
+	http2FrameData         uint8 = 0x0
+	http2FrameHeaders      uint8 = 0x1
+	http2FramePriority     uint8 = 0x2
+	http2FrameRSTStream    uint8 = 0x3
+	http2FrameSettings     uint8 = 0x4
+	http2FramePushPromise  uint8 = 0x5
+	http2FramePing         uint8 = 0x6
+	http2FrameGoAway       uint8 = 0x7
+	http2FrameWindowUpdate uint8 = 0x8
+	http2FrameContinuation uint8 = 0x9
+)
+
+// frame flags.
+const (
+	http2FlagEndStream  uint8 = 0x1
+	http2FlagAck        uint8 = 0x1
+	http2FlagEndHeaders uint8 = 0x4
+	http2FlagPadded     uint8 = 0x8
+	http2FlagPriority   uint8 = 0x20
+)
+
+// settings identifiers.
+const (
+	http2SettingHeaderTableSize      uint16 = 0x1
+	http2SettingEnablePush           uint16 = 0x2
+	http2SettingMaxConcurrentStreams uint16 = 0x3
+	http2SettingInitialWindowSize    uint16 = 0x4
+	http2SettingMaxFrameSize         uint16 = 0x5
+	http2SettingMaxHeaderListSize    uint16 = 0x6
+)
+
+const (
+	http2DefaultWindowSize      int32  = 65535
+	http2DefaultMaxFrameSize    uint32 = 16384
+	http2MaxFrameSizeLimit      uint32 = 1<<24 - 1
+	http2MaxWindowSize          int64  = 1<<31 - 1
+	http2DefaultHeaderTableSize uint32 = 4096
+	http2MaxConcurrentStreams   uint32 = 250
+	http2MaxHeaderListSize      uint32 = 1 << 20
+	http2DefaultMaxBodySize     int    = 4 << 20
+)
+
+type http2ErrCode uint32
+
+const (
+	http2ErrCodeNo                 http2ErrCode = 0x0
+	http2ErrCodeProtocol           http2ErrCode = 0x1
+	http2ErrCodeInternal           http2ErrCode = 0x2
+	http2ErrCodeFlowControl        http2ErrCode = 0x3
+	http2ErrCodeSettingsTimeout    http2ErrCode = 0x4
+	http2ErrCodeStreamClosed       http2ErrCode = 0x5
+	http2ErrCodeFrameSize          http2ErrCode = 0x6
+	http2ErrCodeRefusedStream      http2ErrCode = 0x7
+	http2ErrCodeCancel             http2ErrCode = 0x8
+	http2ErrCodeCompression        http2ErrCode = 0x9
+	http2ErrCodeConnect            http2ErrCode = 0xa
+	http2ErrCodeEnhanceYourCalm    http2ErrCode = 0xb
+	http2ErrCodeInadequateSecurity http2ErrCode = 0xc
+	http2ErrCodeHTTP11Required     http2ErrCode = 0xd
+)
+
+var http2ErrCodeName = map[http2ErrCode]string{
+	http2ErrCodeNo:                 "NO_ERROR",
+	http2ErrCodeProtocol:           "PROTOCOL_ERROR",
+	http2ErrCodeInternal:           "INTERNAL_ERROR",
+	http2ErrCodeFlowControl:        "FLOW_CONTROL_ERROR",
+	http2ErrCodeSettingsTimeout:    "SETTINGS_TIMEOUT",
+	http2ErrCodeStreamClosed:       "STREAM_CLOSED",
+	http2ErrCodeFrameSize:          "FRAME_SIZE_ERROR",
+	http2ErrCodeRefusedStream:      "REFUSED_STREAM",
+	http2ErrCodeCancel:             "CANCEL",
+	http2ErrCodeCompression:        "COMPRESSION_ERROR",
+	http2ErrCodeConnect:            "CONNECT_ERROR",
+	http2ErrCodeEnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	http2ErrCodeInadequateSecurity: "INADEQUATE_SECURITY",
+	http2ErrCodeHTTP11Required:     "HTTP_1_1_REQUIRED",
+}
+
+func (e http2ErrCode) String() string {
+	if s, ok := http2ErrCodeName[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint32(e))
+}
+
+// http2ConnError is a connection error, the connection must be closed with a GOAWAY.
+type http2ConnError struct {
+	Code   http2ErrCode
+	Reason string
+}
+
+func (e http2ConnError) Error() string {
+	return fmt.Sprintf("[http2] connection error: %s, %s", e.Code, e.Reason)
+}
+
+// http2StreamError only affects one stream, which is reset with a RST_STREAM.
+type http2StreamError struct {
+	StreamID uint32
+	Code     http2ErrCode
+}
+
+func (e http2StreamError) Error() string {
+	return fmt.Sprintf("[http2] stream error: stream ID %d; %s", e.StreamID, e.Code)
+}
+
+type http2FrameHeader struct {
+	Length   uint32
+	Type     uint8
+	Flags    uint8
+	StreamID uint32
+}
+
+func (h http2FrameHeader) Has(flag uint8) bool {
+	return h.Flags&flag == flag
+}
+
+// parseHttp2FrameHeader parses the fixed 9-byte frame header, b must be at least 9 bytes.
+func parseHttp2FrameHeader(b []byte) http2FrameHeader {
+	return http2FrameHeader{
+		Length:   uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2]),
+		Type:     b[3],
+		Flags:    b[4],
+		StreamID: binary.BigEndian.Uint32(b[5:9]) & (1<<31 - 1),
+	}
+}
+
+// appendHttp2Frame appends a complete frame to dst.
+func appendHttp2Frame(dst []byte, typ, flags uint8, streamID uint32, payload []byte) []byte {
+	length := len(payload)
+	dst = append(dst,
+		byte(length>>16), byte(length>>8), byte(length),
+		typ, flags,
+		byte(streamID>>24)&0x7f, byte(streamID>>16), byte(streamID>>8), byte(streamID))
+	return append(dst, payload...)
+}
+
+// http2StripPadding removes the padding of DATA or HEADERS payload.
+func http2StripPadding(h http2FrameHeader, payload []byte) ([]byte, error) {
+	if !h.Has(http2FlagPadded) {
+		return payload, nil
+	}
+	if len(payload) < 1 {
+		return nil, http2ConnError{http2ErrCodeFrameSize, "padded frame too short"}
+	}
+	padLen := int(payload[0])
+	payload = payload[1:]
+	if padLen > len(payload) {
+		return nil, http2ConnError{http2ErrCodeProtocol, "pad length too large"}
+	}
+	return payload[:len(payload)-padLen], nil
+}
+
+type http2Setting struct {
+	ID  uint16
+	Val uint32
+}
+
+func (s http2Setting) Valid() error {
+	switch s.ID {
+	case http2SettingEnablePush:
+		if s.Val != 1 && s.Val != 0 {
+			return http2ConnError{http2ErrCodeProtocol, "invalid ENABLE_PUSH"}
+		}
+	case http2SettingInitialWindowSize:
+		if int64(s.Val) > http2MaxWindowSize {
+			return http2ConnError{http2ErrCodeFlowControl, "invalid INITIAL_WINDOW_SIZE"}
+		}
+	case http2SettingMaxFrameSize:
+		if s.Val < http2DefaultMaxFrameSize || s.Val > http2MaxFrameSizeLimit {
+			return http2ConnError{http2ErrCodeProtocol, "invalid MAX_FRAME_SIZE"}
+		}
+	}
+	return nil
+}
+
+// parseHttp2Settings parses the payload of a SETTINGS frame, also used for HTTP2-Settings header.
+func parseHttp2Settings(payload []byte) (settings []http2Setting, err error) {
+	if len(payload)%6 != 0 {
+		return nil, http2ConnError{http2ErrCodeFrameSize, "invalid settings length"}
+	}
+	for i := 0; i < len(payload); i += 6 {
+		s := http2Setting{
+			ID:  binary.BigEndian.Uint16(payload[i : i+2]),
+			Val: binary.BigEndian.Uint32(payload[i+2 : i+6]),
+		}
+		if err = s.Valid(); err != nil {
+			return nil, err
+		}
+		settings = append(settings, s)
+	}
+	return
+}
+
+func appendHttp2Settings(dst []byte, settings ...http2Setting) []byte {
+	payload := make([]byte, 0, 6*len(settings))
+	for _, s := range settings {
+		payload = append(payload, byte(s.ID>>8), byte(s.ID),
+			byte(s.Val>>24), byte(s.Val>>16), byte(s.Val>>8), byte(s.Val))
+	}
+	return appendHttp2Frame(dst, http2FrameSettings, 0, 0, payload)
+}
+
+func appendHttp2WindowUpdate(dst []byte, streamID, incr uint32) []byte {
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], incr&0x7fffffff)
+	return appendHttp2Frame(dst, http2FrameWindowUpdate, 0, streamID, payload[:])
+}
+
+func appendHttp2RSTStream(dst []byte, streamID uint32, code http2ErrCode) []byte {
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], uint32(code))
+	return appendHttp2Frame(dst, http2FrameRSTStream, 0, streamID, payload[:])
+}
+
+func appendHttp2GoAway(dst []byte, lastStreamID uint32, code http2ErrCode, debug []byte) []byte {
+	payload := make([]byte, 8, 8+len(debug))
+	binary.BigEndian.PutUint32(payload[:4], lastStreamID&0x7fffffff)
+	binary.BigEndian.PutUint32(payload[4:8], uint32(code))
+	payload = append(payload, debug...)
+	return appendHttp2Frame(dst, http2FrameGoAway, 0, 0, payload)
+}
@@ -0,0 +1,122 @@
+package gkhttp
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// response body is sent in DATA frames once this much has been buffered.
+const http2BufferBeforeFlushSize = 16 << 10
+
+// http2Response implements http.ResponseWriter and http.Flusher for a HTTP/2 stream.
+type http2Response struct {
+	conn        *http2Conn
+	stream      *http2Stream
+	req         *http.Request
+	header      http.Header
+	status      int
+	wroteHeader bool // header logically written by the handler
+	sentHeader  bool // HEADERS frame queued on the wire
+	handlerDone bool
+	body        []byte
+}
+
+func newHttp2Response(c *http2Conn, st *http2Stream, req *http.Request) *http2Response {
+	return &http2Response{
+		conn:   c,
+		stream: st,
+		req:    req,
+		header: make(http.Header),
+	}
+}
+
+// Header returns the header map that will be sent by WriteHeader.
+func (w *http2Response) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader records the status code, the HEADERS frame is sent on Flush, or when the handler returns.
+func (w *http2Response) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	checkWriteHeaderCode(code)
+	w.wroteHeader = true
+	w.status = code
+}
+
+// Write buffers data as part of the response body.
+func (w *http2Response) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if !bodyAllowedForStatus(w.status) {
+		return 0, http.ErrBodyNotAllowed
+	}
+	if w.req.Method == head {
+		return len(data), nil
+	}
+	w.body = append(w.body, data...)
+	if len(w.body) >= http2BufferBeforeFlushSize {
+		w.sendHeader(false)
+		w.sendBody(false)
+	}
+	return len(data), nil
+}
+
+// WriteString is like Write but for a string.
+func (w *http2Response) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush implements the http.Flusher interface.
+func (w *http2Response) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.sendHeader(false)
+	w.sendBody(false)
+	w.conn.flush()
+}
+
+// finish ends the stream once the handler has returned.
+func (w *http2Response) finish() {
+	w.handlerDone = true
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.sentHeader && len(w.body) == 0 {
+		w.sendHeader(true)
+		return
+	}
+	w.sendHeader(false)
+	w.sendBody(true)
+}
+
+func (w *http2Response) sendHeader(endStream bool) {
+	if w.sentHeader {
+		return
+	}
+	w.sentHeader = true
+	if w.header.Get(date) == emptyString {
+		w.header.Set(date, string(appendTime(nil, time.Now())))
+	}
+	if w.header.Get(contentType) == emptyString && len(w.body) > 0 {
+		w.header.Set(contentType, http.DetectContentType(w.body))
+	}
+	if w.handlerDone && w.header.Get(contentLength) == emptyString &&
+		bodyAllowedForStatus(w.status) && w.req.Method != head {
+		w.header.Set(contentLength, strconv.Itoa(len(w.body)))
+	}
+	w.conn.writeHeaders(w.stream, headerFields(w.status, w.header), endStream)
+}
+
+func (w *http2Response) sendBody(endStream bool) {
+	data := w.body
+	w.body = nil
+	w.conn.writeData(w.stream, data, endStream)
+}
@@ -0,0 +1,857 @@
+package gkhttp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/moqsien/gknet/conn"
+	"github.com/moqsien/gknet/iface"
+)
+
+type http2StreamState int
+
+const (
+	http2StateOpen http2StreamState = iota
+	http2StateHalfClosedRemote
+	http2StateClosed
+)
+
+// http2Stream is a request/response exchange on a http2Conn.
+type http2Stream struct {
+	id          uint32
+	state       http2StreamState
+	method      string
+	scheme      string
+	authority   string
+	path        string
+	header      http.Header
+	trailer     http.Header
+	body        []byte
+	headersDone bool
+	sendWindow  int64  // flow-control window for sending DATA
+	recvWindow  int64  // what the peer may still send, bodies are only refunded on release
+	held        int64  // bytes of the connection window taken by body
+	pending     []byte // response data waiting for window
+	endPending  bool   // END_STREAM should be sent after pending data
+	endSent     bool
+}
+
+// http2Conn is the per-connection HTTP/2 state. It replaces GkEventHandler as the handler
+// of the conn.Conn once HTTP/2 is negotiated, so every frame is processed on the event loop.
+type http2Conn struct {
+	server            *Server
+	ctx               *iface.Context
+	inBuf             []byte // unparsed inbound bytes
+	outBuf            []byte // frames to be written at the end of the event
+	readBuf           []byte
+	prefaced          bool // client connection preface has been received
+	decoder           *hpack.Decoder
+	encoder           *hpack.Encoder
+	encBuf            bytes.Buffer
+	streams           map[uint32]*http2Stream
+	lastStreamID      uint32
+	sendWindow        int64 // connection-level flow-control window for sending
+	recvWindow        int64 // connection-level window the peer may still send in
+	refunds           uint32
+	maxBody           int
+	peerMaxFrameSize  uint32
+	peerInitialWindow int64
+	contStreamID      uint32 // stream whose header block expects CONTINUATION frames
+	contEndStream     bool
+	contRefused       bool
+	contBlock         []byte
+	goAwaySent        bool
+	goAwayReceived    bool
+	tlsState          *tls.ConnectionState
+}
+
+func newHttp2Conn(s *Server, c *iface.Context) *http2Conn {
+	h2 := &http2Conn{
+		server:            s,
+		ctx:               c,
+		streams:           make(map[uint32]*http2Stream),
+		sendWindow:        int64(http2DefaultWindowSize),
+		recvWindow:        int64(http2DefaultWindowSize),
+		peerMaxFrameSize:  http2DefaultMaxFrameSize,
+		peerInitialWindow: int64(http2DefaultWindowSize),
+		maxBody:           http2DefaultMaxBodySize,
+	}
+	if s.options != nil && s.options.MaxRequestBodySize > 0 {
+		h2.maxBody = s.options.MaxRequestBodySize
+	}
+	h2.decoder = hpack.NewDecoder(http2DefaultHeaderTableSize, nil)
+	h2.decoder.SetMaxStringLength(int(http2MaxHeaderListSize))
+	h2.encoder = hpack.NewEncoder(&h2.encBuf)
+	if tc, ok := c.Conn.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		h2.tlsState = &state
+	}
+	return h2
+}
+
+// switchToHttp2 replaces the handler of the underlying conn with a http2Conn.
+func (that *GkEventHandler) switchToHttp2(c *iface.Context) *http2Conn {
+	h2 := newHttp2Conn(that.httpServer, c)
+	if rc, ok := c.RawConn.(*conn.Conn); ok {
+		rc.Handler = h2
+	}
+	return h2
+}
+
+// detectHttp2 checks ALPN for TLS conns, or the client preface(prior knowledge) for plaintext conns.
+func (that *GkEventHandler) detectHttp2(c *iface.Context) *http2Conn {
+	if tc, ok := c.Conn.(*tls.Conn); ok {
+		if tc.ConnectionState().NegotiatedProtocol != http2NextProto {
+			return nil
+		}
+	} else {
+		b, _ := c.Reader.Peek(len(http2ClientPreface))
+		if len(b) < 4 || !strings.HasPrefix(http2ClientPreface, string(b)) {
+			return nil
+		}
+	}
+	h2 := that.switchToHttp2(c)
+	h2.writeServerPreface()
+	return h2
+}
+
+// isHttp2Upgrade reports whether req asks for h2c, which is only allowed on plaintext conns.
+func isHttp2Upgrade(c *iface.Context, req *http.Request) bool {
+	if _, ok := c.Conn.(*tls.Conn); ok {
+		return false
+	}
+	if req.ContentLength > 0 || req.Header.Get(http2SettingsName) == emptyString {
+		return false
+	}
+	return headerValueContains(req.Header, "Upgrade", http2CleartextName) &&
+		headerValueContains(req.Header, "Connection", "upgrade")
+}
+
+func headerValueContains(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var http2SwitchingProtocols = []byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+
+// upgradeToHttp2 handles "Upgrade: h2c", the request is served as stream 1.
+func (that *GkEventHandler) upgradeToHttp2(c *iface.Context, req *http.Request) error {
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(req.Header.Get(http2SettingsName), "="))
+	if err != nil {
+		return err
+	}
+	settings, err := parseHttp2Settings(payload)
+	if err != nil {
+		return err
+	}
+	h2 := that.switchToHttp2(c)
+	h2.outBuf = append(h2.outBuf, http2SwitchingProtocols...)
+	h2.writeServerPreface()
+	if err = h2.applySettings(settings); err != nil {
+		return err
+	}
+	for _, key := range []string{"Upgrade", "Connection", http2SettingsName} {
+		req.Header.Del(key)
+	}
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/2.0", 2, 0
+	st := h2.newStream(1)
+	st.state = http2StateHalfClosedRemote
+	h2.lastStreamID = 1
+	h2.serveStream(st, req)
+	// the client preface and frames may already be buffered.
+	return h2.OnTrack(c)
+}
+
+// bodyWindow is the receive window of the streams and of the connection, a request body may
+// fill it before its handler runs. A stream that exhausts it exceeds maxBody.
+func (that *http2Conn) bodyWindow() int64 {
+	if w := int64(that.maxBody) + 1; w < http2MaxWindowSize {
+		return w
+	}
+	return http2MaxWindowSize
+}
+
+func (that *http2Conn) writeServerPreface() {
+	w := that.bodyWindow()
+	that.outBuf = appendHttp2Settings(that.outBuf,
+		http2Setting{http2SettingMaxConcurrentStreams, http2MaxConcurrentStreams},
+		http2Setting{http2SettingInitialWindowSize, uint32(w)},
+		http2Setting{http2SettingMaxFrameSize, http2DefaultMaxFrameSize},
+		http2Setting{http2SettingMaxHeaderListSize, http2MaxHeaderListSize},
+	)
+	if incr := w - that.recvWindow; incr > 0 {
+		that.outBuf = appendHttp2WindowUpdate(that.outBuf, 0, uint32(incr))
+		that.recvWindow = w
+	}
+}
+
+func (that *http2Conn) newStream(id uint32) *http2Stream {
+	st := &http2Stream{
+		id:         id,
+		header:     make(http.Header),
+		sendWindow: that.peerInitialWindow,
+		recvWindow: that.bodyWindow(),
+	}
+	that.streams[id] = st
+	return st
+}
+
+func (that *http2Conn) OnAccept(c iface.RawConn) error {
+	return nil
+}
+
+func (that *http2Conn) OnOpen(c *iface.Context) ([]byte, error) {
+	return nil, nil
+}
+
+func (that *http2Conn) OnClose(c *iface.Context) error {
+	that.streams = nil
+	that.inBuf, that.outBuf = nil, nil
+	return that.server.eventHandler.OnClose(c)
+}
+
+func (that *http2Conn) OnTrack(c *iface.Context) (err error) {
+	that.readFrom(c.Reader)
+	err = that.serve()
+	that.flush()
+	return
+}
+
+// readFrom drains everything readable in this event.
+func (that *http2Conn) readFrom(r io.Reader) {
+	if that.readBuf == nil {
+		that.readBuf = make([]byte, http2DefaultMaxFrameSize)
+	}
+	for {
+		n, err := r.Read(that.readBuf)
+		if n > 0 {
+			that.inBuf = append(that.inBuf, that.readBuf[:n]...)
+		}
+		if err != nil || n == 0 {
+			return
+		}
+	}
+}
+
+func (that *http2Conn) flush() {
+	if that.refunds > 0 && !that.goAwaySent {
+		that.outBuf = appendHttp2WindowUpdate(that.outBuf, 0, that.refunds)
+		that.recvWindow += int64(that.refunds)
+		that.refunds = 0
+	}
+	if len(that.outBuf) == 0 || that.ctx == nil {
+		return
+	}
+	// the written slice is handed over, since async writers keep it until the task runs.
+	data := that.outBuf
+	that.outBuf = nil
+	that.ctx.Conn.Write(data)
+}
+
+func (that *http2Conn) serve() error {
+	if that.goAwaySent {
+		that.inBuf = that.inBuf[:0]
+		return nil
+	}
+	if !that.prefaced {
+		n := len(http2ClientPreface)
+		if len(that.inBuf) < n {
+			if !strings.HasPrefix(http2ClientPreface, string(that.inBuf)) {
+				return that.goAway(http2ConnError{http2ErrCodeProtocol, "invalid client preface"})
+			}
+			return nil
+		}
+		if string(that.inBuf[:n]) != http2ClientPreface {
+			return that.goAway(http2ConnError{http2ErrCodeProtocol, "invalid client preface"})
+		}
+		that.inBuf = that.inBuf[n:]
+		that.prefaced = true
+	}
+
+	var err error
+	buf := that.inBuf
+	for len(buf) >= http2FrameHeadLen {
+		h := parseHttp2FrameHeader(buf)
+		if h.Length > http2DefaultMaxFrameSize {
+			return that.goAway(http2ConnError{http2ErrCodeFrameSize, "frame too large"})
+		}
+		end := http2FrameHeadLen + int(h.Length)
+		if len(buf) < end {
+			break
+		}
+		err = that.processFrame(h, buf[http2FrameHeadLen:end])
+		buf = buf[end:]
+		switch e := err.(type) {
+		case nil:
+		case http2StreamError:
+			that.resetStream(e)
+		case http2ConnError:
+			return that.goAway(e)
+		default:
+			return that.goAway(http2ConnError{http2ErrCodeInternal, e.Error()})
+		}
+	}
+	that.inBuf = append(that.inBuf[:0], buf...)
+	return nil
+}
+
+func (that *http2Conn) goAway(e http2ConnError) error {
+	that.goAwaySent = true
+	that.outBuf = appendHttp2GoAway(that.outBuf, that.lastStreamID, e.Code, []byte(e.Reason))
+	that.inBuf = that.inBuf[:0]
+	return e
+}
+
+func (that *http2Conn) resetStream(e http2StreamError) {
+	that.outBuf = appendHttp2RSTStream(that.outBuf, e.StreamID, e.Code)
+	if st, ok := that.streams[e.StreamID]; ok {
+		st.state = http2StateClosed
+		that.releaseBody(st)
+		delete(that.streams, e.StreamID)
+	}
+}
+
+// refund gives n bytes of the connection window back to the peer with the next flush.
+func (that *http2Conn) refund(n int64) {
+	that.refunds += uint32(n)
+}
+
+// releaseBody drops the request body of st and refunds the connection window it took.
+func (that *http2Conn) releaseBody(st *http2Stream) {
+	that.refund(st.held)
+	st.held = 0
+	st.body = nil
+}
+
+// rejectBody answers a request whose body exceeds maxBody with a 413, and asks the peer to
+// stop sending it.
+func (that *http2Conn) rejectBody(st *http2Stream) {
+	h := http.Header{contentLength: []string{"0"}}
+	that.writeHeaders(st, headerFields(http.StatusRequestEntityTooLarge, h), true)
+	that.resetStream(http2StreamError{st.id, http2ErrCodeNo})
+}
+
+// relieve refuses the newest incomplete request once the bodies waiting for END_STREAM have
+// taken the whole connection window, so the peer is never stuck. The request was not
+// processed and can be retried.
+func (that *http2Conn) relieve() {
+	for that.recvWindow+int64(that.refunds) <= 0 {
+		var newest *http2Stream
+		for _, st := range that.streams {
+			if st.held > 0 && st.state == http2StateOpen && (newest == nil || st.id > newest.id) {
+				newest = st
+			}
+		}
+		if newest == nil {
+			return
+		}
+		that.resetStream(http2StreamError{newest.id, http2ErrCodeRefusedStream})
+	}
+}
+
+func (that *http2Conn) processFrame(h http2FrameHeader, payload []byte) error {
+	if that.contStreamID != 0 && (h.Type != http2FrameContinuation || h.StreamID != that.contStreamID) {
+		return http2ConnError{http2ErrCodeProtocol, "expected CONTINUATION frame"}
+	}
+	switch h.Type {
+	case http2FrameData:
+		return that.processData(h, payload)
+	case http2FrameHeaders:
+		return that.processHeaders(h, payload)
+	case http2FramePriority:
+		if h.StreamID == 0 {
+			return http2ConnError{http2ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if len(payload) != 5 {
+			return http2StreamError{h.StreamID, http2ErrCodeFrameSize}
+		}
+		return nil
+	case http2FrameRSTStream:
+		if h.StreamID == 0 {
+			return http2ConnError{http2ErrCodeProtocol, "RST_STREAM on stream 0"}
+		}
+		if len(payload) != 4 {
+			return http2ConnError{http2ErrCodeFrameSize, "invalid RST_STREAM length"}
+		}
+		if h.StreamID > that.lastStreamID {
+			return http2ConnError{http2ErrCodeProtocol, "RST_STREAM on idle stream"}
+		}
+		if st, ok := that.streams[h.StreamID]; ok {
+			that.releaseBody(st)
+		}
+		delete(that.streams, h.StreamID)
+		return nil
+	case http2FrameSettings:
+		return that.processSettings(h, payload)
+	case http2FramePushPromise:
+		return http2ConnError{http2ErrCodeProtocol, "PUSH_PROMISE from client"}
+	case http2FramePing:
+		if h.StreamID != 0 {
+			return http2ConnError{http2ErrCodeProtocol, "PING on non-zero stream"}
+		}
+		if len(payload) != 8 {
+			return http2ConnError{http2ErrCodeFrameSize, "invalid PING length"}
+		}
+		if !h.Has(http2FlagAck) {
+			that.outBuf = appendHttp2Frame(that.outBuf, http2FramePing, http2FlagAck, 0, payload)
+		}
+		return nil
+	case http2FrameGoAway:
+		if h.StreamID != 0 {
+			return http2ConnError{http2ErrCodeProtocol, "GOAWAY on non-zero stream"}
+		}
+		if len(payload) < 8 {
+			return http2ConnError{http2ErrCodeFrameSize, "invalid GOAWAY length"}
+		}
+		that.goAwayReceived = true
+		return nil
+	case http2FrameWindowUpdate:
+		return that.processWindowUpdate(h, payload)
+	case http2FrameContinuation:
+		if that.contStreamID == 0 {
+			return http2ConnError{http2ErrCodeProtocol, "unexpected CONTINUATION frame"}
+		}
+		that.contBlock = append(that.contBlock, payload...)
+		if uint32(len(that.contBlock)) > http2MaxHeaderListSize {
+			return http2ConnError{http2ErrCodeEnhanceYourCalm, "header block too large"}
+		}
+		if h.Has(http2FlagEndHeaders) {
+			return that.finishHeaders()
+		}
+		return nil
+	default:
+		// unknown frame types must be ignored.
+		return nil
+	}
+}
+
+func (that *http2Conn) processData(h http2FrameHeader, payload []byte) error {
+	if h.StreamID == 0 {
+		return http2ConnError{http2ErrCodeProtocol, "DATA on stream 0"}
+	}
+	n := int64(h.Length)
+	if n > that.recvWindow {
+		return http2ConnError{http2ErrCodeFlowControl, "connection window exceeded"}
+	}
+	that.recvWindow -= n
+	st, ok := that.streams[h.StreamID]
+	if !ok || st.state != http2StateOpen {
+		// the data is dropped, so its window is given back.
+		that.refund(n)
+		if h.StreamID > that.lastStreamID {
+			return http2ConnError{http2ErrCodeProtocol, "DATA on idle stream"}
+		}
+		return http2StreamError{h.StreamID, http2ErrCodeStreamClosed}
+	}
+	if n > st.recvWindow {
+		that.refund(n)
+		return http2StreamError{h.StreamID, http2ErrCodeFlowControl}
+	}
+	st.recvWindow -= n
+	data, err := http2StripPadding(h, payload)
+	if err != nil {
+		return err
+	}
+	// the window of the body is held until its handler returns, the padding is not kept.
+	st.held += int64(len(data))
+	that.refund(n - int64(len(data)))
+	if len(st.body)+len(data) > that.maxBody {
+		that.rejectBody(st)
+		return nil
+	}
+	st.body = append(st.body, data...)
+	if h.Has(http2FlagEndStream) {
+		st.state = http2StateHalfClosedRemote
+		that.dispatch(st)
+		return nil
+	}
+	that.relieve()
+	return nil
+}
+
+func (that *http2Conn) processHeaders(h http2FrameHeader, payload []byte) error {
+	if h.StreamID == 0 || h.StreamID%2 == 0 {
+		return http2ConnError{http2ErrCodeProtocol, "invalid HEADERS stream ID"}
+	}
+	block, err := http2StripPadding(h, payload)
+	if err != nil {
+		return err
+	}
+	if h.Has(http2FlagPriority) {
+		if len(block) < 5 {
+			return http2ConnError{http2ErrCodeFrameSize, "HEADERS priority too short"}
+		}
+		block = block[5:]
+	}
+	that.contRefused = false
+	if st, ok := that.streams[h.StreamID]; ok {
+		// trailers.
+		if st.state != http2StateOpen {
+			return http2StreamError{h.StreamID, http2ErrCodeStreamClosed}
+		}
+		if !h.Has(http2FlagEndStream) {
+			return http2ConnError{http2ErrCodeProtocol, "trailers without END_STREAM"}
+		}
+	} else {
+		if h.StreamID <= that.lastStreamID {
+			return http2ConnError{http2ErrCodeProtocol, "HEADERS on closed stream"}
+		}
+		that.lastStreamID = h.StreamID
+		if that.goAwayReceived || uint32(len(that.streams)) >= http2MaxConcurrentStreams {
+			// the header block must still be decoded to keep the HPACK state in sync.
+			that.contRefused = true
+		} else {
+			that.newStream(h.StreamID)
+		}
+	}
+	that.contStreamID = h.StreamID
+	that.contEndStream = h.Has(http2FlagEndStream)
+	that.contBlock = append(that.contBlock[:0], block...)
+	if h.Has(http2FlagEndHeaders) {
+		return that.finishHeaders()
+	}
+	return nil
+}
+
+func (that *http2Conn) finishHeaders() error {
+	id := that.contStreamID
+	that.contStreamID = 0
+	fields, err := that.decoder.DecodeFull(that.contBlock)
+	if err != nil {
+		return http2ConnError{http2ErrCodeCompression, err.Error()}
+	}
+	if that.contRefused {
+		return http2StreamError{id, http2ErrCodeRefusedStream}
+	}
+	st, ok := that.streams[id]
+	if !ok {
+		return nil
+	}
+	if st.headersDone {
+		for _, f := range fields {
+			if f.IsPseudo() {
+				return http2StreamError{id, http2ErrCodeProtocol}
+			}
+			if st.trailer == nil {
+				st.trailer = make(http.Header)
+			}
+			st.trailer.Add(http.CanonicalHeaderKey(f.Name), f.Value)
+		}
+	} else {
+		if err = st.parseFields(fields); err != nil {
+			return err
+		}
+		st.headersDone = true
+	}
+	if that.contEndStream {
+		st.state = http2StateHalfClosedRemote
+		that.dispatch(st)
+	} else if cl, err := strconv.ParseInt(st.header.Get(contentLength), 10, 64); err == nil && cl > int64(that.maxBody) {
+		that.rejectBody(st)
+	}
+	return nil
+}
+
+func (that *http2Conn) processSettings(h http2FrameHeader, payload []byte) error {
+	if h.StreamID != 0 {
+		return http2ConnError{http2ErrCodeProtocol, "SETTINGS on non-zero stream"}
+	}
+	if h.Has(http2FlagAck) {
+		if len(payload) != 0 {
+			return http2ConnError{http2ErrCodeFrameSize, "SETTINGS ACK with payload"}
+		}
+		return nil
+	}
+	settings, err := parseHttp2Settings(payload)
+	if err != nil {
+		return err
+	}
+	if err = that.applySettings(settings); err != nil {
+		return err
+	}
+	that.outBuf = appendHttp2Frame(that.outBuf, http2FrameSettings, http2FlagAck, 0, nil)
+	return nil
+}
+
+func (that *http2Conn) applySettings(settings []http2Setting) error {
+	for _, s := range settings {
+		switch s.ID {
+		case http2SettingHeaderTableSize:
+			that.encoder.SetMaxDynamicTableSizeLimit(s.Val)
+		case http2SettingInitialWindowSize:
+			delta := int64(s.Val) - that.peerInitialWindow
+			that.peerInitialWindow = int64(s.Val)
+			for _, st := range that.streams {
+				st.sendWindow += delta
+				if st.sendWindow > http2MaxWindowSize {
+					return http2ConnError{http2ErrCodeFlowControl, "stream window overflow"}
+				}
+			}
+		case http2SettingMaxFrameSize:
+			that.peerMaxFrameSize = s.Val
+		}
+	}
+	that.flushAllStreams()
+	return nil
+}
+
+func (that *http2Conn) processWindowUpdate(h http2FrameHeader, payload []byte) error {
+	if len(payload) != 4 {
+		return http2ConnError{http2ErrCodeFrameSize, "invalid WINDOW_UPDATE length"}
+	}
+	incr := int64(binary.BigEndian.Uint32(payload) & 0x7fffffff)
+	if h.StreamID == 0 {
+		if incr == 0 {
+			return http2ConnError{http2ErrCodeProtocol, "zero WINDOW_UPDATE increment"}
+		}
+		if that.sendWindow += incr; that.sendWindow > http2MaxWindowSize {
+			return http2ConnError{http2ErrCodeFlowControl, "connection window overflow"}
+		}
+		that.flushAllStreams()
+		return nil
+	}
+	st, ok := that.streams[h.StreamID]
+	if !ok {
+		return nil
+	}
+	if incr == 0 {
+		return http2StreamError{h.StreamID, http2ErrCodeProtocol}
+	}
+	if st.sendWindow += incr; st.sendWindow > http2MaxWindowSize {
+		return http2StreamError{h.StreamID, http2ErrCodeFlowControl}
+	}
+	that.flushStream(st)
+	return nil
+}
+
+func (that *http2Conn) flushAllStreams() {
+	for _, st := range that.streams {
+		if len(st.pending) > 0 || (st.endPending && !st.endSent) {
+			that.flushStream(st)
+		}
+	}
+}
+
+// flushStream sends as much pending DATA as the flow-control windows allow.
+func (that *http2Conn) flushStream(st *http2Stream) {
+	for len(st.pending) > 0 {
+		n := int64(len(st.pending))
+		for _, limit := range []int64{int64(that.peerMaxFrameSize), that.sendWindow, st.sendWindow} {
+			if limit < n {
+				n = limit
+			}
+		}
+		if n <= 0 {
+			return
+		}
+		var flags uint8
+		if n == int64(len(st.pending)) && st.endPending {
+			flags = http2FlagEndStream
+		}
+		that.outBuf = appendHttp2Frame(that.outBuf, http2FrameData, flags, st.id, st.pending[:n])
+		st.pending = st.pending[n:]
+		that.sendWindow -= n
+		st.sendWindow -= n
+		if flags == http2FlagEndStream {
+			that.endStream(st)
+			return
+		}
+	}
+	if st.endPending && !st.endSent {
+		that.outBuf = appendHttp2Frame(that.outBuf, http2FrameData, http2FlagEndStream, st.id, nil)
+		that.endStream(st)
+	}
+}
+
+func (that *http2Conn) endStream(st *http2Stream) {
+	st.endSent = true
+	st.pending = nil
+	if st.state == http2StateHalfClosedRemote {
+		st.state = http2StateClosed
+		delete(that.streams, st.id)
+	}
+}
+
+// writeHeaders encodes the fields and splits the block into HEADERS and CONTINUATION frames.
+func (that *http2Conn) writeHeaders(st *http2Stream, fields []hpack.HeaderField, endStream bool) {
+	that.encBuf.Reset()
+	for _, f := range fields {
+		that.encoder.WriteField(f)
+	}
+	block := that.encBuf.Bytes()
+	typ := http2FrameHeaders
+	for first := true; first || len(block) > 0; first = false {
+		chunk := block
+		if uint32(len(chunk)) > that.peerMaxFrameSize {
+			chunk = chunk[:that.peerMaxFrameSize]
+		}
+		block = block[len(chunk):]
+		var flags uint8
+		if first && endStream {
+			flags |= http2FlagEndStream
+		}
+		if len(block) == 0 {
+			flags |= http2FlagEndHeaders
+		}
+		that.outBuf = appendHttp2Frame(that.outBuf, typ, flags, st.id, chunk)
+		typ = http2FrameContinuation
+	}
+	if endStream {
+		that.endStream(st)
+	}
+}
+
+// writeData queues response body of a stream and sends what the windows allow.
+func (that *http2Conn) writeData(st *http2Stream, data []byte, endStream bool) {
+	if st.endSent {
+		return
+	}
+	st.pending = append(st.pending, data...)
+	st.endPending = st.endPending || endStream
+	that.flushStream(st)
+}
+
+// parseFields validates the decoded request header block.
+func (that *http2Stream) parseFields(fields []hpack.HeaderField) error {
+	var (
+		sawRegular bool
+		cookies    []string
+	)
+	for _, f := range fields {
+		if f.IsPseudo() {
+			if sawRegular {
+				return http2StreamError{that.id, http2ErrCodeProtocol}
+			}
+			switch f.Name {
+			case ":method":
+				that.method = f.Value
+			case ":scheme":
+				that.scheme = f.Value
+			case ":authority":
+				that.authority = f.Value
+			case ":path":
+				that.path = f.Value
+			default:
+				return http2StreamError{that.id, http2ErrCodeProtocol}
+			}
+			continue
+		}
+		sawRegular = true
+		if strings.ToLower(f.Name) != f.Name {
+			return http2StreamError{that.id, http2ErrCodeProtocol}
+		}
+		switch f.Name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			return http2StreamError{that.id, http2ErrCodeProtocol}
+		case "te":
+			if f.Value != "trailers" {
+				return http2StreamError{that.id, http2ErrCodeProtocol}
+			}
+		case "cookie":
+			cookies = append(cookies, f.Value)
+			continue
+		}
+		that.header.Add(http.CanonicalHeaderKey(f.Name), f.Value)
+	}
+	if len(cookies) > 0 {
+		that.header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+	if that.method == emptyString {
+		return http2StreamError{that.id, http2ErrCodeProtocol}
+	}
+	if that.method == http.MethodConnect {
+		if that.path != emptyString || that.scheme != emptyString || that.authority == emptyString {
+			return http2StreamError{that.id, http2ErrCodeProtocol}
+		}
+	} else if that.path == emptyString || that.scheme == emptyString {
+		return http2StreamError{that.id, http2ErrCodeProtocol}
+	}
+	return nil
+}
+
+func (that *http2Conn) newRequest(st *http2Stream) (*http.Request, error) {
+	var (
+		u   *url.URL
+		err error
+	)
+	if st.method == http.MethodConnect {
+		u = &url.URL{Host: st.authority}
+	} else if u, err = url.ParseRequestURI(st.path); err != nil {
+		return nil, err
+	}
+	authority := st.authority
+	if authority == emptyString {
+		authority = st.header.Get(host)
+	}
+	req := &http.Request{
+		Method:     st.method,
+		URL:        u,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     st.header,
+		Host:       authority,
+		RequestURI: st.path,
+		TLS:        that.tlsState,
+		Trailer:    st.trailer,
+	}
+	if addr := that.ctx.Conn.RemoteAddr(); addr != nil {
+		req.RemoteAddr = addr.String()
+	}
+	if len(st.body) > 0 {
+		req.ContentLength = int64(len(st.body))
+		req.Body = io.NopCloser(bytes.NewReader(st.body))
+	} else {
+		req.Body = http.NoBody
+	}
+	return req, nil
+}
+
+// dispatch serves a stream whose request has been fully received.
+func (that *http2Conn) dispatch(st *http2Stream) {
+	req, err := that.newRequest(st)
+	if err != nil {
+		that.resetStream(http2StreamError{st.id, http2ErrCodeProtocol})
+		return
+	}
+	that.serveStream(st, req)
+}
+
+func (that *http2Conn) serveStream(st *http2Stream, req *http.Request) {
+	req = withTraceParent(req)
+	res := newHttp2Response(that, st, req)
+	that.server.handler.ServeHTTP(res, req)
+	res.finish()
+	that.releaseBody(st)
+}
+
+// headerFields converts the response header to HPACK fields.
+func headerFields(status int, h http.Header) []hpack.HeaderField {
+	fields := make([]hpack.HeaderField, 0, len(h)+1)
+	fields = append(fields, hpack.HeaderField{Name: ":status", Value: strconv.Itoa(status)})
+	for key, values := range h {
+		name := strings.ToLower(key)
+		switch name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			continue
+		}
+		for _, v := range values {
+			fields = append(fields, hpack.HeaderField{Name: name, Value: v})
+		}
+	}
+	return fields
+}
@@ -0,0 +1,227 @@
+package gkhttp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/moqsien/gknet/iface"
+)
+
+func newTestHttp2Conn() *http2Conn {
+	return newHttp2Conn(&Server{options: nullOpts}, &iface.Context{})
+}
+
+func http2Frame(typ, flags uint8, streamID uint32, payload []byte) (http2FrameHeader, []byte) {
+	f := appendHttp2Frame(nil, typ, flags, streamID, payload)
+	return parseHttp2FrameHeader(f), f[http2FrameHeadLen:]
+}
+
+func settingsPayload(settings ...http2Setting) []byte {
+	return appendHttp2Settings(nil, settings...)[http2FrameHeadLen:]
+}
+
+func windowUpdatePayload(incr uint32) []byte {
+	return appendHttp2WindowUpdate(nil, 0, incr)[http2FrameHeadLen:]
+}
+
+// checkHttp2Error checks that err is a connection error(stream == 0) or a stream error with code.
+func checkHttp2Error(t *testing.T, err error, stream uint32, code http2ErrCode) {
+	t.Helper()
+	switch e := err.(type) {
+	case nil:
+		if code != http2ErrCodeNo {
+			t.Fatalf("no error, want %s", code)
+		}
+	case http2ConnError:
+		if stream != 0 || e.Code != code {
+			t.Fatalf("got %v, want %s on stream %d", e, code, stream)
+		}
+	case http2StreamError:
+		if e.StreamID != stream || e.Code != code {
+			t.Fatalf("got %v, want %s on stream %d", e, code, stream)
+		}
+	default:
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestHttp2Settings(t *testing.T) {
+	cases := []struct {
+		name     string
+		flags    uint8
+		streamID uint32
+		payload  []byte
+		code     http2ErrCode
+		window   int64
+		frame    uint32
+	}{
+		{"empty", 0, 0, nil, http2ErrCodeNo, 65535, 16384},
+		{"window and frame size", 0, 0, settingsPayload(
+			http2Setting{http2SettingInitialWindowSize, 1 << 20},
+			http2Setting{http2SettingMaxFrameSize, 1 << 15},
+		), http2ErrCodeNo, 1 << 20, 1 << 15},
+		{"max window", 0, 0, settingsPayload(http2Setting{http2SettingInitialWindowSize, 1<<31 - 1}), http2ErrCodeNo, 1<<31 - 1, 16384},
+		{"unknown setting", 0, 0, settingsPayload(http2Setting{0xff, 1}), http2ErrCodeNo, 65535, 16384},
+		{"ack", http2FlagAck, 0, nil, http2ErrCodeNo, 65535, 16384},
+		{"ack with payload", http2FlagAck, 0, settingsPayload(http2Setting{http2SettingEnablePush, 0}), http2ErrCodeFrameSize, 0, 0},
+		{"non-zero stream", 0, 1, nil, http2ErrCodeProtocol, 0, 0},
+		{"bad length", 0, 0, []byte{0, 1, 0}, http2ErrCodeFrameSize, 0, 0},
+		{"enable push", 0, 0, settingsPayload(http2Setting{http2SettingEnablePush, 2}), http2ErrCodeProtocol, 0, 0},
+		{"window too large", 0, 0, settingsPayload(http2Setting{http2SettingInitialWindowSize, 1 << 31}), http2ErrCodeFlowControl, 0, 0},
+		{"frame size too small", 0, 0, settingsPayload(http2Setting{http2SettingMaxFrameSize, 16383}), http2ErrCodeProtocol, 0, 0},
+		{"frame size too large", 0, 0, settingsPayload(http2Setting{http2SettingMaxFrameSize, 1 << 24}), http2ErrCodeProtocol, 0, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h2 := newTestHttp2Conn()
+			h, payload := http2Frame(http2FrameSettings, c.flags, c.streamID, c.payload)
+			err := h2.processFrame(h, payload)
+			checkHttp2Error(t, err, 0, c.code)
+			if err != nil {
+				return
+			}
+			if h2.peerInitialWindow != c.window || h2.peerMaxFrameSize != c.frame {
+				t.Fatalf("got window %d frame size %d", h2.peerInitialWindow, h2.peerMaxFrameSize)
+			}
+			var ack []byte
+			if c.flags&http2FlagAck == 0 {
+				ack = appendHttp2Frame(nil, http2FrameSettings, http2FlagAck, 0, nil)
+			}
+			if !bytes.Equal(h2.outBuf, ack) {
+				t.Fatalf("wrote %x, want %x", h2.outBuf, ack)
+			}
+		})
+	}
+}
+
+func TestHttp2InitialWindowSize(t *testing.T) {
+	cases := []struct {
+		name       string
+		sendWindow int64
+		window     uint32
+		code       http2ErrCode
+		sent       int
+		want       int64
+	}{
+		{"grows", 0, 65535 + 10, http2ErrCodeNo, 10, 0},
+		{"shrinks below zero", 0, 65535 - 10, http2ErrCodeNo, 0, -10},
+		{"overflow", 1<<31 - 1, 65536, http2ErrCodeFlowControl, 0, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h2 := newTestHttp2Conn()
+			st := h2.newStream(1)
+			st.sendWindow = c.sendWindow
+			st.pending = make([]byte, 100)
+			err := h2.applySettings([]http2Setting{{http2SettingInitialWindowSize, c.window}})
+			checkHttp2Error(t, err, 0, c.code)
+			if err != nil {
+				return
+			}
+			if sent := 100 - len(st.pending); sent != c.sent || st.sendWindow != c.want {
+				t.Fatalf("sent %d with window %d left, want %d and %d", sent, st.sendWindow, c.sent, c.want)
+			}
+		})
+	}
+}
+
+func TestHttp2WindowUpdate(t *testing.T) {
+	cases := []struct {
+		name     string
+		streamID uint32
+		payload  []byte
+		err      uint32
+		code     http2ErrCode
+		conn     int64
+		stream   int64
+	}{
+		{"connection", 0, windowUpdatePayload(100), 0, http2ErrCodeNo, 65535 + 100, 65535},
+		{"stream", 1, windowUpdatePayload(100), 0, http2ErrCodeNo, 65535, 65535 + 100},
+		{"reserved bit", 1, []byte{0x80, 0, 0, 100}, 0, http2ErrCodeNo, 65535, 65535 + 100},
+		{"closed stream", 3, windowUpdatePayload(100), 0, http2ErrCodeNo, 65535, 65535},
+		{"bad length", 0, []byte{0, 0, 1}, 0, http2ErrCodeFrameSize, 0, 0},
+		{"zero on connection", 0, windowUpdatePayload(0), 0, http2ErrCodeProtocol, 0, 0},
+		{"zero on stream", 1, windowUpdatePayload(0), 1, http2ErrCodeProtocol, 0, 0},
+		{"connection overflow", 0, windowUpdatePayload(1<<31 - 65535), 0, http2ErrCodeFlowControl, 0, 0},
+		{"stream overflow", 1, windowUpdatePayload(1<<31 - 65535), 1, http2ErrCodeFlowControl, 0, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h2 := newTestHttp2Conn()
+			st := h2.newStream(1)
+			h, payload := http2Frame(http2FrameWindowUpdate, 0, c.streamID, c.payload)
+			err := h2.processFrame(h, payload)
+			checkHttp2Error(t, err, c.err, c.code)
+			if err != nil {
+				return
+			}
+			if h2.sendWindow != c.conn || st.sendWindow != c.stream {
+				t.Fatalf("got windows %d/%d, want %d/%d", h2.sendWindow, st.sendWindow, c.conn, c.stream)
+			}
+		})
+	}
+}
+
+func TestHttp2WindowUpdateFlushes(t *testing.T) {
+	h2 := newTestHttp2Conn()
+	st := h2.newStream(1)
+	h2.sendWindow = 0
+	h2.writeData(st, []byte("hello"), false)
+	if len(h2.outBuf) != 0 {
+		t.Fatal("DATA is sent without window")
+	}
+	h, payload := http2Frame(http2FrameWindowUpdate, 0, 0, windowUpdatePayload(3))
+	if err := h2.processFrame(h, payload); err != nil {
+		t.Fatal(err)
+	}
+	want := appendHttp2Frame(nil, http2FrameData, 0, 1, []byte("hel"))
+	if !bytes.Equal(h2.outBuf, want) || string(st.pending) != "lo" {
+		t.Fatalf("wrote %x with %q pending", h2.outBuf, st.pending)
+	}
+}
+
+func TestHttp2DataFlowControl(t *testing.T) {
+	padded := append([]byte{3}, "abc\x00\x00\x00"...)
+	cases := []struct {
+		name       string
+		streamID   uint32
+		flags      uint8
+		payload    []byte
+		connWindow int64
+		maxBody    int
+		err        uint32
+		code       http2ErrCode
+		recv       int64 // connection window left
+		refunds    uint32
+		body       string
+	}{
+		{"data", 1, 0, []byte("hello"), 65535, 0, 0, http2ErrCodeNo, 65535 - 5, 0, "hello"},
+		{"padding is refunded", 1, http2FlagPadded, padded, 65535, 0, 0, http2ErrCodeNo, 65535 - 7, 4, "abc"},
+		{"stream 0", 0, 0, []byte("hello"), 65535, 0, 0, http2ErrCodeProtocol, 65535, 0, ""},
+		{"connection window", 1, 0, []byte("hello"), 4, 0, 0, http2ErrCodeFlowControl, 4, 0, ""},
+		{"stream window", 1, 0, []byte("hello"), 65535, 3, 1, http2ErrCodeFlowControl, 65535 - 5, 5, ""},
+		{"closed stream", 3, 0, []byte("hello"), 65535, 0, 3, http2ErrCodeStreamClosed, 65535 - 5, 5, ""},
+		{"idle stream", 7, 0, []byte("hello"), 65535, 0, 0, http2ErrCodeProtocol, 65535 - 5, 5, ""},
+		{"over max body", 1, 0, make([]byte, 101), 1 << 20, 100, 0, http2ErrCodeNo, 1<<20 - 101, 101, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h2 := newTestHttp2Conn()
+			if c.maxBody > 0 {
+				h2.maxBody = c.maxBody
+			}
+			h2.recvWindow = c.connWindow
+			h2.lastStreamID = 5
+			st := h2.newStream(1)
+			h, payload := http2Frame(http2FrameData, c.flags, c.streamID, c.payload)
+			err := h2.processFrame(h, payload)
+			checkHttp2Error(t, err, c.err, c.code)
+			if h2.recvWindow != c.recv || h2.refunds != c.refunds {
+				t.Fatalf("got window %d refunds %d, want %d and %d", h2.recvWindow, h2.refunds, c.recv, c.refunds)
+			}
+			if string(st.body) != c.body {
+				t.Fatalf("got body %q, want %q", st.body, c.body)
+			}
+		})
+	}
+}
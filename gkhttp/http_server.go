@@ -1,13 +1,17 @@
 package gkhttp
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"net"
 	"net/http"
+	"strings"
 
+	"github.com/moqsien/gknet/conn"
 	"github.com/moqsien/gknet/engine"
 	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/metrics"
 	"github.com/moqsien/gknet/socket"
 	"github.com/moqsien/gknet/utils"
 )
@@ -37,6 +41,11 @@ func (that *GkEventHandler) OnTrack(c *iface.Context) (err error) {
 	if that.httpServer.handler == nil {
 		return errors.New("[HttpServer] no handler was found!")
 	}
+	if that.httpServer.options.EnableHTTP2 {
+		if h2 := that.detectHttp2(c); h2 != nil {
+			return h2.OnTrack(c)
+		}
+	}
 	var req *http.Request
 	if that.httpServer.options.DoFast {
 		req, err = ReadFastRequest(c.Reader)
@@ -46,19 +55,71 @@ func (that *GkEventHandler) OnTrack(c *iface.Context) (err error) {
 	if err != nil {
 		return err
 	}
-	res := NewResponse(req, c.Conn, c.ReadWriter)
-	that.httpServer.handler.ServeHTTP(res, req)
+	if addr := c.Conn.RemoteAddr(); addr != nil {
+		// the client address, or the source of a PROXY protocol header.
+		req.RemoteAddr = addr.String()
+	}
+	if that.httpServer.options.EnableHTTP2 && isHttp2Upgrade(c, req) {
+		return that.upgradeToHttp2(c, req)
+	}
+	r := withTraceParent(req)
+	if req.Header.Get("Upgrade") != emptyString || strings.Contains(req.Header.Get("Accept"), eventStream) {
+		// protocol upgraders(websocket, etc.) and event streams need the event-loop context of the conn.
+		r = r.WithContext(context.WithValue(r.Context(), ConnContextKey, c))
+	}
+	res := NewResponse(r, c.Conn, c.ReadWriter)
+	res.raw, _ = c.RawConn.(*conn.Conn)
+	res.compress = that.httpServer.options.Compression
+	that.httpServer.handler.ServeHTTP(res, r)
+	switched := that.handlerSwitched(c)
+	if switched {
+		// the conn now speaks another protocol, nothing should be written as HTTP/1.x.
+		res.hijacked.setTrue()
+	}
 	res.FinishRequest()
 	if that.httpServer.options.DoFast {
 		FreeRequest(req)
 	}
 	FreeResponse(res)
+	if switched && c.Reader.Buffered() > 0 {
+		// data arrived along with the upgrade request belongs to the new protocol.
+		return c.RawConn.(*conn.Conn).Handler.OnTrack(c)
+	}
 	return nil
 }
 
+// handlerSwitched reports whether the handler of the conn has been replaced during ServeHTTP.
+func (that *GkEventHandler) handlerSwitched(c *iface.Context) bool {
+	rc, ok := c.RawConn.(*conn.Conn)
+	return ok && rc.Handler != iface.IEventHandler(that)
+}
+
+type contextKey struct {
+	name string
+}
+
+// ConnContextKey is a context key. It can be used in HTTP handlers with
+// Request.Context().Value(ConnContextKey) to access the *iface.Context of the conn.
+// The value is only set for requests with an "Upgrade" header, or accepting text/event-stream.
+var ConnContextKey = &contextKey{"gkhttp-conn"}
+
+// ConnContext returns the *iface.Context of the conn the request arrived on, or nil.
+func ConnContext(r *http.Request) *iface.Context {
+	c, _ := r.Context().Value(ConnContextKey).(*iface.Context)
+	return c
+}
+
 type Opts struct {
 	*iface.Options
-	DoFast bool
+	DoFast       bool
+	Compression  *CompressOptions     // compress HTTP/1.x responses, nil disables compression
+	ListenConfig *socket.ListenConfig // used by Listen, e.g. for TCP_DEFER_ACCEPT
+	// EnableHTTP2 serves h2 over TLS and h2c on plaintext conns. gkhttp/proxy, SSE and
+	// websocket need the conn of a HTTP/1.x request, they refuse HTTP/2 requests.
+	EnableHTTP2 bool
+	// MaxRequestBodySize caps the buffered request body of a HTTP/2 stream(4MB by default),
+	// larger requests get a 413.
+	MaxRequestBodySize int
 }
 
 type Server struct {
@@ -90,13 +151,32 @@ func (that *Server) GetListener() iface.IListener {
 	return that.listener
 }
 
+// Connections returns snapshots of the open conns, see gkhttp/debug.
+func (that *Server) Connections() []iface.ConnInfo {
+	return that.engine.Connections()
+}
+
+// CloseConnection closes the conn with the id, it reports whether the conn was found.
+func (that *Server) CloseConnection(id uint64) bool {
+	return that.engine.CloseConnection(id)
+}
+
+// Metrics returns the counters of the engine, the registry is an http.Handler.
+func (that *Server) Metrics() *metrics.Registry {
+	return that.engine.Metrics()
+}
+
 func (that *Server) Close() {
 	that.engine.Stop()
 }
 
 func (that *Server) Listen(network, address string) (iface.IListener, error) {
 	var err error
-	that.listener, err = socket.Listen(network, address)
+	if that.options.ListenConfig != nil {
+		that.listener, err = that.options.ListenConfig.Listen(network, address)
+	} else {
+		that.listener, err = socket.Listen(network, address)
+	}
 	return that.listener, err
 }
 
@@ -125,6 +205,9 @@ func (that *Server) ServeTLS(certFile, keyFile string) (err error) {
 		config = that.options.TLSConfig
 	}
 
+	if that.options.EnableHTTP2 && !utils.StrSliceContains(config.NextProtos, http2NextProto) {
+		config.NextProtos = append([]string{http2NextProto}, config.NextProtos...)
+	}
 	if !utils.StrSliceContains(config.NextProtos, "http/1.1") {
 		config.NextProtos = append(config.NextProtos, "http/1.1")
 	}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/moqsien/gknet/conn"
+	"github.com/moqsien/gknet/eloop"
+)
+
+// Backend is an upstream HTTP/1.1 server.
+type Backend struct {
+	Addr      string // host:port
+	pending   int32
+	fails     int32
+	downUntil int64
+	pools     sync.Map // *eloop.Eloop -> *idlePool
+}
+
+// Pending returns the number of requests in flight to the backend.
+func (that *Backend) Pending() int {
+	return int(atomic.LoadInt32(&that.pending))
+}
+
+// Healthy reports whether the backend is not marked down by the passive health checks.
+func (that *Backend) Healthy() bool {
+	return time.Now().UnixNano() >= atomic.LoadInt64(&that.downUntil)
+}
+
+func (that *Backend) fail(opts *Options) {
+	if atomic.AddInt32(&that.fails, 1) >= int32(opts.MaxFails) {
+		atomic.StoreInt32(&that.fails, 0)
+		atomic.StoreInt64(&that.downUntil, time.Now().Add(opts.FailTimeout).UnixNano())
+	}
+}
+
+func (that *Backend) succeed() {
+	atomic.StoreInt32(&that.fails, 0)
+}
+
+// idlePool keeps the idle keep-alive conns of a backend on one loop.
+type idlePool struct {
+	conns []*upstream
+	lock  sync.Mutex
+}
+
+func (that *Backend) pool(loop *eloop.Eloop) *idlePool {
+	if p, ok := that.pools.Load(loop); ok {
+		return p.(*idlePool)
+	}
+	p, _ := that.pools.LoadOrStore(loop, &idlePool{})
+	return p.(*idlePool)
+}
+
+// idle returns an idle conn on the loop, or nil.
+func (that *Backend) idle(loop *eloop.Eloop) *upstream {
+	p := that.pool(loop)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	n := len(p.conns)
+	if n == 0 {
+		return nil
+	}
+	u := p.conns[n-1]
+	p.conns[n-1] = nil
+	p.conns = p.conns[:n-1]
+	return u
+}
+
+// dial connects to the backend without blocking, done gets the new conn or the error.
+func (that *Backend) dial(loop *eloop.Eloop, opts *Options, done func(u *upstream, err error)) error {
+	u := &upstream{backend: that, pool: that.pool(loop)}
+	return loop.Dial("tcp", that.Addr, u, opts.DialTimeout, func(c *conn.Conn, err error) {
+		if err != nil {
+			done(nil, err)
+			return
+		}
+		u.raw = c
+		done(u, nil)
+	})
+}
+
+// put keeps u for the next request, it reports false if the pool is full.
+func (that *idlePool) put(u *upstream, max int) bool {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	if len(that.conns) >= max {
+		return false
+	}
+	that.conns = append(that.conns, u)
+	return true
+}
+
+func (that *idlePool) remove(u *upstream) {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	for i, c := range that.conns {
+		if c == u {
+			that.conns = append(that.conns[:i], that.conns[i+1:]...)
+			return
+		}
+	}
+}
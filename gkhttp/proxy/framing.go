@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"errors"
+)
+
+var errChunked = errors.New("[Proxy] malformed chunked body")
+
+type framing int
+
+const (
+	noBody      framing = iota
+	lengthBody          // Content-Length
+	chunkedBody         // Transfer-Encoding: chunked
+	closeBody           // ends when the upstream closes the conn
+)
+
+// states of a chunked body.
+const (
+	chunkSize = iota
+	chunkExt
+	chunkData
+	chunkDataEnd
+	chunkTrailer
+)
+
+const maxChunkSize = 1 << 40
+
+// bodyScanner finds the end of a message body in the bytes read from a conn.
+// Bodies are forwarded as they are, so chunked bodies keep their framing.
+type bodyScanner struct {
+	framing framing
+	remain  int64 // bytes left of a length body or of the current chunk
+	state   int
+	size    int64
+	digits  int
+	line    int // length of the current trailer line
+}
+
+func (that *bodyScanner) reset(f framing, length int64) {
+	*that = bodyScanner{framing: f, remain: length}
+	if f == lengthBody && length <= 0 {
+		that.framing = noBody
+	}
+}
+
+// done reports whether the whole body has been scanned.
+func (that *bodyScanner) done() bool {
+	return that.framing == noBody
+}
+
+// scan returns how many bytes at the head of b belong to the body.
+func (that *bodyScanner) scan(b []byte) (n int, err error) {
+	switch that.framing {
+	case noBody:
+		return 0, nil
+	case closeBody:
+		return len(b), nil
+	case lengthBody:
+		n = len(b)
+		if int64(n) > that.remain {
+			n = int(that.remain)
+		}
+		if that.remain -= int64(n); that.remain == 0 {
+			that.framing = noBody
+		}
+		return n, nil
+	}
+	for n < len(b) && that.framing == chunkedBody {
+		c := b[n]
+		switch that.state {
+		case chunkSize:
+			switch {
+			case c == '\n':
+				if err = that.endSizeLine(); err != nil {
+					return
+				}
+			case c == ';' || c == ' ' || c == '\t':
+				that.state = chunkExt
+			case c == '\r':
+			default:
+				d := unhex(c)
+				if d < 0 || that.size > maxChunkSize>>4 {
+					return n, errChunked
+				}
+				that.size = that.size<<4 | int64(d)
+				that.digits++
+			}
+			n++
+		case chunkExt:
+			if c == '\n' {
+				if err = that.endSizeLine(); err != nil {
+					return
+				}
+			}
+			n++
+		case chunkData:
+			m := len(b) - n
+			if int64(m) > that.remain {
+				m = int(that.remain)
+			}
+			n += m
+			if that.remain -= int64(m); that.remain == 0 {
+				that.state = chunkDataEnd
+			}
+		case chunkDataEnd:
+			switch c {
+			case '\r':
+			case '\n':
+				that.state = chunkSize
+			default:
+				return n, errChunked
+			}
+			n++
+		case chunkTrailer:
+			switch c {
+			case '\r':
+			case '\n':
+				if that.line == 0 {
+					that.framing = noBody
+				}
+				that.line = 0
+			default:
+				that.line++
+			}
+			n++
+		}
+	}
+	return
+}
+
+func (that *bodyScanner) endSizeLine() error {
+	if that.digits == 0 {
+		return errChunked
+	}
+	if that.size == 0 {
+		that.state = chunkTrailer
+	} else {
+		that.remain, that.state = that.size, chunkData
+	}
+	that.size, that.digits = 0, 0
+	return nil
+}
+
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c - 'a' + 10)
+	case 'A' <= c && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
@@ -0,0 +1,264 @@
+/*
+proxy is a reverse proxy for gkhttp. Upstream conns are registered on the event loop of
+the client conn and kept alive in per-loop pools, bodies are streamed in both directions.
+*/
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/moqsien/gknet/conn"
+	"github.com/moqsien/gknet/eloop"
+	"github.com/moqsien/gknet/gkhttp"
+	"github.com/moqsien/gknet/iface"
+)
+
+var (
+	ErrNoBackend          = errors.New("[Proxy] no backend")
+	ErrUnsupportedBackend = errors.New("[Proxy] only http backends are supported")
+)
+
+type Strategy int
+
+const (
+	RoundRobin   Strategy = iota
+	LeastPending          // the backend with the fewest requests in flight
+)
+
+type Options struct {
+	Strategy       Strategy
+	DialTimeout    time.Duration // 0 means 3s
+	MaxIdlePerLoop int           // idle conns kept per backend and event loop, 0 means 32
+	MaxFails       int           // failures in a row before a backend is marked down, 0 means 3
+	FailTimeout    time.Duration // how long a backend stays down, 0 means 10s
+}
+
+func (that *Options) setDefaults() {
+	if that.DialTimeout <= 0 {
+		that.DialTimeout = 3 * time.Second
+	}
+	if that.MaxIdlePerLoop <= 0 {
+		that.MaxIdlePerLoop = 32
+	}
+	if that.MaxFails <= 0 {
+		that.MaxFails = 3
+	}
+	if that.FailTimeout <= 0 {
+		that.FailTimeout = 10 * time.Second
+	}
+}
+
+// Proxy is an http.Handler forwarding requests to its backends. It must be served by
+// gkhttp over HTTP/1.x, and not be wrapped by writers without an Unwrap method.
+type Proxy struct {
+	backends []*Backend
+	options  Options
+	next     uint32
+}
+
+// New returns a Proxy for targets like "http://127.0.0.1:8080" or "127.0.0.1:8080".
+func New(targets []string, opts ...*Options) (*Proxy, error) {
+	if len(targets) == 0 {
+		return nil, ErrNoBackend
+	}
+	p := &Proxy{}
+	if len(opts) > 0 && opts[0] != nil {
+		p.options = *opts[0]
+	}
+	p.options.setDefaults()
+	for _, t := range targets {
+		addr := t
+		if strings.Contains(t, "://") {
+			u, err := url.Parse(t)
+			if err != nil {
+				return nil, err
+			}
+			if u.Scheme != "http" {
+				return nil, ErrUnsupportedBackend
+			}
+			addr = u.Host
+		}
+		if _, port, _ := net.SplitHostPort(addr); port == "" {
+			addr = net.JoinHostPort(strings.Trim(addr, "[]"), "80")
+		}
+		p.backends = append(p.backends, &Backend{Addr: addr})
+	}
+	return p, nil
+}
+
+// Backends returns the backends of the proxy.
+func (that *Proxy) Backends() []*Backend {
+	return that.backends
+}
+
+// choose picks a backend by the strategy, backends marked down are skipped unless all are down.
+func (that *Proxy) choose() *Backend {
+	n := len(that.backends)
+	start := int(atomic.AddUint32(&that.next, 1)-1) % n
+	var best *Backend
+	for pass := 0; pass < 2 && best == nil; pass++ {
+		for i := 0; i < n; i++ {
+			b := that.backends[(start+i)%n]
+			if pass == 0 && !b.Healthy() {
+				continue
+			}
+			if that.options.Strategy == RoundRobin {
+				return b
+			}
+			if best == nil || b.Pending() < best.Pending() {
+				best = b
+			}
+		}
+	}
+	return best
+}
+
+func (that *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 1 {
+		// the session takes over the conn of the request, a HTTP/2 stream has none.
+		http.Error(w, "[Proxy] HTTP/1.x is required", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	c := gkhttp.ContextOf(w, r)
+	var raw *conn.Conn
+	if c != nil {
+		raw, _ = c.RawConn.(*conn.Conn)
+	}
+	if raw == nil || raw.Detached() {
+		http.Error(w, "[Proxy] unsupported conn", http.StatusInternalServerError)
+		return
+	}
+	loop, ok := raw.Poller.Eloop.(*eloop.Eloop)
+	if !ok {
+		http.Error(w, "[Proxy] unsupported conn", http.StatusInternalServerError)
+		return
+	}
+	b := that.choose()
+	atomic.AddInt32(&b.pending, 1)
+	f, length := requestFraming(r)
+	s := &session{
+		proxy:     that,
+		backend:   b,
+		loop:      loop,
+		down:      raw,
+		downRef:   raw.Ref(),
+		ctx:       c,
+		highWater: loop.Engine.GetOptions().WriteHighWaterMark,
+		handler:   raw.Handler,
+		method:    r.Method,
+		closeDown: r.Close || hasToken(r.Header.Get("Connection"), "close"),
+	}
+	s.reqBody.reset(f, length)
+	s.head = that.requestHead(r, c, f, length)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// the response is written by the session, gkhttp sees the switch and writes nothing.
+	raw.Handler = s
+	if err := s.connect(); err != nil {
+		raw.Handler = s.handler
+		atomic.AddInt32(&b.pending, -1)
+		b.fail(&that.options)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}
+}
+
+func requestFraming(r *http.Request) (framing, int64) {
+	if (len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked") ||
+		hasToken(r.Header.Get("Transfer-Encoding"), "chunked") {
+		return chunkedBody, 0
+	}
+	if n, err := strconv.ParseInt(r.Header.Get("Content-Length"), 10, 64); err == nil && n > 0 {
+		return lengthBody, n
+	}
+	return noBody, 0
+}
+
+func (that *Proxy) requestHead(r *http.Request, c *iface.Context, f framing, length int64) []byte {
+	var b bytes.Buffer
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	proto := "HTTP/1.1"
+	if r.Proto == "HTTP/1.0" {
+		proto = r.Proto
+	}
+	b.WriteString(r.Method + " " + uri + " " + proto + "\r\n")
+
+	h := r.Header.Clone()
+	removeHopHeaders(h)
+	host := r.Host
+	if host == "" {
+		host = h.Get("Host")
+	}
+	h.Del("Host")
+	b.WriteString("Host: " + host + "\r\n")
+	if ip, _, err := net.SplitHostPort(c.Conn.RemoteAddr().String()); err == nil {
+		if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		h.Set("X-Forwarded-For", ip)
+	}
+	h.Set("X-Forwarded-Host", host)
+	if _, ok := c.Conn.(*tls.Conn); ok {
+		h.Set("X-Forwarded-Proto", "https")
+	} else {
+		h.Set("X-Forwarded-Proto", "http")
+	}
+	switch f {
+	case chunkedBody:
+		h.Del("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+	case lengthBody:
+		h.Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	h.Write(&b)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// hop-by-hop headers, see RFC 9110 section 7.6.1.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// hasToken reports whether the comma separated list v contains token.
+func hasToken(v, token string) bool {
+	for _, s := range strings.Split(v, ",") {
+		if strings.EqualFold(strings.TrimSpace(s), token) {
+			return true
+		}
+	}
+	return false
+}
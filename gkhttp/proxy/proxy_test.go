@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/moqsien/gknet/gkhttp"
+	"github.com/moqsien/gknet/iface"
+)
+
+// testBackend answers the requests on its i-th conn as actions[i] says:
+//
+//	"ok"    a response with a Content-Length
+//	"close" a response with Connection: close
+//	"eof"   a response ending with the conn
+//	"drop"  the conn is closed without a response, like at a keep-alive timeout
+type testBackend struct {
+	ln      net.Listener
+	actions [][]string
+	conns   int32
+}
+
+func newTestBackend(t *testing.T, actions [][]string) *testBackend {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBackend{ln: ln, actions: actions}
+	go b.serve()
+	return b
+}
+
+func (that *testBackend) serve() {
+	for {
+		c, err := that.ln.Accept()
+		if err != nil {
+			return
+		}
+		i := int(atomic.AddInt32(&that.conns, 1)) - 1
+		var actions []string
+		if i < len(that.actions) {
+			actions = that.actions[i]
+		}
+		go that.serveConn(c, actions)
+	}
+}
+
+func (that *testBackend) serveConn(c net.Conn, actions []string) {
+	defer c.Close()
+	rd := bufio.NewReader(c)
+	for _, action := range actions {
+		req, err := http.ReadRequest(rd)
+		if err != nil {
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		text := req.Method + " " + req.URL.Path + " " + string(body)
+		switch action {
+		case "ok":
+			io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: "+strconv.Itoa(len(text))+"\r\n\r\n"+text)
+		case "close":
+			io.WriteString(c, "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: "+strconv.Itoa(len(text))+"\r\n\r\n"+text)
+			return
+		case "eof":
+			io.WriteString(c, "HTTP/1.1 200 OK\r\n\r\n"+text)
+			return
+		case "drop":
+			return
+		}
+	}
+}
+
+type testRequest struct {
+	method, path, body string
+	status             int
+	close              bool // the proxy closes the client conn after the response
+}
+
+func TestSessionKeepAlive(t *testing.T) {
+	cases := []struct {
+		name     string
+		actions  [][]string
+		requests []testRequest
+		conns    int32 // conns dialed to the backend
+	}{
+		{"reused", [][]string{{"ok", "ok", "ok"}}, []testRequest{
+			{"GET", "/a", "", 200, false},
+			{"POST", "/b", "body", 200, false},
+			{"GET", "/c", "", 200, false},
+		}, 1},
+		{"backend closes", [][]string{{"close"}, {"ok"}}, []testRequest{
+			{"GET", "/a", "", 200, false},
+			{"GET", "/b", "", 200, false},
+		}, 2},
+		{"body ends with the conn", [][]string{{"eof"}}, []testRequest{
+			{"GET", "/a", "", 200, true},
+		}, 1},
+		{"retried after idle close", [][]string{{"ok", "drop"}, {"ok"}}, []testRequest{
+			{"GET", "/a", "", 200, false},
+			{"GET", "/b", "", 200, false},
+		}, 2},
+		{"not retried with a body", [][]string{{"ok", "drop"}}, []testRequest{
+			{"GET", "/a", "", 200, false},
+			{"POST", "/b", "body", 502, true},
+		}, 1},
+		{"not retried on a new conn", [][]string{{"drop"}}, []testRequest{
+			{"GET", "/a", "", 502, true},
+		}, 1},
+	}
+	mux := http.NewServeMux()
+	backends := make([]*testBackend, len(cases))
+	for i, c := range cases {
+		backends[i] = newTestBackend(t, c.actions)
+		defer backends[i].ln.Close()
+		p, err := New([]string{backends[i].ln.Addr().String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux.Handle("/"+strconv.Itoa(i)+"/", p)
+	}
+	s := gkhttp.NewHttpServer(mux, &gkhttp.Opts{Options: &iface.Options{NumOfLoops: 1}})
+	ln, err := s.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	defer s.Close()
+
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cn.Close()
+			cn.SetDeadline(time.Now().Add(5 * time.Second))
+			rd := bufio.NewReader(cn)
+			prefix := "/" + strconv.Itoa(i)
+			for _, r := range c.requests {
+				req, _ := http.NewRequest(r.method, "http://backend"+prefix+r.path, strings.NewReader(r.body))
+				if err = req.Write(cn); err != nil {
+					t.Fatal(err)
+				}
+				resp, err := http.ReadResponse(rd, req)
+				if err != nil {
+					t.Fatalf("%s %s: %v", r.method, r.path, err)
+				}
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatalf("%s %s: %v", r.method, r.path, err)
+				}
+				if resp.StatusCode != r.status || resp.Close != r.close {
+					t.Fatalf("%s %s: status %d close %v, want %d and %v", r.method, r.path, resp.StatusCode, resp.Close, r.status, r.close)
+				}
+				if want := r.method + " " + prefix + r.path + " " + r.body; r.status == 200 && string(body) != want {
+					t.Fatalf("got body %q, want %q", body, want)
+				}
+			}
+			if n := atomic.LoadInt32(&backends[i].conns); n != c.conns {
+				t.Fatalf("%d conns dialed, want %d", n, c.conns)
+			}
+		})
+	}
+}
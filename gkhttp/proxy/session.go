@@ -0,0 +1,519 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/moqsien/gknet/conn"
+	"github.com/moqsien/gknet/eloop"
+	"github.com/moqsien/gknet/iface"
+)
+
+const maxHeadSize = 64 << 10
+
+var (
+	errBadResponse  = errors.New("[Proxy] malformed response from backend")
+	errHeadTooLarge = errors.New("[Proxy] response header from backend is too large")
+	headEnd         = []byte("\r\n\r\n")
+)
+
+// session is one proxied request. It replaces the handler of the client conn until the
+// response has been forwarded, then the previous handler takes over again.
+type session struct {
+	proxy     *Proxy
+	backend   *Backend
+	up        *upstream
+	head      []byte // header of the request
+	retry     bool   // the request has no body and up came from the idle pool, see upstreamClosed
+	loop      *eloop.Eloop
+	down      *conn.Conn
+	downRef   conn.Ref // the writes to the client are posted to its mailbox
+	ctx       *iface.Context
+	handler   iface.IEventHandler // handler of the client conn before the session
+	method    string
+	closeDown bool // close the client conn after the response
+	reqBody   bodyScanner
+	respHead  []byte
+	headSent  bool // the final response header has been forwarded
+	respBody  bodyScanner
+	reusable  bool // the upstream conn may serve another request
+	finished  bool
+	lock      sync.Mutex
+	// the flow lock guards the fields below, OnWritable may run inside a write of the session.
+	flow      sync.Mutex
+	highWater int      // Options.WriteHighWaterMark, zero disables the flow control
+	upRef     conn.Ref // the upstream conn sending the response
+	upPaused  bool     // reading upRef waits for the client to take the output
+	downFull  bool     // the client conn is above its high water mark
+	posted    int      // bytes posted to the client conn and not written yet
+}
+
+// connect sends the request over an idle conn of the backend, or dials a new conn and
+// sends it once the conn is open. The lock must be held.
+func (that *session) connect() error {
+	for {
+		u := that.backend.idle(that.loop)
+		if u == nil {
+			break
+		}
+		if that.start(u, true) {
+			return nil
+		}
+	}
+	return that.backend.dial(that.loop, &that.proxy.options, that.dialed)
+}
+
+// dialed gets the conn dialed for the session, the request goes on on the client conn.
+func (that *session) dialed(u *upstream, err error) {
+	e := that.down.Post(func() error {
+		that.lock.Lock()
+		defer that.lock.Unlock()
+		switch {
+		case that.finished:
+			if u != nil {
+				u.close()
+			}
+		case err != nil || !that.start(u, false):
+			that.backend.fail(&that.proxy.options)
+			that.abort(http.StatusBadGateway)
+		}
+		return nil
+	})
+	if e != nil && u != nil {
+		// the client conn has been recycled, the session ended in OnClose.
+		u.close()
+	}
+}
+
+// start sends the request over u, it reports false if u has been closed. The lock must
+// be held.
+func (that *session) start(u *upstream, reused bool) bool {
+	if !u.bind(that) {
+		return false
+	}
+	that.up = u
+	that.setUpstream(u.raw.Ref())
+	that.retry = reused && that.reqBody.done()
+	u.raw.AsyncWrite(that.head)
+	if err := that.forwardRequest(that.ctx.Reader); err != nil {
+		that.abort(http.StatusBadRequest)
+	}
+	return true
+}
+
+// readAll calls fn with everything buffered or readable in rd without blocking, until fn
+// returns an error or stop reports true.
+func readAll(rd *bufio.Reader, fn func(b []byte) error, stop func() bool) error {
+	for !stop() {
+		if rd.Buffered() == 0 {
+			if _, err := rd.Peek(1); err != nil {
+				return nil
+			}
+		}
+		b, _ := rd.Peek(rd.Buffered())
+		n, err := len(b), fn(b)
+		rd.Discard(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forwardRequest sends the request body read so far to the backend, bytes after the body
+// stay in rd for the next request. The lock must be held.
+func (that *session) forwardRequest(rd *bufio.Reader) error {
+	for !that.reqBody.done() {
+		if rd.Buffered() == 0 {
+			if _, err := rd.Peek(1); err != nil {
+				return nil
+			}
+		}
+		b, _ := rd.Peek(rd.Buffered())
+		n, err := that.reqBody.scan(b)
+		if n > 0 {
+			that.up.raw.AsyncWrite(append([]byte(nil), b[:n]...))
+			rd.Discard(n)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forwardResponse handles bytes from the backend. The lock must be held.
+func (that *session) forwardResponse(b []byte) error {
+	for len(b) > 0 && !that.finished {
+		if !that.headSent {
+			that.respHead = append(that.respHead, b...)
+			i := bytes.Index(that.respHead, headEnd)
+			if i < 0 {
+				if len(that.respHead) > maxHeadSize {
+					return errHeadTooLarge
+				}
+				return nil
+			}
+			head := that.respHead[:i+len(headEnd)]
+			b = that.respHead[i+len(headEnd):]
+			that.respHead = nil
+			if err := that.writeHead(head); err != nil {
+				return err
+			}
+			if that.headSent && that.respBody.done() {
+				that.reusable = that.reusable && len(b) == 0
+				that.finish()
+			}
+			continue
+		}
+		n, err := that.respBody.scan(b)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			that.write(append([]byte(nil), b[:n]...))
+		}
+		b = b[n:]
+		if that.respBody.done() {
+			// nothing may follow a response which has not been requested.
+			that.reusable = that.reusable && len(b) == 0
+			that.finish()
+		}
+	}
+	return nil
+}
+
+// writeHead forwards a response header without its hop-by-hop headers.
+func (that *session) writeHead(head []byte) error {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), &http.Request{Method: that.method})
+	if err != nil {
+		return errBadResponse
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return errBadResponse
+	}
+	if resp.StatusCode < 200 {
+		// interim responses(100 Continue, 103 Early Hints) are forwarded as they are.
+		that.write(append([]byte(nil), head...))
+		return nil
+	}
+	that.headSent = true
+	that.backend.succeed()
+
+	f, length := closeBody, int64(0)
+	switch {
+	case that.method == http.MethodHead || resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusNotModified:
+		f = noBody
+	case len(resp.TransferEncoding) > 0 && resp.TransferEncoding[0] == "chunked":
+		f = chunkedBody
+	case resp.ContentLength >= 0:
+		f, length = lengthBody, resp.ContentLength
+	}
+	that.reusable = !resp.Close && f != closeBody
+	if f == closeBody {
+		that.closeDown = true
+	}
+
+	h := resp.Header
+	removeHopHeaders(h)
+	switch f {
+	case chunkedBody:
+		h.Del("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+	case lengthBody:
+		h.Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	if that.closeDown {
+		h.Set("Connection", "close")
+	}
+	var buf bytes.Buffer
+	buf.WriteString("HTTP/1.1 " + resp.Status + "\r\n")
+	h.Write(&buf)
+	buf.WriteString("\r\n")
+	that.write(buf.Bytes())
+	that.respBody.reset(f, length)
+	return nil
+}
+
+// write sends data to the client in order, through the conn of the context so TLS works.
+// It runs in the mailbox of the client conn, the response comes on the upstream conn.
+func (that *session) write(data []byte) {
+	that.flowed(len(data))
+	err := that.downRef.Post(func() error {
+		_, err := that.ctx.Conn.Write(data)
+		that.flowed(-len(data))
+		return err
+	})
+	if err != nil {
+		that.flowed(-len(data))
+	}
+}
+
+// flowed counts the bytes posted to the client conn, they are taken back once written.
+func (that *session) flowed(n int) {
+	that.flow.Lock()
+	defer that.flow.Unlock()
+	that.posted += n
+	that.updateFlow()
+}
+
+// updateFlow pauses reading the upstream conn while the client conn is above its high water
+// mark, or the writes posted to it exceed the mark, until they fall to half of it. The flow
+// lock must be held.
+func (that *session) updateFlow() {
+	pause := that.downFull
+	if that.highWater > 0 {
+		limit := that.highWater
+		if that.upPaused {
+			limit /= 2
+		}
+		pause = pause || that.posted > limit
+	}
+	if pause != that.upPaused && that.upRef.Valid() {
+		that.upPaused = pause
+		pauseRead(that.upRef, pause)
+	}
+}
+
+// setUpstream sets the conn whose reading follows the client conn, the previous one is
+// resumed if it has been paused.
+func (that *session) setUpstream(ref conn.Ref) {
+	that.flow.Lock()
+	defer that.flow.Unlock()
+	if that.upPaused {
+		pauseRead(that.upRef, false)
+		that.upPaused = false
+	}
+	that.upRef = ref
+	that.updateFlow()
+}
+
+// pauseRead stops or resumes reading a conn in its mailbox.
+func pauseRead(ref conn.Ref, pause bool) {
+	ref.Post(func() error {
+		if pause {
+			return ref.Conn().PauseRead()
+		}
+		return ref.Conn().ResumeRead()
+	})
+}
+
+// finish ends the session after the response, the upstream conn goes back to its pool.
+// The lock must be held.
+func (that *session) finish() {
+	if that.finished {
+		return
+	}
+	that.finished = true
+	atomic.AddInt32(&that.backend.pending, -1)
+	u := that.up
+	u.attach(nil)
+	// resumed before another session can take the conn.
+	that.setUpstream(conn.Ref{})
+	if !that.reusable || !that.reqBody.done() || !u.pool.put(u, that.proxy.options.MaxIdlePerLoop) {
+		u.close()
+	}
+	that.release()
+}
+
+// release gives the client conn back to its previous handler, or closes it.
+func (that *session) release() {
+	down := that.down
+	if that.closeDown {
+		that.downRef.Post(func() error {
+			// the writes of the session may be queued by the async adapter of the conn.
+			return down.AsyncWrite(nil, func(_ net.Conn) error {
+				return down.Close()
+			})
+		})
+		return
+	}
+	// the response may end on the worker of the upstream conn.
+	that.downRef.Post(func() error {
+		down.Handler = that.handler
+		if that.ctx.Reader.Buffered() > 0 || !down.InBuffer.IsEmpty() {
+			// a pipelined request arrived during the session.
+			return down.Handler.OnTrack(that.ctx)
+		}
+		return nil
+	})
+}
+
+// abort ends a session which failed before the response was complete. status is written
+// to the client if the response has not started, the client conn is closed anyway.
+// The lock must be held.
+func (that *session) abort(status int) {
+	if that.finished {
+		return
+	}
+	that.finished = true
+	atomic.AddInt32(&that.backend.pending, -1)
+	if u := that.up; u != nil && u.attach(nil) {
+		u.close()
+	}
+	if !that.headSent {
+		text := http.StatusText(status)
+		that.write([]byte("HTTP/1.1 " + strconv.Itoa(status) + " " + text +
+			"\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: " +
+			strconv.Itoa(len(text)+1) + "\r\nConnection: close\r\n\r\n" + text + "\n"))
+	}
+	that.closeDown = true
+	that.release()
+}
+
+// upstreamClosed is called when the backend closes the conn during the session.
+func (that *session) upstreamClosed() {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	if that.finished {
+		return
+	}
+	if that.headSent && that.respBody.framing == closeBody {
+		that.finished = true
+		atomic.AddInt32(&that.backend.pending, -1)
+		that.release()
+		return
+	}
+	if !that.headSent && len(that.respHead) == 0 && that.retry {
+		// the backend may have closed the idle conn at its keep-alive timeout.
+		that.up = nil
+		if that.backend.dial(that.loop, &that.proxy.options, that.dialed) == nil {
+			return
+		}
+	} else if !that.headSent {
+		that.backend.fail(&that.proxy.options)
+	}
+	that.abort(http.StatusBadGateway)
+}
+
+/*
+methods for iface.IEventHandler of the client conn
+*/
+func (that *session) OnAccept(c iface.RawConn) error {
+	return nil
+}
+
+func (that *session) OnOpen(c *iface.Context) ([]byte, error) {
+	return nil, nil
+}
+
+func (that *session) OnTrack(c *iface.Context) error {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	if that.finished || that.up == nil {
+		// the body waits in the reader while the backend is dialed.
+		return nil
+	}
+	if err := that.forwardRequest(c.Reader); err != nil {
+		that.abort(http.StatusBadRequest)
+		return err
+	}
+	return nil
+}
+
+// OnWritable pauses the upstream conn while the client conn is above its high water mark.
+func (that *session) OnWritable(c *iface.Context, writable bool) {
+	that.flow.Lock()
+	defer that.flow.Unlock()
+	that.downFull = !writable
+	that.updateFlow()
+}
+
+func (that *session) OnClose(c *iface.Context) error {
+	that.lock.Lock()
+	if !that.finished {
+		that.finished = true
+		atomic.AddInt32(&that.backend.pending, -1)
+		if u := that.up; u != nil && u.attach(nil) {
+			u.close()
+		}
+	}
+	that.lock.Unlock()
+	return that.handler.OnClose(c)
+}
+
+// upstream is a conn to a backend, its events are passed to the session using it.
+type upstream struct {
+	backend *Backend
+	pool    *idlePool
+	raw     *conn.Conn
+	session *session
+	closed  bool
+	lock    sync.Mutex
+}
+
+// bind sets the session of the conn unless it has been closed, it reports which.
+func (that *upstream) bind(s *session) bool {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	if that.closed {
+		return false
+	}
+	that.session = s
+	return true
+}
+
+// attach sets the session of the conn, it reports whether there was one.
+func (that *upstream) attach(s *session) bool {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	had := that.session != nil
+	that.session = s
+	return had
+}
+
+// close closes the conn in its mailbox, the session may end on the client conn.
+func (that *upstream) close() {
+	that.raw.Ref().Close()
+}
+
+func (that *upstream) current() *session {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	return that.session
+}
+
+func (that *upstream) OnAccept(c iface.RawConn) error {
+	return nil
+}
+
+func (that *upstream) OnOpen(c *iface.Context) ([]byte, error) {
+	return nil, nil
+}
+
+func (that *upstream) OnTrack(c *iface.Context) error {
+	s := that.current()
+	if s == nil {
+		// an idle conn must not send anything.
+		io.Copy(io.Discard, c.Reader)
+		that.pool.remove(that)
+		return c.RawConn.Close()
+	}
+	s.lock.Lock()
+	err := readAll(c.Reader, s.forwardResponse, func() bool { return s.finished })
+	if err != nil {
+		s.abort(http.StatusBadGateway)
+	}
+	s.lock.Unlock()
+	return err
+}
+
+func (that *upstream) OnClose(c *iface.Context) error {
+	that.pool.remove(that)
+	that.lock.Lock()
+	s := that.session
+	that.session, that.closed = nil, true
+	that.lock.Unlock()
+	if s != nil {
+		s.upstreamClosed()
+	}
+	return nil
+}
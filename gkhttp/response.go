@@ -2,16 +2,20 @@ package gkhttp
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
+
+	"github.com/moqsien/gknet/conn"
 )
 
 const (
@@ -142,6 +146,8 @@ func freeHeader(h http.Header) {
 type Response struct {
 	req           *http.Request
 	conn          net.Conn
+	raw           *conn.Conn // the event-loop conn, detached on Hijack
+	compress      *CompressOptions
 	wroteHeader   bool
 	rw            *bufio.ReadWriter
 	buffer        []byte
@@ -264,7 +270,17 @@ func (w *Response) WriteHeader(code int) {
 // Hijack lets the caller take over the connection.
 // After a call to Hijack the HTTP server library
 // will not do anything else with the connection.
+// The conn is detached from its event loop, so blocking reads
+// and deadlines work on the returned net.Conn.
 func (w *Response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked.isSet() {
+		return nil, nil, http.ErrHijacked
+	}
+	if w.raw != nil {
+		if err := w.raw.Detach(); err != nil {
+			return nil, nil, err
+		}
+	}
 	if w.wroteHeader {
 		w.FinishRequest()
 	}
@@ -274,6 +290,116 @@ func (w *Response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return w.conn, w.rw, nil
 }
 
+// responseOf unwraps w to the *Response, or returns nil.
+func responseOf(w http.ResponseWriter) *Response {
+	for {
+		switch v := w.(type) {
+		case *Response:
+			return v
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+type writerOnly struct {
+	io.Writer
+}
+
+// ReadFrom implements the io.ReaderFrom interface.
+//
+// Regions of an *os.File(as passed by http.ServeContent) are sent
+// with sendfile on plaintext conns, other readers are copied.
+func (w *Response) ReadFrom(src io.Reader) (n int64, err error) {
+	if w.hijacked.isSet() {
+		return 0, http.ErrHijacked
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	f, off, size, ok := w.sendfileSource(src)
+	if !ok {
+		return io.Copy(writerOnly{w}, src)
+	}
+	if size == 0 {
+		return 0, nil
+	}
+
+	// the header and buffered body go to the socket right away, so they are sent before the file.
+	rw := w.rw
+	bw := NewBufioWriter(w.raw)
+	w.rw = bufio.NewReadWriter(rw.Reader, bw)
+	if !w.noCache {
+		w.noCache = true
+		if w.written > 0 {
+			w.cw.Write(w.buffer[:w.written])
+		}
+	}
+	w.cw.flush()
+	w.rw = rw
+	FreeBufioWriter(bw)
+
+	if err = w.raw.SendFile(f, off, size); err != nil {
+		return 0, err
+	}
+	f.Seek(size, io.SeekCurrent)
+	w.written += size
+	return size, nil
+}
+
+// sendfileSource returns the file region of src if it can be sent with sendfile.
+func (w *Response) sendfileSource(src io.Reader) (f *os.File, off, size int64, ok bool) {
+	if w.raw == nil || w.raw.Detached() || w.req.Method == head || !w.bodyAllowed() {
+		return
+	}
+	if _, isTLS := w.conn.(*tls.Conn); isTLS {
+		return
+	}
+	// the length must be declared, and nothing may wait in the bufio.Writer of the conn.
+	if len(w.setHeader.contentLength) == 0 || w.cw.chunking || w.rw.Writer.Buffered() > 0 {
+		return
+	}
+	// bodies the encoder takes can not be sent as is, the header is written with the buffered
+	// body below, so it is not the whole body.
+	if w.compress != nil && !w.cw.wroteHeader {
+		var p []byte
+		if !w.noCache {
+			p = w.buffer[:w.written]
+		}
+		if name, _, _ := w.cw.encoding(p, false, false); name != emptyString {
+			return
+		}
+	}
+	size = -1
+	switch v := src.(type) {
+	case *os.File:
+		f = v
+	case *io.LimitedReader:
+		if f, ok = v.R.(*os.File); !ok {
+			return
+		}
+		size = v.N
+	default:
+		return
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil, 0, 0, false
+	}
+	if off, err = f.Seek(0, io.SeekCurrent); err != nil {
+		return nil, 0, 0, false
+	}
+	if remain := fi.Size() - off; size < 0 || size > remain {
+		size = remain
+	}
+	if size < 0 || w.written+size > w.contentLength {
+		return nil, 0, 0, false
+	}
+	return f, off, size, true
+}
+
 // Flush implements the http.Flusher interface.
 //
 // Flush writes any buffered data to the underlying connection.
@@ -298,12 +424,18 @@ func (w *Response) FinishRequest() {
 	if !w.handlerDone.setTrue() {
 		return
 	}
-	w.Flush()
-	w.cw.close()
-	w.rw.Flush()
+	if !w.hijacked.isSet() {
+		w.Flush()
+		w.cw.close()
+		w.rw.Flush()
+	}
+	w.cw.closeEncoder(false)
 	// Close the body (regardless of w.closeAfterReply) so we can
-	// re-use its bufio.Reader later safely.
-	w.req.Body.Close()
+	// re-use its bufio.Reader later safely. The reader of a hijacked
+	// conn belongs to the new owner.
+	if !w.hijacked.isSet() {
+		w.req.Body.Close()
+	}
 
 	if w.req.MultipartForm != nil {
 		w.req.MultipartForm.RemoveAll()
@@ -381,6 +513,11 @@ type chunkWriter struct {
 
 	// set by the writeHeader method:
 	chunking bool // using chunked transfer encoding for reply body
+
+	// set when the body is compressed, see negotiateEncoding.
+	enc     Encoder
+	encPool *sync.Pool
+	encoded []byte // the whole compressed body
 }
 
 func (cw *chunkWriter) Write(p []byte) (n int, err error) {
@@ -391,6 +528,19 @@ func (cw *chunkWriter) Write(p []byte) (n int, err error) {
 		// Eat writes.
 		return len(p), nil
 	}
+	if cw.encoded != nil {
+		_, err = cw.writeFrame(cw.encoded)
+		cw.encoded = nil
+		return len(p), err
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.writeFrame(p)
+}
+
+// writeFrame writes p to the conn, as a chunk when chunking.
+func (cw *chunkWriter) writeFrame(p []byte) (n int, err error) {
 	if cw.chunking {
 		_, err = fmt.Fprintf(cw.res.rw, chunk, len(p))
 		if err != nil {
@@ -412,6 +562,9 @@ func (cw *chunkWriter) flush() {
 	if !cw.wroteHeader {
 		cw.writeHeader(nil)
 	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
 	cw.res.rw.Flush()
 }
 
@@ -419,6 +572,7 @@ func (cw *chunkWriter) close() {
 	if !cw.wroteHeader {
 		cw.writeHeader(nil)
 	}
+	cw.closeEncoder(true)
 	if cw.chunking {
 		bw := cw.res.rw // conn's bufio writer
 		// zero chunk to mark EOF
@@ -468,6 +622,9 @@ func (cw *chunkWriter) writeHeader(p []byte) {
 	if co := w.handlerHeader.Get(connection); co != emptyString {
 		w.setHeader.connection = co
 	}
+	if w.compress != nil {
+		cw.negotiateEncoding(p, isHEAD)
+	}
 	w.rw.WriteString(httpVersion)
 	if text := http.StatusText(w.status); len(text) > 0 {
 		w.rw.Write(strconv.AppendInt(w.statusBuf[:0], int64(w.status), 10))
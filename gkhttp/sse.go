@@ -0,0 +1,282 @@
+package gkhttp
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/moqsien/gknet/conn"
+	"github.com/moqsien/gknet/iface"
+)
+
+const eventStream = "text/event-stream"
+
+var (
+	ErrSSEClosed      = errors.New("[SSE] stream closed")
+	ErrSSEUnsupported = errors.New("[SSE] streaming is not supported on this conn")
+)
+
+// Event is a server-sent event. Empty fields are omitted.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+type SSEOptions struct {
+	Heartbeat time.Duration // interval of heartbeat comments, 0 disables heartbeats
+	Retry     time.Duration // reconnection time sent to the client when the stream opens
+	OnClose   func(s *SSEStream)
+}
+
+// SSEStream is an open text/event-stream response. It replaces the handler of the conn,
+// so it stays open after ServeHTTP returns, and events are written on the event loop.
+type SSEStream struct {
+	out         net.Conn // Ctx.Conn of the stream, a tls.Conn under TLS
+	raw         *conn.Conn
+	ref         conn.Ref // writes from other goroutines must not reach a reused conn
+	request     *http.Request
+	lastEventID string
+	chunked     bool
+	options     SSEOptions
+	pending     []byte
+	writing     bool
+	ended       bool  // set by Close
+	closed      int32 // set when the conn is closed
+	done        chan struct{}
+	lock        sync.Mutex
+}
+
+// ContextOf returns the *iface.Context of the conn serving an HTTP/1.x request, or nil
+// if w is not a gkhttp response(HTTP/2, or wrapped without an Unwrap method).
+func ContextOf(w http.ResponseWriter, r *http.Request) *iface.Context {
+	if c := ConnContext(r); c != nil {
+		return c
+	}
+	if res := responseOf(w); res != nil && res.raw != nil {
+		return res.raw.Ctx
+	}
+	return nil
+}
+
+// NewSSE starts an event stream for the request. Headers already set on w are sent with
+// the response, and w must not be used afterwards. The handler may return at once, the
+// stream is closed by Close or when the client goes away.
+// If w is wrapped without an Unwrap method(gin, etc.), the request must accept
+// text/event-stream, as EventSource clients do.
+func NewSSE(w http.ResponseWriter, r *http.Request, opts ...*SSEOptions) (*SSEStream, error) {
+	c := ContextOf(w, r)
+	if c == nil {
+		return nil, ErrSSEUnsupported
+	}
+	raw, ok := c.RawConn.(*conn.Conn)
+	if !ok || raw.Detached() {
+		return nil, ErrSSEUnsupported
+	}
+	s := &SSEStream{
+		out:         c.Conn,
+		raw:         raw,
+		ref:         raw.Ref(),
+		request:     r,
+		lastEventID: r.Header.Get("Last-Event-ID"),
+		chunked:     r.ProtoAtLeast(1, 1),
+		done:        make(chan struct{}),
+	}
+	if len(opts) > 0 && opts[0] != nil {
+		s.options = *opts[0]
+	}
+
+	h := w.Header()
+	h.Set(contentType, eventStream)
+	h.Set("Cache-Control", "no-cache")
+	h.Del(contentLength)
+	if s.chunked {
+		h.Set(transferEncoding, chunked)
+	} else {
+		h.Del(transferEncoding)
+		h.Set(connection, "close")
+	}
+	var b strings.Builder
+	b.WriteString(httpVersion)
+	b.WriteString("200 OK\r\n")
+	h.Write(&b)
+	b.WriteString("\r\n")
+
+	raw.Handler = s
+	s.lock.Lock()
+	s.pending, s.writing = []byte(b.String()), true
+	err := s.flush()
+	s.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if s.options.Retry > 0 {
+		s.Send(&Event{Retry: s.options.Retry})
+	}
+	if s.options.Heartbeat > 0 {
+		go s.heartbeat()
+	}
+	return s, nil
+}
+
+// Request returns the request which opened the stream.
+func (that *SSEStream) Request() *http.Request {
+	return that.request
+}
+
+// LastEventID returns the Last-Event-ID header sent by a reconnecting client.
+func (that *SSEStream) LastEventID() string {
+	return that.lastEventID
+}
+
+// Done is closed when the stream is closed.
+func (that *SSEStream) Done() <-chan struct{} {
+	return that.done
+}
+
+func (that *SSEStream) heartbeat() {
+	ticker := time.NewTicker(that.options.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-that.done:
+			return
+		case <-ticker.C:
+			if that.Comment("") != nil {
+				return
+			}
+		}
+	}
+}
+
+// write queues data as one chunk. Only one AsyncWrite of the stream is in flight at a
+// time, so events keep their order. The conn is closed after the last chunk is sent.
+func (that *SSEStream) write(data []byte, last bool) error {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	if that.ended || atomic.LoadInt32(&that.closed) == 1 {
+		return ErrSSEClosed
+	}
+	if that.chunked {
+		that.pending = strconv.AppendInt(that.pending, int64(len(data)), 16)
+		that.pending = append(that.pending, "\r\n"...)
+		that.pending = append(that.pending, data...)
+		that.pending = append(that.pending, "\r\n"...)
+	} else {
+		that.pending = append(that.pending, data...)
+	}
+	that.ended = last
+	if that.writing {
+		return nil
+	}
+	that.writing = true
+	return that.flush()
+}
+
+// flush must be called with the lock held.
+func (that *SSEStream) flush() error {
+	data := that.pending
+	that.pending = nil
+	if _, ok := that.out.(*tls.Conn); ok {
+		_, err := that.out.Write(data)
+		that.writing = false
+		if that.ended {
+			return that.ref.Close()
+		}
+		return err
+	}
+	return that.ref.AsyncWrite(data, that.onWritten)
+}
+
+func (that *SSEStream) onWritten(_ net.Conn) error {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	if len(that.pending) > 0 {
+		return that.flush()
+	}
+	that.writing = false
+	if that.ended {
+		return that.raw.Close()
+	}
+	return nil
+}
+
+// single line values must not break the event format.
+func sseValue(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// Send writes an event, multi-line Data is sent as several data lines.
+func (that *SSEStream) Send(e *Event) error {
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: ")
+		b.WriteString(sseValue(e.ID))
+		b.WriteByte('\n')
+	}
+	if e.Event != "" {
+		b.WriteString("event: ")
+		b.WriteString(sseValue(e.Event))
+		b.WriteByte('\n')
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: ")
+		b.WriteString(strconv.FormatInt(e.Retry.Milliseconds(), 10))
+		b.WriteByte('\n')
+	}
+	if e.Data != "" || (e.ID == "" && e.Event == "" && e.Retry <= 0) {
+		for _, line := range strings.Split(strings.ReplaceAll(e.Data, "\r\n", "\n"), "\n") {
+			b.WriteString("data: ")
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	b.WriteByte('\n')
+	return that.write([]byte(b.String()), false)
+}
+
+// Comment writes a comment line, which clients ignore. It keeps proxies from closing idle streams.
+func (that *SSEStream) Comment(text string) error {
+	return that.write([]byte(":"+sseValue(text)+"\n\n"), false)
+}
+
+// Close ends the stream and closes the conn once the queued events are sent.
+func (that *SSEStream) Close() error {
+	return that.write(nil, true)
+}
+
+/*
+methods for iface.IEventHandler
+*/
+func (that *SSEStream) OnAccept(c iface.RawConn) error {
+	return nil
+}
+
+func (that *SSEStream) OnOpen(c *iface.Context) ([]byte, error) {
+	return nil, nil
+}
+
+// OnTrack discards anything the client sends on the stream.
+func (that *SSEStream) OnTrack(c *iface.Context) error {
+	io.Copy(io.Discard, c.Reader)
+	return nil
+}
+
+func (that *SSEStream) OnClose(c *iface.Context) error {
+	if !atomic.CompareAndSwapInt32(&that.closed, 0, 1) {
+		return nil
+	}
+	close(that.done)
+	if that.options.OnClose != nil {
+		that.options.OnClose(that)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package gkhttp
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const traceParentHeader = "Traceparent"
+
+var traceContext propagation.TraceContext
+
+// withTraceParent puts the W3C trace context sent by the client into the context of the
+// request, the spans started by the handler then join the trace of the client.
+func withTraceParent(req *http.Request) *http.Request {
+	if req.Header.Get(traceParentHeader) == emptyString {
+		return req
+	}
+	ctx := traceContext.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	if !trace.SpanContextFromContext(ctx).IsRemote() {
+		return req
+	}
+	return req.WithContext(ctx)
+}
@@ -0,0 +1,74 @@
+package ws
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	extensionName = "permessage-deflate"
+	// the response always disables context takeover, so every message is compressed on its own.
+	extensionResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+	// messages shorter than this are sent uncompressed.
+	compressThreshold = 128
+)
+
+// deflate tail stripped by the sender, plus an empty final block so the reader sees io.EOF.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+var (
+	flateWriterPool = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	}}
+	flateReaderPool = sync.Pool{New: func() interface{} {
+		return flate.NewReader(nil)
+	}}
+)
+
+// offersCompression reports whether the client offered permessage-deflate.
+func offersCompression(extensions []string) bool {
+	for _, v := range extensions {
+		for _, ext := range strings.Split(v, ",") {
+			params := strings.Split(ext, ";")
+			if strings.TrimSpace(params[0]) == extensionName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func compressMessage(data []byte) []byte {
+	var buf bytes.Buffer
+	w := flateWriterPool.Get().(*flate.Writer)
+	w.Reset(&buf)
+	w.Write(data)
+	w.Flush()
+	flateWriterPool.Put(w)
+	b := buf.Bytes()
+	// strip 0x00 0x00 0xff 0xff, see RFC 7692 section 7.2.1.
+	return b[:len(b)-4]
+}
+
+// decompressMessage inflates data, failing if the result exceeds limit(limit <= 0 means no limit).
+func decompressMessage(data []byte, limit int64) ([]byte, error) {
+	r := flateReaderPool.Get().(io.ReadCloser)
+	defer flateReaderPool.Put(r)
+	r.(flate.Resetter).Reset(io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail)), nil)
+	var src io.Reader = r
+	if limit > 0 {
+		src = io.LimitReader(r, limit+1)
+	}
+	out, err := io.ReadAll(src)
+	if err != nil {
+		return nil, &CloseError{CloseInvalidFramePayloadData, "invalid compressed data"}
+	}
+	if limit > 0 && int64(len(out)) > limit {
+		return nil, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+	return out, nil
+}
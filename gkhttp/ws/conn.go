@@ -0,0 +1,356 @@
+package ws
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/moqsien/gknet/conn"
+	"github.com/moqsien/gknet/iface"
+)
+
+// Handler receives the events of websocket conns.
+type Handler interface {
+	OnOpen(c *Conn)
+	// data is only valid until OnMessage returns.
+	OnMessage(c *Conn, typ MessageType, data []byte)
+	OnClose(c *Conn, code int, reason string)
+}
+
+// Conn is a websocket connection. It implements iface.IEventHandler and replaces the
+// handler of the underlying conn.Conn, so frames are decoded on the event loop.
+type Conn struct {
+	upgrader      *Upgrader
+	ctx           *iface.Context
+	raw           *conn.Conn
+	ref           conn.Ref // frames queued from other goroutines must not reach a reused conn
+	writer        io.Writer
+	request       *http.Request
+	subprotocol   string
+	compress      bool
+	inBuf         []byte
+	readBuf       []byte
+	msgType       MessageType
+	msgBuf        []byte
+	msgCompressed bool
+	fragmented    bool
+	closeSent     int32
+	closed        int32
+	closeCode     int
+	closeReason   string
+	lock          sync.Mutex
+}
+
+func newConn(up *Upgrader, c *iface.Context, raw *conn.Conn, req *http.Request) *Conn {
+	wc := &Conn{
+		upgrader:  up,
+		ctx:       c,
+		raw:       raw,
+		ref:       raw.Ref(),
+		request:   req,
+		closeCode: CloseAbnormalClosure,
+	}
+	// plaintext frames go to the raw conn directly, so they are ordered with its OutBuffer.
+	if _, ok := c.Conn.(*tls.Conn); ok {
+		wc.writer = c.Conn
+	} else {
+		wc.writer = raw
+	}
+	return wc
+}
+
+// Request returns the handshake request.
+func (that *Conn) Request() *http.Request {
+	return that.request
+}
+
+// Subprotocol returns the negotiated subprotocol.
+func (that *Conn) Subprotocol() string {
+	return that.subprotocol
+}
+
+// Compressed reports whether permessage-deflate was negotiated.
+func (that *Conn) Compressed() bool {
+	return that.compress
+}
+
+func (that *Conn) LocalAddr() net.Addr {
+	return that.raw.LocalAddr()
+}
+
+func (that *Conn) RemoteAddr() net.Addr {
+	return that.raw.RemoteAddr()
+}
+
+// RawConn returns the underlying conn of the event loop.
+func (that *Conn) RawConn() *conn.Conn {
+	return that.raw
+}
+
+/*
+methods for iface.IEventHandler
+*/
+func (that *Conn) OnAccept(c iface.RawConn) error {
+	return nil
+}
+
+func (that *Conn) OnOpen(c *iface.Context) ([]byte, error) {
+	return nil, nil
+}
+
+func (that *Conn) OnTrack(c *iface.Context) error {
+	if that.readBuf == nil {
+		that.readBuf = make([]byte, 4096)
+	}
+	for {
+		n, err := c.Reader.Read(that.readBuf)
+		if n > 0 {
+			that.inBuf = append(that.inBuf, that.readBuf[:n]...)
+		}
+		if err != nil || n == 0 {
+			break
+		}
+	}
+	err := that.readFrames()
+	if ce, ok := err.(*CloseError); ok {
+		that.fail(ce.Code, ce.Reason)
+	}
+	return err
+}
+
+func (that *Conn) OnClose(c *iface.Context) error {
+	if !atomic.CompareAndSwapInt32(&that.closed, 0, 1) {
+		return nil
+	}
+	that.inBuf, that.msgBuf = nil, nil
+	if that.upgrader.Hub != nil {
+		that.upgrader.Hub.remove(that)
+	}
+	if that.upgrader.Handler != nil {
+		that.upgrader.Handler.OnClose(that, that.closeCode, that.closeReason)
+	}
+	return nil
+}
+
+// readFrames decodes all complete frames in inBuf.
+func (that *Conn) readFrames() error {
+	buf := that.inBuf
+	defer func() {
+		that.inBuf = append(that.inBuf[:0], buf...)
+	}()
+	for atomic.LoadInt32(&that.closed) == 0 {
+		h, ok, err := parseFrameHeader(buf)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if h.length > that.upgrader.maxMessageSize()-int64(len(that.msgBuf)) {
+			return &CloseError{CloseMessageTooBig, "message too big"}
+		}
+		if h.length > int64(len(buf)-h.headLen) {
+			return nil
+		}
+		end := h.headLen + int(h.length)
+		payload := buf[h.headLen:end]
+		maskBytes(h.mask, payload)
+		buf = buf[end:]
+		if err = that.handleFrame(h, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (that *Conn) handleFrame(h frameHeader, payload []byte) error {
+	if isControl(h.opcode) {
+		if !h.fin || len(payload) > maxControlPayload || h.rsv1 {
+			return &CloseError{CloseProtocolError, "invalid control frame"}
+		}
+		return that.handleControl(MessageType(h.opcode), payload)
+	}
+	switch {
+	case isData(h.opcode):
+		if that.fragmented {
+			return &CloseError{CloseProtocolError, "expected continuation frame"}
+		}
+		if h.rsv1 && !that.compress {
+			return &CloseError{CloseProtocolError, "unexpected RSV1 bit"}
+		}
+		that.msgType = MessageType(h.opcode)
+		that.msgCompressed = h.rsv1
+	case h.opcode == continuationFrame:
+		if !that.fragmented {
+			return &CloseError{CloseProtocolError, "unexpected continuation frame"}
+		}
+		if h.rsv1 {
+			return &CloseError{CloseProtocolError, "unexpected RSV1 bit"}
+		}
+	default:
+		return &CloseError{CloseProtocolError, "unknown opcode"}
+	}
+
+	if !h.fin {
+		that.fragmented = true
+		that.msgBuf = append(that.msgBuf, payload...)
+		return nil
+	}
+	data := payload
+	if that.fragmented {
+		data = append(that.msgBuf, payload...)
+		that.fragmented = false
+	}
+	err := that.deliver(data)
+	that.msgBuf = that.msgBuf[:0]
+	return err
+}
+
+func (that *Conn) deliver(data []byte) (err error) {
+	if that.msgCompressed {
+		if data, err = decompressMessage(data, that.upgrader.maxMessageSize()); err != nil {
+			return err
+		}
+	}
+	if that.msgType == TextMessage && !utf8.Valid(data) {
+		return &CloseError{CloseInvalidFramePayloadData, "invalid utf-8 text"}
+	}
+	if that.upgrader.Handler != nil {
+		that.upgrader.Handler.OnMessage(that, that.msgType, data)
+	}
+	return nil
+}
+
+func (that *Conn) handleControl(typ MessageType, payload []byte) error {
+	switch typ {
+	case PingMessage:
+		return that.writeNow(appendFrame(nil, true, false, int(PongMessage), payload), false)
+	case PongMessage:
+		return nil
+	}
+	// close frame.
+	code, reason := CloseNoStatusReceived, ""
+	if len(payload) == 1 {
+		return &CloseError{CloseProtocolError, "invalid close payload"}
+	}
+	if len(payload) >= 2 {
+		code = int(binary.BigEndian.Uint16(payload))
+		if !validReceivedCloseCode(code) {
+			return &CloseError{CloseProtocolError, "invalid close code"}
+		}
+		if !utf8.Valid(payload[2:]) {
+			return &CloseError{CloseInvalidFramePayloadData, "invalid utf-8 close reason"}
+		}
+		reason = string(payload[2:])
+	}
+	that.closeCode, that.closeReason = code, reason
+	if atomic.CompareAndSwapInt32(&that.closeSent, 0, 1) {
+		// echo the close frame, then close the TCP conn.
+		echo := code
+		if echo == CloseNoStatusReceived {
+			echo = CloseNormalClosure
+		}
+		return that.writeNow(appendFrame(nil, true, false, int(CloseMessage), FormatCloseMessage(echo, "")), true)
+	}
+	// the closing handshake was started by us.
+	return that.closeRaw()
+}
+
+// fail sends a close frame with code and closes the conn.
+func (that *Conn) fail(code int, reason string) {
+	that.closeCode, that.closeReason = code, reason
+	if atomic.CompareAndSwapInt32(&that.closeSent, 0, 1) {
+		that.writeNow(appendFrame(nil, true, false, int(CloseMessage), FormatCloseMessage(code, reason)), true)
+		return
+	}
+	that.closeRaw()
+}
+
+// closeRaw closes the conn unless it has been closed already.
+func (that *Conn) closeRaw() error {
+	if c := that.ref.Conn(); c != nil {
+		return c.Close()
+	}
+	return nil
+}
+
+// writeNow writes a frame on the current goroutine.
+func (that *Conn) writeNow(frame []byte, closeAfter bool) (err error) {
+	that.lock.Lock()
+	if atomic.LoadInt32(&that.closed) == 1 || !that.ref.Valid() {
+		that.lock.Unlock()
+		return ErrClosed
+	}
+	_, err = that.writer.Write(frame)
+	that.lock.Unlock()
+	if closeAfter || err != nil {
+		that.closeRaw()
+	}
+	return
+}
+
+// enqueue writes a frame in the mailbox of the conn.
+func (that *Conn) enqueue(frame []byte, closeAfter bool) error {
+	c := that.ref.Conn()
+	if c == nil || atomic.LoadInt32(&that.closed) == 1 {
+		return ErrClosed
+	}
+	return c.Post(func() error {
+		if err := that.writeNow(frame, closeAfter); err != nil && err != ErrClosed {
+			return err
+		}
+		return nil
+	})
+}
+
+// encode builds the frame of a data message, compressing it if negotiated.
+func (that *Conn) encode(typ MessageType, data []byte) []byte {
+	if that.compress && len(data) >= compressThreshold {
+		return appendFrame(nil, true, true, int(typ), compressMessage(data))
+	}
+	return appendFrame(nil, true, false, int(typ), data)
+}
+
+// WriteMessage sends a message, the write runs on the event loop of the conn.
+// Control messages(ping, pong, close) must not be longer than 125 bytes.
+func (that *Conn) WriteMessage(typ MessageType, data []byte) error {
+	switch typ {
+	case TextMessage, BinaryMessage:
+		return that.enqueue(that.encode(typ, data), false)
+	case PingMessage, PongMessage:
+		if len(data) > maxControlPayload {
+			return &CloseError{CloseProtocolError, "control frame too long"}
+		}
+		return that.enqueue(appendFrame(nil, true, false, int(typ), data), false)
+	case CloseMessage:
+		code, reason := CloseNormalClosure, ""
+		if len(data) >= 2 {
+			code, reason = int(binary.BigEndian.Uint16(data)), string(data[2:])
+		}
+		return that.Close(code, reason)
+	}
+	return &CloseError{CloseProtocolError, "unknown message type"}
+}
+
+// Ping sends a ping message.
+func (that *Conn) Ping(data []byte) error {
+	return that.WriteMessage(PingMessage, data)
+}
+
+// Close starts the closing handshake, the TCP conn is closed when the peer replies.
+func (that *Conn) Close(code int, reason string) error {
+	if !atomic.CompareAndSwapInt32(&that.closeSent, 0, 1) {
+		return nil
+	}
+	payload := FormatCloseMessage(code, reason)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	that.closeCode, that.closeReason = code, reason
+	return that.enqueue(appendFrame(nil, true, false, int(CloseMessage), payload), false)
+}
@@ -0,0 +1,175 @@
+/*
+ws implements the WebSocket protocol(RFC 6455) with permessage-deflate(RFC 7692) on the event loop.
+Once the handshake completes, the conn.Conn's handler is switched to a ws.Conn which decodes frames.
+*/
+package ws
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+	CloseMessage  MessageType = 8
+	PingMessage   MessageType = 9
+	PongMessage   MessageType = 10
+)
+
+const continuationFrame = 0
+
+// close codes, see RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+	CloseServiceRestart          = 1012
+	CloseTryAgainLater           = 1013
+	CloseTLSHandshake            = 1015
+)
+
+const (
+	finalBit          = 1 << 7
+	rsv1Bit           = 1 << 6
+	rsv2Bit           = 1 << 5
+	rsv3Bit           = 1 << 4
+	maskBit           = 1 << 7
+	maxControlPayload = 125
+)
+
+var (
+	ErrClosed       = errors.New("[ws] connection closed")
+	ErrBadHandshake = errors.New("[ws] bad handshake")
+)
+
+// CloseError is the close frame received from, or sent to the peer.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("[ws] close %d %s", e.Code, e.Reason)
+}
+
+func isControl(op int) bool {
+	return op == int(CloseMessage) || op == int(PingMessage) || op == int(PongMessage)
+}
+
+func isData(op int) bool {
+	return op == int(TextMessage) || op == int(BinaryMessage)
+}
+
+// validReceivedCloseCode reports whether code may appear in a close frame on the wire.
+func validReceivedCloseCode(code int) bool {
+	switch code {
+	case CloseNormalClosure, CloseGoingAway, CloseProtocolError, CloseUnsupportedData,
+		CloseInvalidFramePayloadData, ClosePolicyViolation, CloseMessageTooBig,
+		CloseMandatoryExtension, CloseInternalServerErr, CloseServiceRestart, CloseTryAgainLater:
+		return true
+	}
+	return code >= 3000 && code <= 4999
+}
+
+// frameHeader is a parsed frame header of a client frame.
+type frameHeader struct {
+	fin     bool
+	rsv1    bool
+	opcode  int
+	length  int64
+	mask    [4]byte
+	headLen int
+}
+
+// parseFrameHeader returns ok=false if b does not contain the whole header yet.
+func parseFrameHeader(b []byte) (h frameHeader, ok bool, err error) {
+	if len(b) < 2 {
+		return
+	}
+	if b[0]&(rsv2Bit|rsv3Bit) != 0 {
+		return h, false, &CloseError{CloseProtocolError, "unexpected reserved bits"}
+	}
+	h.fin = b[0]&finalBit != 0
+	h.rsv1 = b[0]&rsv1Bit != 0
+	h.opcode = int(b[0] & 0xf)
+	if b[1]&maskBit == 0 {
+		return h, false, &CloseError{CloseProtocolError, "client frame is not masked"}
+	}
+	h.length = int64(b[1] & 0x7f)
+	h.headLen = 2
+	switch h.length {
+	case 126:
+		if len(b) < 4 {
+			return
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[2:4]))
+		h.headLen = 4
+	case 127:
+		if len(b) < 10 {
+			return
+		}
+		v := binary.BigEndian.Uint64(b[2:10])
+		if v>>63 != 0 {
+			return h, false, &CloseError{CloseProtocolError, "invalid payload length"}
+		}
+		h.length = int64(v)
+		h.headLen = 10
+	}
+	if len(b) < h.headLen+4 {
+		return
+	}
+	copy(h.mask[:], b[h.headLen:h.headLen+4])
+	h.headLen += 4
+	return h, true, nil
+}
+
+// maskBytes xors b with the masking key in place.
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+// appendFrame appends an unmasked server frame to dst.
+func appendFrame(dst []byte, fin, rsv1 bool, opcode int, payload []byte) []byte {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= finalBit
+	}
+	if rsv1 {
+		b0 |= rsv1Bit
+	}
+	length := len(payload)
+	switch {
+	case length <= 125:
+		dst = append(dst, b0, byte(length))
+	case length <= 0xffff:
+		dst = append(dst, b0, 126, byte(length>>8), byte(length))
+	default:
+		dst = append(dst, b0, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(dst[len(dst)-8:], uint64(length))
+	}
+	return append(dst, payload...)
+}
+
+// FormatCloseMessage formats code and reason as the payload of a close frame.
+func FormatCloseMessage(code int, reason string) []byte {
+	if code == CloseNoStatusReceived {
+		return nil
+	}
+	b := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(b, uint16(code))
+	return append(b, reason...)
+}
@@ -0,0 +1,153 @@
+package ws
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var testMask = [4]byte{0x12, 0x34, 0x56, 0x78}
+
+// clientFrame returns a masked client frame.
+func clientFrame(fin, rsv1 bool, opcode int, payload []byte) []byte {
+	f := appendFrame(nil, fin, rsv1, opcode, payload)
+	b := append(f[:len(f)-len(payload):len(f)-len(payload)], testMask[:]...)
+	b[1] |= maskBit
+	p := append([]byte(nil), payload...)
+	maskBytes(testMask, p)
+	return append(b, p...)
+}
+
+func closeCode(err error) int {
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		return ce.Code
+	}
+	return 0
+}
+
+func TestParseFrameHeader(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      []byte
+		ok      bool
+		code    int
+		length  int64
+		headLen int
+	}{
+		{"empty", nil, false, 0, 0, 0},
+		{"one byte", []byte{0x81}, false, 0, 0, 0},
+		{"short", clientFrame(true, false, 1, []byte("hi")), true, 0, 2, 6},
+		{"no mask key yet", []byte{0x81, 0x82, 0x12}, false, 0, 0, 0},
+		{"16 bit", clientFrame(true, false, 2, make([]byte, 300)), true, 0, 300, 8},
+		{"16 bit truncated", []byte{0x82, 0xfe, 0x01}, false, 0, 0, 0},
+		{"64 bit", clientFrame(true, false, 2, make([]byte, 70000)), true, 0, 70000, 14},
+		{"64 bit truncated", []byte{0x82, 0xff, 0, 0, 0, 0}, false, 0, 0, 0},
+		{"64 bit max", []byte{0x82, 0xff, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4}, true, 0, 1<<63 - 1, 14},
+		{"64 bit high bit", []byte{0x82, 0xff, 0x80, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4}, false, CloseProtocolError, 0, 0},
+		{"unmasked", []byte{0x81, 0x02, 'h', 'i'}, false, CloseProtocolError, 0, 0},
+		{"rsv2", []byte{0xa1, 0x80, 1, 2, 3, 4}, false, CloseProtocolError, 0, 0},
+		{"rsv3", []byte{0x91, 0x80, 1, 2, 3, 4}, false, CloseProtocolError, 0, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h, ok, err := parseFrameHeader(c.in)
+			if code := closeCode(err); code != c.code {
+				t.Fatalf("close code %d, want %d(err %v)", code, c.code, err)
+			}
+			if ok != c.ok {
+				t.Fatalf("ok %v, want %v", ok, c.ok)
+			}
+			if ok && (h.length != c.length || h.headLen != c.headLen) {
+				t.Fatalf("got length %d headLen %d", h.length, h.headLen)
+			}
+		})
+	}
+}
+
+type recordHandler struct {
+	messages [][]byte
+}
+
+func (that *recordHandler) OnOpen(c *Conn) {}
+
+func (that *recordHandler) OnMessage(c *Conn, typ MessageType, data []byte) {
+	that.messages = append(that.messages, append([]byte(nil), data...))
+}
+
+func (that *recordHandler) OnClose(c *Conn, code int, reason string) {}
+
+func TestReadFrames(t *testing.T) {
+	join := func(frames ...[]byte) []byte { return bytes.Join(frames, nil) }
+	big := bytes.Repeat([]byte("a"), 2000)
+	cases := []struct {
+		name     string
+		max      int64
+		compress bool
+		in       []byte
+		code     int
+		messages []string
+		rest     int
+	}{
+		{"single", 0, false, clientFrame(true, false, 1, []byte("hello")), 0, []string{"hello"}, 0},
+		{"fragmented", 0, false, join(
+			clientFrame(false, false, 1, []byte("hel")),
+			clientFrame(true, false, continuationFrame, []byte("lo")),
+		), 0, []string{"hello"}, 0},
+		{"partial payload", 0, false, clientFrame(true, false, 1, []byte("hello"))[:8], 0, nil, 8},
+		{"huge length", 0, false, []byte{0x82, 0xff, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4}, CloseMessageTooBig, nil, 14},
+		{"over max", 4, false, clientFrame(true, false, 1, []byte("hello")), CloseMessageTooBig, nil, 11},
+		{"fragments over max", 4, false, join(
+			clientFrame(false, false, 1, []byte("hel")),
+			clientFrame(true, false, continuationFrame, []byte("lo")),
+		), CloseMessageTooBig, nil, 8},
+		{"unexpected continuation", 0, false, clientFrame(true, false, continuationFrame, []byte("x")), CloseProtocolError, nil, 0},
+		{"rsv1 without compression", 0, false, clientFrame(true, true, 1, []byte("x")), CloseProtocolError, nil, 0},
+		{"invalid utf-8", 0, false, clientFrame(true, false, 1, []byte{0xff}), CloseInvalidFramePayloadData, nil, 0},
+		{"deflate", 0, true, clientFrame(true, true, 2, compressMessage(big)), 0, []string{string(big)}, 0},
+		{"deflate over max", 1000, true, clientFrame(true, true, 2, compressMessage(big)), CloseMessageTooBig, nil, 0},
+		{"deflate garbage", 0, true, clientFrame(true, true, 2, []byte{0xff, 0xff, 0xff}), CloseInvalidFramePayloadData, nil, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := &recordHandler{}
+			wc := &Conn{
+				upgrader: &Upgrader{Handler: h, MaxMessageSize: c.max},
+				compress: c.compress,
+				inBuf:    append([]byte(nil), c.in...),
+			}
+			err := wc.readFrames()
+			if code := closeCode(err); code != c.code {
+				t.Fatalf("close code %d, want %d(err %v)", code, c.code, err)
+			}
+			if len(h.messages) != len(c.messages) {
+				t.Fatalf("got %d messages, want %d", len(h.messages), len(c.messages))
+			}
+			for i, m := range c.messages {
+				if string(h.messages[i]) != m {
+					t.Fatalf("message %d is %q, want %q", i, h.messages[i], m)
+				}
+			}
+			if c.code == 0 && len(wc.inBuf) != c.rest {
+				t.Fatalf("%d bytes left, want %d", len(wc.inBuf), c.rest)
+			}
+		})
+	}
+}
+
+func TestOffersCompression(t *testing.T) {
+	cases := []struct {
+		in   []string
+		want bool
+	}{
+		{nil, false},
+		{[]string{"permessage-deflate"}, true},
+		{[]string{"x-webkit-deflate-frame, permessage-deflate; client_max_window_bits"}, true},
+		{[]string{"permessage-deflate-x"}, false},
+	}
+	for _, c := range cases {
+		if got := offersCompression(c.in); got != c.want {
+			t.Errorf("offersCompression(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
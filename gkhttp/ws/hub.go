@@ -0,0 +1,78 @@
+package ws
+
+import "sync"
+
+// Hub keeps track of open conns for broadcasting.
+type Hub struct {
+	conns map[*Conn]struct{}
+	lock  sync.RWMutex
+}
+
+func NewHub() *Hub {
+	return &Hub{conns: make(map[*Conn]struct{})}
+}
+
+func (that *Hub) add(c *Conn) {
+	that.lock.Lock()
+	that.conns[c] = struct{}{}
+	that.lock.Unlock()
+}
+
+func (that *Hub) remove(c *Conn) {
+	that.lock.Lock()
+	delete(that.conns, c)
+	that.lock.Unlock()
+}
+
+// Len returns the number of open conns.
+func (that *Hub) Len() int {
+	that.lock.RLock()
+	defer that.lock.RUnlock()
+	return len(that.conns)
+}
+
+// snapshot returns the open conns, so they are used without holding the lock: closing a
+// conn removes it from the hub.
+func (that *Hub) snapshot() []*Conn {
+	that.lock.RLock()
+	defer that.lock.RUnlock()
+	conns := make([]*Conn, 0, len(that.conns))
+	for c := range that.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Iterator calls f for every conn until f returns false, f may close the conns.
+func (that *Hub) Iterator(f func(c *Conn) bool) {
+	for _, c := range that.snapshot() {
+		if !f(c) {
+			break
+		}
+	}
+}
+
+// Broadcast sends a data message to all conns. The frame is encoded once(and compressed once
+// for conns with permessage-deflate), and every write runs on the event loop of its conn.
+func (that *Hub) Broadcast(typ MessageType, data []byte) (err error) {
+	var plain, compressed []byte
+	that.Iterator(func(c *Conn) bool {
+		var frame []byte
+		if c.compress && len(data) >= compressThreshold {
+			if compressed == nil {
+				compressed = appendFrame(nil, true, true, int(typ), compressMessage(data))
+			}
+			frame = compressed
+		} else {
+			if plain == nil {
+				plain = appendFrame(nil, true, false, int(typ), data)
+			}
+			frame = plain
+		}
+		if e := c.enqueue(frame, false); e != nil && e != ErrClosed {
+			err = e
+		}
+		return true
+	})
+	return
+}
@@ -0,0 +1,235 @@
+package ws
+
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/moqsien/gknet/conn"
+	"github.com/moqsien/gknet/gkhttp"
+	"github.com/moqsien/gknet/iface"
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize is used when Upgrader.MaxMessageSize is not set.
+const DefaultMaxMessageSize = 16 << 20
+
+// Upgrader upgrades HTTP conns to websocket conns.
+type Upgrader struct {
+	Handler           Handler
+	Hub               *Hub // optional, conns are added when opened and removed when closed
+	Subprotocols      []string
+	CheckOrigin       func(r *http.Request) bool // nil means the same origin policy
+	EnableCompression bool                       // negotiate permessage-deflate
+	MaxMessageSize    int64                      // <= 0 means DefaultMaxMessageSize
+}
+
+type handshakeError struct {
+	status int
+	msg    string
+}
+
+func (e *handshakeError) Error() string {
+	return ErrBadHandshake.Error() + ": " + e.msg
+}
+
+// Unwrap makes errors.Is(err, ErrBadHandshake) hold for the errors of a failed handshake.
+func (e *handshakeError) Unwrap() error {
+	return ErrBadHandshake
+}
+
+// Upgrade upgrades a request served by gkhttp. The conn stays on its event loop, and the
+// response writer must not be used after a successful Upgrade.
+func (that *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if err := that.checkHandshake(r); err != nil {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, err.msg, err.status)
+		return nil, err
+	}
+	c := gkhttp.ConnContext(r)
+	if c == nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, errors.New("[ws] request is not served by gkhttp")
+	}
+	return that.upgrade(c, r)
+}
+
+// maxMessageSize caps frames, fragmented messages and inflated messages.
+func (that *Upgrader) maxMessageSize() int64 {
+	if that.MaxMessageSize > 0 {
+		return that.MaxMessageSize
+	}
+	return DefaultMaxMessageSize
+}
+
+func tokenListContains(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func computeAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (that *Upgrader) selectSubprotocol(r *http.Request) string {
+	for _, v := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			for _, s := range that.Subprotocols {
+				if s == p {
+					return s
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func (that *Upgrader) checkHandshake(r *http.Request) *handshakeError {
+	if r.Method != http.MethodGet {
+		return &handshakeError{http.StatusMethodNotAllowed, "request method is not GET"}
+	}
+	if !tokenListContains(r.Header, "Connection", "upgrade") {
+		return &handshakeError{http.StatusBadRequest, "'upgrade' token not found in 'Connection' header"}
+	}
+	if !tokenListContains(r.Header, "Upgrade", "websocket") {
+		return &handshakeError{http.StatusBadRequest, "'websocket' token not found in 'Upgrade' header"}
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return &handshakeError{http.StatusBadRequest, "unsupported version"}
+	}
+	checkOrigin := that.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(r) {
+		return &handshakeError{http.StatusForbidden, "origin not allowed"}
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key")); err != nil || len(decoded) != 16 {
+		return &handshakeError{http.StatusBadRequest, "invalid 'Sec-WebSocket-Key'"}
+	}
+	return nil
+}
+
+// upgrade writes the 101 response and switches the handler of the conn.
+func (that *Upgrader) upgrade(c *iface.Context, r *http.Request) (*Conn, error) {
+	raw, ok := c.RawConn.(*conn.Conn)
+	if !ok {
+		return nil, errors.New("[ws] unsupported raw conn")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	wc := newConn(that, c, raw, r)
+	wc.subprotocol = that.selectSubprotocol(r)
+	wc.compress = that.EnableCompression && offersCompression(r.Header.Values("Sec-WebSocket-Extensions"))
+
+	res := make([]byte, 0, 256)
+	res = append(res, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: "...)
+	res = append(res, computeAcceptKey(key)...)
+	if wc.subprotocol != "" {
+		res = append(res, "\r\nSec-WebSocket-Protocol: "...)
+		res = append(res, wc.subprotocol...)
+	}
+	if wc.compress {
+		res = append(res, "\r\nSec-WebSocket-Extensions: "...)
+		res = append(res, extensionResponse...)
+	}
+	res = append(res, "\r\n\r\n"...)
+	if err := wc.writeNow(res, false); err != nil {
+		return nil, err
+	}
+
+	raw.Handler = wc
+	if that.Hub != nil {
+		that.Hub.add(wc)
+	}
+	if that.Handler != nil {
+		that.Handler.OnOpen(wc)
+	}
+	return wc, nil
+}
+
+// EventHandler serves websocket directly on an engine, without gkhttp. It reads the
+// handshake request of every new conn, and then hands the conn over to a ws.Conn.
+type EventHandler struct {
+	Upgrader *Upgrader
+}
+
+func NewEventHandler(up *Upgrader) *EventHandler {
+	return &EventHandler{Upgrader: up}
+}
+
+func (that *EventHandler) OnAccept(c iface.RawConn) error {
+	return nil
+}
+
+func (that *EventHandler) OnOpen(c *iface.Context) ([]byte, error) {
+	return nil, nil
+}
+
+func (that *EventHandler) OnClose(c *iface.Context) error {
+	return nil
+}
+
+func (that *EventHandler) OnTrack(c *iface.Context) error {
+	req, err := http.ReadRequest(c.Reader)
+	if err != nil {
+		return err
+	}
+	if e := that.Upgrader.checkHandshake(req); e != nil {
+		that.reject(c, e)
+		return e
+	}
+	wc, err := that.Upgrader.upgrade(c, req)
+	if err != nil {
+		return err
+	}
+	if c.Reader.Buffered() > 0 {
+		return wc.OnTrack(c)
+	}
+	return nil
+}
+
+// reject writes an error response and closes the conn.
+func (that *EventHandler) reject(c *iface.Context, e *handshakeError) {
+	raw, ok := c.RawConn.(*conn.Conn)
+	if !ok {
+		return
+	}
+	var w io.Writer = raw
+	if _, isTLS := c.Conn.(*tls.Conn); isTLS {
+		w = c.Conn
+	}
+	w.Write([]byte("HTTP/1.1 " + strconv.Itoa(e.status) + " " + http.StatusText(e.status) +
+		"\r\nConnection: close\r\nSec-WebSocket-Version: 13\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: " +
+		strconv.Itoa(len(e.msg)) + "\r\n\r\n" + e.msg))
+	raw.Close()
+}
@@ -7,6 +7,33 @@ const (
 	ConnAsyncWritevAdapter ConnAdapter = 3
 )
 
+const (
+	ProxyProtocolOff      ProxyProtocol = 0
+	ProxyProtocolOptional ProxyProtocol = 1 // conns without a header are served as they are
+	ProxyProtocolRequired ProxyProtocol = 2 // conns without a valid header are closed
+)
+
+const (
+	DispatchPooled  DispatchMode = 0 // on the goroutine pool, behind a bounded queue
+	DispatchInline  DispatchMode = 1 // on the loop goroutine, for handlers which never block
+	DispatchPerConn DispatchMode = 2 // on a goroutine of the conn, for blocking handlers
+)
+
+const (
+	OverflowBlock      OverflowPolicy = 0 // the poller waits for room in the queue
+	OverflowReject     OverflowPolicy = 1 // the conn is closed
+	OverflowCallerRuns OverflowPolicy = 2 // the work runs on the goroutine submitting it
+)
+
+const (
+	TraceAccept    TraceStage = 0 // the conn is packed and OnAccept runs on the main loop
+	TraceRegister  TraceStage = 1 // the conn is registered on its sub loop
+	TraceHandshake TraceStage = 2 // the TLS handshake
+	TraceOpen      TraceStage = 3 // OnOpen
+	TraceTrack     TraceStage = 4 // one OnTrack
+	TraceClose     TraceStage = 5 // OnClose
+)
+
 const (
 	RoundRobinLB Balancer = 0
 	LeastConnLB  Balancer = 1
@@ -18,5 +45,15 @@ const (
 	MaxTasks               int = 256
 	DefaultWritevChunkSize int = 2048
 	DefaultGoroutineSize   int = 1024
+	DefaultDispatchQueue   int = 4096
 	DefaultErrInfoChanSize int = DefaultGoroutineSize
 )
+
+var traceStages = [...]string{"accept", "register", "handshake", "open", "track", "close"}
+
+func (that TraceStage) String() string {
+	if that >= 0 && int(that) < len(traceStages) {
+		return traceStages[that]
+	}
+	return "unknown"
+}
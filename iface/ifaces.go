@@ -4,6 +4,8 @@ import (
 	"net"
 	"os"
 	"sync"
+
+	"github.com/moqsien/gknet/utils/budget"
 )
 
 type IELoop interface {
@@ -29,6 +31,7 @@ type IEngine interface {
 	GetOptions() *Options
 	GetBalancer() IBalancer
 	GetHandler() IEventHandler
+	GetBudget() *budget.Budget
 }
 
 type IEventHandler interface {
@@ -38,6 +41,60 @@ type IEventHandler interface {
 	OnClose(*Context) error
 }
 
+// IWritabilityHandler may be implemented by an IEventHandler. OnWritable(c, false) is called
+// when the pending output of a conn crosses Options.WriteHighWaterMark, OnWritable(c, true)
+// when it falls to the low mark again.
+type IWritabilityHandler interface {
+	OnWritable(c *Context, writable bool)
+}
+
+// IReadEOFHandler may be implemented by an IEventHandler. OnReadEOF is called when the peer
+// shuts down its write side, instead of closing the conn: the handler can keep writing and
+// call CloseWrite of the conn when it is done. An error closes the conn.
+type IReadEOFHandler interface {
+	OnReadEOF(c *Context) error
+}
+
+// WorkerPool runs the handlers and tasks of the loops, *ants.Pool implements it.
+type WorkerPool interface {
+	Submit(task func()) error
+	Running() int
+	Cap() int // capacity, not positive if unbounded
+	Release()
+}
+
+// Admission decides on the accepted conns before they are registered, see package
+// admission. Admit is called on the main loop, release once the admitted conn is closed.
+// Rejected sockets are closed at once, with a RST if RejectWithReset is true.
+type Admission interface {
+	Admit(remote net.Addr) (release func(), err error)
+	RejectWithReset() bool
+}
+
+// Logger receives the messages of an engine, see Options.Logger. fields are pairs of keys
+// and values, With returns a logger adding fields to every message.
+type Logger interface {
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+	With(fields ...interface{}) Logger
+}
+
+// Tracer follows the conns of an engine from accept to close, see Options.Tracer.
+// TraceConn is called on the main loop for every accepted conn.
+type Tracer interface {
+	TraceConn(local, remote net.Addr) ConnTrace
+}
+
+// ConnTrace gets the stages of one conn. Start is called when a stage begins and the
+// returned function with its error when it is over, End once the conn is closed or
+// detached. Stages of a conn may overlap and run on different goroutines.
+type ConnTrace interface {
+	Start(stage TraceStage) (end func(err error))
+	End(err error)
+}
+
 type IPollCallback interface {
 	Callback(fd int, events uint32) error
 	AsyncCallback(fd int, events uint32) chan error
@@ -58,3 +115,10 @@ type IListener interface {
 	File() (*os.File, error)
 	IsUDP() bool
 }
+
+// IDispatchListener is a listener whose conns are dispatched in a mode of their own instead
+// of Options.DispatchMode, see socket.WithDispatchMode.
+type IDispatchListener interface {
+	IListener
+	DispatchMode() DispatchMode
+}
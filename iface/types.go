@@ -6,6 +6,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/moqsien/gknet/proxyproto"
 	"github.com/moqsien/gknet/sys"
 )
 
@@ -15,6 +16,14 @@ type Balancer int
 
 type ConnAdapter int
 
+type ProxyProtocol int
+
+type DispatchMode int
+
+type OverflowPolicy int
+
+type TraceStage int
+
 type RawConn interface {
 	sys.EventHandler
 }
@@ -47,6 +56,100 @@ type Options struct {
 	ConnAsyncCallback AsyncCallback
 	WritevChunkSize   int
 	GoroutineSize     int
+	// PROXY protocol headers are read before OnOpen from the sources in ProxyProtocolTrusted,
+	// which must not be empty, "0.0.0.0/0" and "::/0" trust all. The header must arrive within
+	// ProxyProtocolTimeout(5s).
+	ProxyProtocol        ProxyProtocol
+	ProxyProtocolTimeout time.Duration
+	ProxyProtocolTrusted []string // CIDRs or addresses
+	// Reading a conn stops while its pending output is above WriteHighWaterMark, and resumes
+	// once it falls to WriteLowWaterMark(half of the high mark). A conn whose pending output
+	// would exceed WriteBufferLimit is closed. Zero disables them.
+	WriteHighWaterMark int
+	WriteLowWaterMark  int
+	WriteBufferLimit   int
+	// BufferBudget limits the bytes held in the buffers of all conns, new conns are rejected
+	// near the limit. A conn holding more than ConnInBufferLimit unread bytes is closed.
+	BufferBudget      int64
+	ConnInBufferLimit int
+	// ReuseConns puts closed conns into a pool, with their buffers and Context. Handlers
+	// must not use a Context after OnClose, conn.Ref detects conns closed meanwhile.
+	ReuseConns bool
+	// DispatchMode decides where the handlers of the conns accepted by the listener run, a
+	// listener from socket.WithDispatchMode overrides it. In DispatchPooled mode, work waits
+	// in a queue of DispatchQueueSize(4096) while all goroutines of the pool are busy,
+	// DispatchOverflow decides what happens when it is full. In DispatchPerConn mode every
+	// conn has a goroutine of its own until it is closed.
+	DispatchMode      DispatchMode
+	DispatchQueueSize int
+	DispatchOverflow  OverflowPolicy
+	// WorkerPool replaces the ants pool of GoroutineSize shared by the loops, the engine does
+	// not release it. Otherwise with PoolPerLoop every loop gets a pool of its own from
+	// NewWorkerPool, an ants pool of GoroutineSize if it is nil.
+	WorkerPool    WorkerPool
+	PoolPerLoop   bool
+	NewWorkerPool func(loop int) (WorkerPool, error)
+	// TCPInfoInterval is the period of sampling the RTTs of all conns into the metrics of
+	// their loops, zero disables it.
+	TCPInfoInterval time.Duration
+	// Tracer is told about the lifecycle of the accepted conns, nil disables tracing.
+	Tracer Tracer
+	// Logger gets the messages of the engine with the loop, fd and remote address of the
+	// conns as fields, the processes logger if it is nil. See package logging.
+	Logger Logger
+	// Admission is consulted for every accepted conn, nil admits all.
+	Admission Admission
+	// Reading a conn stops for a while once it has read ReadRateLimit bytes per second on
+	// average, in bursts of ReadRateBurst(ReadRateLimit) bytes, or called OnTrack
+	// TrackRateLimit times per second, in bursts of TrackRateBurst. Zero disables them,
+	// conn.SetReadRate and conn.SetTrackRate override them in OnOpen.
+	ReadRateLimit  float64
+	ReadRateBurst  int
+	TrackRateLimit float64
+	TrackRateBurst int
+	// Socket is set on every accepted and dialed TCP fd, see SocketOptions.
+	Socket SocketOptions
+}
+
+// SocketOptions of the TCP fds, zero values keep the defaults of the system. Options the
+// platform lacks(TCP_USER_TIMEOUT and TCP_QUICKACK on macOS) are skipped, the setters of
+// conn.Conn return errs.ErrUnsupportedSockOpt for them.
+type SocketOptions struct {
+	NoDelay        bool          // TCP_NODELAY
+	Cork           bool          // TCP_CORK, TCP_NOPUSH on macOS
+	UserTimeout    time.Duration // TCP_USER_TIMEOUT
+	KeepAliveCount int           // TCP_KEEPCNT, the keepalive period is ConnKeepAlive
+	// Linger is SO_LINGER, a positive value waits so long for the unsent output on close,
+	// a negative one closes with a RST.
+	Linger       time.Duration
+	TOS          int  // IP_TOS, IPV6_TCLASS on IPv6
+	QuickAck     bool // TCP_QUICKACK
+	NotSentLowat int  // TCP_NOTSENT_LOWAT in bytes
+}
+
+// PoolStats shows the saturation of the worker pools of an engine. Queued is the work
+// waiting in the dispatch queues, Rejected counts the work the queues or pools refused.
+type PoolStats struct {
+	Running  int
+	Cap      int
+	Queued   int
+	Rejected uint64
+}
+
+// ConnInfo is a snapshot of an open conn, see Engine.Connections. BufferedOut is the
+// pending output when the conn was last handled, TLS is nil for plain conns.
+type ConnInfo struct {
+	ID          uint64
+	Fd          int
+	Loop        int
+	LocalAddr   net.Addr
+	RemoteAddr  net.Addr
+	Opened      time.Time
+	Age         time.Duration
+	BytesIn     uint64
+	BytesOut    uint64
+	BufferedOut int
+	TLS         *tls.ConnectionState
 }
 
 type Context struct {
@@ -54,6 +157,7 @@ type Context struct {
 	ReadWriter *bufio.ReadWriter
 	RawConn    RawConn
 	Conn       net.Conn
+	Proxy      *proxyproto.Header // the PROXY protocol header of the conn, if any
 }
 
 func (that *Context) Write(data []byte) (int, error) {
@@ -0,0 +1,77 @@
+/*
+Package logging provides the loggers for Options.Logger, fields are pairs of keys and
+values as in log/slog.
+*/
+package logging
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/moqsien/processes/logger"
+
+	"github.com/moqsien/gknet/iface"
+)
+
+// Default writes to github.com/moqsien/processes/logger, with the fields after the message.
+var Default iface.Logger = &procLogger{}
+
+// Nop drops all messages.
+var Nop iface.Logger = nop{}
+
+// Or returns l, or Default if l is nil.
+func Or(l iface.Logger) iface.Logger {
+	if l == nil {
+		return Default
+	}
+	return l
+}
+
+type procLogger struct {
+	fields string // rendered fields of With
+}
+
+func (that *procLogger) Debug(msg string, fields ...interface{}) {
+	logger.Debug(that.format(msg, fields))
+}
+
+func (that *procLogger) Info(msg string, fields ...interface{}) {
+	logger.Info(that.format(msg, fields))
+}
+
+func (that *procLogger) Warn(msg string, fields ...interface{}) {
+	logger.Warning(that.format(msg, fields))
+}
+
+func (that *procLogger) Error(msg string, fields ...interface{}) {
+	logger.Error(that.format(msg, fields))
+}
+
+func (that *procLogger) With(fields ...interface{}) iface.Logger {
+	return &procLogger{fields: that.fields + render(fields)}
+}
+
+func (that *procLogger) format(msg string, fields []interface{}) string {
+	return msg + that.fields + render(fields)
+}
+
+// render writes the fields as " key=value", a value without a key gets "!BADKEY".
+func render(fields []interface{}) string {
+	var b strings.Builder
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 == len(fields) {
+			fmt.Fprintf(&b, " !BADKEY=%v", fields[i])
+			break
+		}
+		fmt.Fprintf(&b, " %v=%v", fields[i], fields[i+1])
+	}
+	return b.String()
+}
+
+type nop struct{}
+
+func (nop) Debug(string, ...interface{})          {}
+func (nop) Info(string, ...interface{})           {}
+func (nop) Warn(string, ...interface{})           {}
+func (nop) Error(string, ...interface{})          {}
+func (that nop) With(...interface{}) iface.Logger { return that }
@@ -0,0 +1,38 @@
+//go:build go1.21
+
+package logging
+
+import (
+	"log/slog"
+
+	"github.com/moqsien/gknet/iface"
+)
+
+// Slog adapts l to iface.Logger.
+func Slog(l *slog.Logger) iface.Logger {
+	return slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (that slogLogger) Debug(msg string, fields ...interface{}) {
+	that.l.Debug(msg, fields...)
+}
+
+func (that slogLogger) Info(msg string, fields ...interface{}) {
+	that.l.Info(msg, fields...)
+}
+
+func (that slogLogger) Warn(msg string, fields ...interface{}) {
+	that.l.Warn(msg, fields...)
+}
+
+func (that slogLogger) Error(msg string, fields ...interface{}) {
+	that.l.Error(msg, fields...)
+}
+
+func (that slogLogger) With(fields ...interface{}) iface.Logger {
+	return slogLogger{l: that.l.With(fields...)}
+}
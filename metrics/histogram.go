@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// RTTBuckets are the upper bounds, in seconds, of the buckets of the RTT histograms.
+var RTTBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// Histogram counts observations in buckets without a lock.
+type Histogram struct {
+	bounds []float64
+	counts []uint64 // the last one is +Inf
+	sum    uint64   // bits of a float64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (that *Histogram) Observe(v float64) {
+	i := 0
+	for i < len(that.bounds) && v > that.bounds[i] {
+		i++
+	}
+	atomic.AddUint64(&that.counts[i], 1)
+	for {
+		old := atomic.LoadUint64(&that.sum)
+		if atomic.CompareAndSwapUint64(&that.sum, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// cumulative returns the counts of the buckets as Prometheus wants them, each one
+// including the smaller ones, and the sum.
+func (that *Histogram) cumulative() (counts []uint64, sum float64) {
+	counts = make([]uint64, len(that.counts))
+	var n uint64
+	for i := range that.counts {
+		n += atomic.LoadUint64(&that.counts[i])
+		counts[i] = n
+	}
+	return counts, math.Float64frombits(atomic.LoadUint64(&that.sum))
+}
@@ -0,0 +1,115 @@
+/*
+Package metrics counts what the loops of an engine do and renders it in the Prometheus text
+format, without a client library.
+*/
+package metrics
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Loop holds the counters of one event loop. It is updated by the loop and its conns
+// without a lock, the methods do nothing on a nil Loop.
+type Loop struct {
+	accepts      uint64
+	acceptErrors uint64
+	rejected     uint64
+	opened       uint64
+	closed       uint64
+	readBytes    uint64
+	writtenBytes uint64
+	readAgain    uint64
+	writeAgain   uint64
+	wakeups      uint64
+	events       uint64
+	rtt          *Histogram // RTTs sampled from the conns
+	label        string
+	conns        func() int // active conns
+	tasks        func() int // tasks queued in the poller
+}
+
+func (that *Loop) add(v *uint64, n int) {
+	if n > 0 {
+		atomic.AddUint64(v, uint64(n))
+	}
+}
+
+func (that *Loop) Accept() {
+	if that != nil {
+		that.add(&that.accepts, 1)
+	}
+}
+
+func (that *Loop) AcceptError() {
+	if that != nil {
+		that.add(&that.acceptErrors, 1)
+	}
+}
+
+// AcceptRejected counts a conn refused by the admission.
+func (that *Loop) AcceptRejected() {
+	if that != nil {
+		that.add(&that.rejected, 1)
+	}
+}
+
+// Conns counts n conns opened if n is positive, -n conns closed otherwise.
+func (that *Loop) Conns(n int) {
+	switch {
+	case that == nil:
+	case n > 0:
+		that.add(&that.opened, n)
+	default:
+		that.add(&that.closed, -n)
+	}
+}
+
+func (that *Loop) Read(n int) {
+	if that != nil {
+		that.add(&that.readBytes, n)
+	}
+}
+
+func (that *Loop) Written(n int) {
+	if that != nil {
+		that.add(&that.writtenBytes, n)
+	}
+}
+
+// ReadAgain counts a read which got EAGAIN.
+func (that *Loop) ReadAgain() {
+	if that != nil {
+		that.add(&that.readAgain, 1)
+	}
+}
+
+// WriteAgain counts a write which got EAGAIN, the socket buffer was full.
+func (that *Loop) WriteAgain() {
+	if that != nil {
+		that.add(&that.writeAgain, 1)
+	}
+}
+
+// Wakeup counts a return of epoll_wait(kevent) with n events.
+func (that *Loop) Wakeup(n int) {
+	if that != nil {
+		that.add(&that.wakeups, 1)
+		that.add(&that.events, n)
+	}
+}
+
+// ObserveRTT records the RTT of a conn of the loop.
+func (that *Loop) ObserveRTT(d time.Duration) {
+	if that != nil {
+		that.rtt.Observe(d.Seconds())
+	}
+}
+
+func loopLabel(index int) string {
+	if index < 0 {
+		return "main"
+	}
+	return strconv.Itoa(index)
+}
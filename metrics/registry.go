@@ -0,0 +1,226 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry keeps the loops and the gauges of an engine. Its labels are put on every sample,
+// so the registries of several engines can be rendered together.
+type Registry struct {
+	labels string
+	lock   sync.Mutex
+	loops  []*Loop
+	funcs  []funcMetric
+}
+
+type funcMetric struct {
+	name, help, typ string
+	fn              func() float64
+}
+
+// NewRegistry returns a registry, labels are pairs of names and values.
+func NewRegistry(labels ...string) *Registry {
+	var b strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i] + `="` + escape(labels[i+1]) + `"`)
+	}
+	return &Registry{labels: b.String()}
+}
+
+// NewLoop adds the counters of a loop, index is negative for the main loop. conns and tasks
+// report the open conns and the tasks queued in the poller, they may be nil.
+func (that *Registry) NewLoop(index int, conns, tasks func() int) *Loop {
+	l := &Loop{label: loopLabel(index), conns: conns, tasks: tasks, rtt: NewHistogram(RTTBuckets)}
+	that.lock.Lock()
+	that.loops = append(that.loops, l)
+	that.lock.Unlock()
+	return l
+}
+
+// GaugeFunc adds a gauge whose value is fn() when rendered.
+func (that *Registry) GaugeFunc(name, help string, fn func() float64) {
+	that.addFunc(funcMetric{name: name, help: help, typ: "gauge", fn: fn})
+}
+
+// CounterFunc adds a counter whose value is fn() when rendered.
+func (that *Registry) CounterFunc(name, help string, fn func() float64) {
+	that.addFunc(funcMetric{name: name, help: help, typ: "counter", fn: fn})
+}
+
+func (that *Registry) addFunc(m funcMetric) {
+	that.lock.Lock()
+	that.funcs = append(that.funcs, m)
+	that.lock.Unlock()
+}
+
+func (that *Registry) WriteTo(w io.Writer) (int64, error) {
+	return Write(w, that)
+}
+
+func (that *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	Handler(that).ServeHTTP(w, r)
+}
+
+type sample struct {
+	suffix string // of the name, for the series of a histogram
+	labels string
+	value  float64
+}
+
+type family struct {
+	name, help, typ string
+	samples         []sample
+}
+
+type families struct {
+	list  []*family
+	index map[string]*family
+}
+
+func (that *families) add(name, help, typ, labels string, v float64) {
+	that.addSample(name, help, typ, sample{labels: labels, value: v})
+}
+
+func (that *families) addSample(name, help, typ string, s sample) {
+	f := that.index[name]
+	if f == nil {
+		f = &family{name: name, help: help, typ: typ}
+		that.index[name] = f
+		that.list = append(that.list, f)
+	}
+	f.samples = append(f.samples, s)
+}
+
+// addHistogram adds the series of h, unless nothing has been observed.
+func (that *families) addHistogram(name, help, labels string, h *Histogram) {
+	counts, sum := h.cumulative()
+	total := counts[len(counts)-1]
+	if total == 0 {
+		return
+	}
+	for i, n := range counts {
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+		}
+		that.addSample(name, help, "histogram", sample{suffix: "_bucket", labels: labels + `,le="` + le + `"`, value: float64(n)})
+	}
+	that.addSample(name, help, "histogram", sample{suffix: "_sum", labels: labels, value: sum})
+	that.addSample(name, help, "histogram", sample{suffix: "_count", labels: labels, value: float64(total)})
+}
+
+func (that *Registry) collect(fs *families) {
+	that.lock.Lock()
+	loops := append([]*Loop(nil), that.loops...)
+	funcs := append([]funcMetric(nil), that.funcs...)
+	that.lock.Unlock()
+
+	counter := func(name, help string, l *Loop, v *uint64, extra ...string) {
+		fs.add(name, help, "counter", that.join(l, extra...), float64(atomic.LoadUint64(v)))
+	}
+	for _, l := range loops {
+		if l.label == "main" {
+			counter("gknet_accepts_total", "Conns accepted.", l, &l.accepts)
+			counter("gknet_accept_errors_total", "Failed accepts.", l, &l.acceptErrors)
+			counter("gknet_accept_rejected_total", "Accepted conns refused by the admission.", l, &l.rejected)
+		}
+	}
+	for _, l := range loops {
+		if l.label == "main" {
+			continue
+		}
+		counter("gknet_connections_opened_total", "Conns opened.", l, &l.opened)
+		counter("gknet_connections_closed_total", "Conns closed.", l, &l.closed)
+		if l.conns != nil {
+			fs.add("gknet_connections_active", "Open conns.", "gauge", that.join(l), float64(l.conns()))
+		}
+		counter("gknet_read_bytes_total", "Bytes read from conns.", l, &l.readBytes)
+		counter("gknet_written_bytes_total", "Bytes written to conns.", l, &l.writtenBytes)
+		counter("gknet_eagain_total", "Reads and writes which got EAGAIN.", l, &l.readAgain, "op", "read")
+		counter("gknet_eagain_total", "Reads and writes which got EAGAIN.", l, &l.writeAgain, "op", "write")
+		fs.addHistogram("gknet_conn_rtt_seconds", "Smoothed RTTs sampled from the conns.", that.join(l), l.rtt)
+	}
+	for _, l := range loops {
+		counter("gknet_loop_wakeups_total", "Returns of epoll_wait or kevent.", l, &l.wakeups)
+		counter("gknet_loop_events_total", "Events reported by epoll_wait or kevent.", l, &l.events)
+		if l.tasks != nil {
+			fs.add("gknet_poller_tasks", "Tasks queued in the poller.", "gauge", that.join(l), float64(l.tasks()))
+		}
+	}
+	for _, m := range funcs {
+		fs.add(m.name, m.help, m.typ, that.labels, m.fn())
+	}
+}
+
+// join renders the labels of a sample of loop l.
+func (that *Registry) join(l *Loop, extra ...string) string {
+	s := `loop="` + l.label + `"`
+	if that.labels != "" {
+		s = that.labels + "," + s
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		s += "," + extra[i] + `="` + escape(extra[i+1]) + `"`
+	}
+	return s
+}
+
+// Write renders the metrics of the registries in the Prometheus text format.
+func Write(w io.Writer, regs ...*Registry) (int64, error) {
+	fs := &families{index: make(map[string]*family)}
+	for _, r := range regs {
+		if r != nil {
+			r.collect(fs)
+		}
+	}
+	cw := &countWriter{w: w}
+	b := bufio.NewWriter(cw)
+	for _, f := range fs.list {
+		b.WriteString("# HELP " + f.name + " " + f.help + "\n")
+		b.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+		for _, s := range f.samples {
+			b.WriteString(f.name + s.suffix)
+			if s.labels != "" {
+				b.WriteString("{" + s.labels + "}")
+			}
+			b.WriteString(" " + strconv.FormatFloat(s.value, 'g', -1, 64) + "\n")
+		}
+	}
+	err := b.Flush()
+	return cw.n, err
+}
+
+// Handler serves the metrics of the registries, it can be mounted on gkhttp or any mux.
+func Handler(regs ...*Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		Write(w, regs...)
+	})
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (that *countWriter) Write(p []byte) (int, error) {
+	n, err := that.w.Write(p)
+	that.n += int64(n)
+	return n, err
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
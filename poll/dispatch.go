@@ -0,0 +1,118 @@
+package poll
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/utils/errs"
+)
+
+// WorkQueue keeps the work submitted while all goroutines of the pool are busy, the
+// goroutines take it from the queue when they are done. It is shared by the pollers
+// sharing the pool.
+type WorkQueue struct {
+	rejected uint64 // atomic, first for the alignment on 32-bit platforms
+	pool     iface.WorkerPool
+	size     int
+	overflow iface.OverflowPolicy
+	lock     sync.Mutex
+	room     *sync.Cond
+	tasks    []func()
+	busy     int // goroutines of the pool running the queue
+}
+
+func NewWorkQueue(pool iface.WorkerPool, size int, overflow iface.OverflowPolicy) *WorkQueue {
+	if size <= 0 {
+		size = iface.DefaultDispatchQueue
+	}
+	q := &WorkQueue{pool: pool, size: size, overflow: overflow}
+	q.room = sync.NewCond(&q.lock)
+	return q
+}
+
+// Submit runs fn on the pool, or queues it. wait is false for the goroutines which must
+// not block, they run fn themselves when the queue is full in OverflowBlock mode.
+func (that *WorkQueue) Submit(fn func(), wait bool) error {
+	that.lock.Lock()
+	for {
+		if c := that.pool.Cap(); c <= 0 || that.busy < c {
+			that.busy++
+			that.lock.Unlock()
+			err := that.pool.Submit(func() { that.run(fn) })
+			if err != nil {
+				atomic.AddUint64(&that.rejected, 1)
+				that.lock.Lock()
+				that.busy--
+				that.lock.Unlock()
+			}
+			return err
+		}
+		if len(that.tasks) < that.size {
+			that.tasks = append(that.tasks, fn)
+			that.lock.Unlock()
+			return nil
+		}
+		if that.overflow != iface.OverflowBlock || !wait {
+			break
+		}
+		that.room.Wait()
+	}
+	that.lock.Unlock()
+	if that.overflow == iface.OverflowReject {
+		atomic.AddUint64(&that.rejected, 1)
+		return errs.ErrDispatchFull
+	}
+	fn()
+	return nil
+}
+
+func (that *WorkQueue) run(fn func()) {
+	for fn != nil {
+		fn()
+		that.lock.Lock()
+		fn = nil
+		if len(that.tasks) > 0 {
+			fn = that.tasks[0]
+			that.tasks[0] = nil
+			that.tasks = that.tasks[1:]
+		} else {
+			that.busy--
+		}
+		that.room.Broadcast()
+		that.lock.Unlock()
+	}
+}
+
+// Len returns the number of queued functions.
+func (that *WorkQueue) Len() int {
+	that.lock.Lock()
+	defer that.lock.Unlock()
+	return len(that.tasks)
+}
+
+// Rejected returns the number of functions refused by the queue or the pool.
+func (that *WorkQueue) Rejected() uint64 {
+	return atomic.LoadUint64(&that.rejected)
+}
+
+// Dispatch runs the work of a conn as Mode says, fromLoop is true if it is called on the
+// loop goroutine. Conns run their own goroutines in DispatchPerConn mode instead.
+func (that *Poller) Dispatch(fn func(), fromLoop bool) error {
+	switch {
+	case that.Mode == iface.DispatchInline && fromLoop:
+		fn()
+		return nil
+	case that.Mode == iface.DispatchInline:
+		return that.AddTask(func(_ iface.PollTaskArg) error {
+			fn()
+			return nil
+		}, nil)
+	case that.Queue != nil:
+		return that.Queue.Submit(fn, fromLoop)
+	case that.Pool != nil:
+		return that.Pool.Submit(fn)
+	}
+	fn()
+	return nil
+}
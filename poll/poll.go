@@ -7,10 +7,9 @@ import (
 	"sync"
 	"sync/atomic"
 
-	"github.com/moqsien/processes/logger"
-	"github.com/panjf2000/ants/v2"
-
 	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/logging"
+	"github.com/moqsien/gknet/metrics"
 	"github.com/moqsien/gknet/sys"
 	"github.com/moqsien/gknet/utils"
 	"github.com/moqsien/gknet/utils/errs"
@@ -18,16 +17,20 @@ import (
 )
 
 type Poller struct {
-	pollFd         int             // poll file descriptor
-	pollEvFd       int             // poll event file descriptor
-	priorTasks     queue.TaskQueue // tasks with priority
-	tasks          queue.TaskQueue // tasks
-	toTrigger      int32           // atomic number to trigger tasks
-	Eloop          iface.IELoop    // eventloop
-	Pool           *ants.Pool      // goroutine pool for running tasks
-	ErrForStop     chan error      // channel for sending error info to stop the whole engine
-	wg             *sync.WaitGroup // wait for tasks to complete
-	ReadBufferSize int             // size of read buffer when reading from fd
+	pollFd         int                // poll file descriptor
+	pollEvFd       int                // poll event file descriptor
+	priorTasks     queue.TaskQueue    // tasks with priority
+	tasks          queue.TaskQueue    // tasks
+	toTrigger      int32              // atomic number to trigger tasks
+	Eloop          iface.IELoop       // eventloop
+	Pool           iface.WorkerPool   // goroutine pool for running tasks
+	Mode           iface.DispatchMode // where the work of conns runs
+	Queue          *WorkQueue         // queue in front of Pool for the work of conns
+	Metrics        *metrics.Loop      // counters of the loop, may be nil
+	Logger         iface.Logger       // with the loop as a field
+	ErrForStop     chan error         // channel for sending error info to stop the whole engine
+	wg             *sync.WaitGroup    // wait for tasks to complete
+	ReadBufferSize int                // size of read buffer when reading from fd
 }
 
 func (that *Poller) GetFd() int {
@@ -38,6 +41,11 @@ func (that *Poller) GetPollEvFd() int {
 	return that.pollEvFd
 }
 
+// TaskCount returns the number of tasks waiting to run.
+func (that *Poller) TaskCount() int {
+	return that.tasks.Len() + that.priorTasks.Len()
+}
+
 func New() (p *Poller, err error) {
 	p = new(Poller)
 	p.pollFd, p.pollEvFd, err = sys.CreatePoll()
@@ -48,6 +56,7 @@ func New() (p *Poller, err error) {
 	p.priorTasks = queue.NewQueue()
 	p.tasks = queue.NewQueue()
 	p.wg = &sync.WaitGroup{}
+	p.Logger = logging.Default
 	return
 }
 
@@ -81,11 +90,11 @@ func (that *Poller) runTask(task *PollTask, wg *sync.WaitGroup) (err error) {
 		case errs.ErrEngineShutdown, errs.ErrAcceptSocket:
 			return err
 		default:
-			logger.Warningf("error occurs in user-defined function, %v", err)
+			that.Logger.Warn("error occurs in a task", "err", err)
 			return nil
 		}
 	} else {
-		that.Pool.Submit(func() {
+		err = that.Pool.Submit(func() {
 			defer wg.Done()
 			switch errInfo := task.Go(task.Arg); errInfo {
 			case nil:
@@ -96,11 +105,16 @@ func (that *Poller) runTask(task *PollTask, wg *sync.WaitGroup) (err error) {
 					break
 				}
 			default:
-				logger.Warningf("error occurs in user-defined function, %v", err)
+				that.Logger.Warn("error occurs in a task", "err", errInfo)
 			}
 			PutTask(task)
 			return
 		})
+		if err != nil {
+			wg.Done()
+			that.Logger.Warn("failed to submit a task of the poller", "err", err)
+			PutTask(task)
+		}
 	}
 	return
 }
@@ -145,8 +159,6 @@ func (that *Poller) Start(callback iface.IPollCallback) error {
 					trigger = true
 				}
 			}
-		} else {
-			wg.Wait()
 		}
 
 		if callback.IsBlocked() { // for accpeting.
@@ -166,17 +178,21 @@ func (that *Poller) Start(callback iface.IPollCallback) error {
 		}
 		return trigger, err
 	}
-	return sys.WaitPoll(that.pollFd, that.pollEvFd, wcb, doWaitCallbackErr, that.wg)
+	err := sys.WaitPoll(that.pollFd, that.pollEvFd, wcb, that.doWaitCallbackErr, that.Metrics.Wakeup, that.wg)
+	if err != nil && err != errs.ErrEngineShutdown && err != errs.ErrAcceptSocket {
+		that.Logger.Error("the poller stops", "err", err)
+	}
+	return err
 }
 
-func doWaitCallbackErr(err error) error {
+func (that *Poller) doWaitCallbackErr(err error) error {
 	switch err {
 	case nil:
 		return nil
 	case errs.ErrAcceptSocket, errs.ErrEngineShutdown:
 		return err
 	default:
-		logger.Warningf("Error occurs in eventloop: %v", err)
+		that.Logger.Warn("error occurs in the event loop", "err", err)
 		return nil
 	}
 }
@@ -215,6 +231,10 @@ func (that *Poller) ModWrite(fd iface.IFd) error {
 	return sys.ModWrite(that.pollFd, fd.GetFd())
 }
 
+func (that *Poller) ModNone(fd iface.IFd) error {
+	return sys.ModNone(that.pollFd, fd.GetFd())
+}
+
 func (that *Poller) RemoveFd(fd iface.IFd) error {
 	return sys.UnRegister(that.pollFd, fd.GetFd())
 }
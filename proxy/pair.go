@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/moqsien/gknet/conn"
+	"github.com/moqsien/gknet/sys"
+)
+
+// pipeSize is the default capacity of a pipe on Linux.
+const pipeSize = 64 << 10
+
+// pair is an accepted conn and its upstream conn.
+type pair struct {
+	down, up       *conn.Conn
+	downRef, upRef conn.Ref // a conn may be closed on its own before the pair is
+	toUp, toDown   *flow
+	highWater      int
+	closed         int32
+	lock           sync.Mutex
+}
+
+// side is the conn.FdHook of one conn of a pair.
+type side struct {
+	p   *pair
+	in  *flow // the flow reading the conn
+	out *flow // the flow writing the conn
+}
+
+func (that *side) OnReadable(c *conn.Conn) error {
+	return that.p.run(func() error {
+		return that.in.pump(that.p.highWater)
+	})
+}
+
+// OnWritable also gets the hangups and errors of the conn, which the event loop handles
+// as writable events.
+func (that *side) OnWritable(c *conn.Conn) error {
+	return that.p.run(func() error {
+		if that.out.pending() {
+			return that.out.onWritable()
+		}
+		if errno, err := syscall.GetsockoptInt(c.Fd, syscall.SOL_SOCKET, syscall.SO_ERROR); err != nil || errno != 0 {
+			return syscall.ECONNRESET
+		}
+		if !that.in.eof {
+			return that.in.pump(that.p.highWater)
+		}
+		return nil
+	})
+}
+
+// run calls fn with the pair locked. The pair is closed after an error, or once both
+// directions have been shut down.
+func (that *pair) run(fn func() error) error {
+	if atomic.LoadInt32(&that.closed) == 1 {
+		return nil
+	}
+	that.lock.Lock()
+	err := fn()
+	done := that.toUp.shut && that.toDown.shut
+	that.lock.Unlock()
+	if err != nil || done {
+		that.close()
+	}
+	return nil
+}
+
+// close closes each conn in its mailbox, so it can be called while the pair is locked.
+func (that *pair) close() {
+	if !atomic.CompareAndSwapInt32(&that.closed, 0, 1) {
+		return
+	}
+	that.down.Post(func() error {
+		that.lock.Lock()
+		that.toUp.release()
+		that.toDown.release()
+		that.lock.Unlock()
+		if !that.downRef.Valid() {
+			return nil
+		}
+		return that.down.Close()
+	})
+	that.upRef.Close()
+}
+
+// flow moves the bytes read from src to dst.
+type flow struct {
+	src, dst *conn.Conn
+	pr, pw   int  // the pipe to splice through, -1 when copying
+	piped    int  // bytes in the pipe
+	eof      bool // src has been read to the end
+	shut     bool // the write side of dst has been shut down
+}
+
+// pending reports whether bytes of the flow are waiting for dst.
+func (that *flow) pending() bool {
+	return that.piped > 0 || !that.dst.OutBuffer.IsEmpty()
+}
+
+// pump moves what src has to dst, reading src stops while dst is backed up.
+func (that *flow) pump(highWater int) error {
+	if that.eof {
+		return that.src.PauseRead()
+	}
+	if that.pr < 0 {
+		buf := that.src.GetBufferFromPool()
+		defer that.src.PutBufferToPool(buf)
+		n, err := sys.Read(that.src.Fd, buf)
+		switch {
+		case err == sys.EAGAIN:
+			that.src.Poller.Metrics.ReadAgain()
+			return nil
+		case err != nil:
+			return err
+		case n == 0:
+			return that.readEOF()
+		}
+		that.src.CountRead(n)
+		if _, err = that.dst.Write(buf[:n]); err != nil || !that.dst.Opened {
+			return syscall.EPIPE
+		}
+		if that.dst.OutBuffer.Buffered() >= highWater {
+			return that.src.PauseRead()
+		}
+		return nil
+	}
+	space := pipeSize - that.piped
+	if space <= 0 {
+		return that.src.PauseRead()
+	}
+	n, err := sys.Splice(that.src.Fd, that.pw, space)
+	switch {
+	case err == sys.EAGAIN:
+		that.src.Poller.Metrics.ReadAgain()
+		return nil
+	case err != nil:
+		return err
+	case n == 0:
+		return that.readEOF()
+	}
+	that.src.CountRead(n)
+	that.piped += n
+	return that.drain()
+}
+
+// drain moves the pipe to dst.
+func (that *flow) drain() error {
+	for that.piped > 0 {
+		n, err := sys.Splice(that.pr, that.dst.Fd, that.piped)
+		if err == sys.EAGAIN {
+			that.dst.Poller.Metrics.WriteAgain()
+			if that.piped >= pipeSize {
+				if err = that.src.PauseRead(); err != nil {
+					return err
+				}
+			}
+			return that.dst.WatchWrite(true)
+		}
+		if err != nil {
+			return err
+		}
+		that.piped -= n
+		that.dst.CountWritten(n)
+	}
+	if err := that.dst.WatchWrite(false); err != nil {
+		return err
+	}
+	return that.drained()
+}
+
+func (that *flow) onWritable() error {
+	if that.pr >= 0 {
+		return that.drain()
+	}
+	if err := that.dst.FlushOutput(); err != nil || !that.dst.Opened {
+		return syscall.EPIPE
+	}
+	if that.dst.OutBuffer.IsEmpty() {
+		return that.drained()
+	}
+	return nil
+}
+
+func (that *flow) readEOF() error {
+	that.eof = true
+	if err := that.src.PauseRead(); err != nil {
+		return err
+	}
+	if that.pending() {
+		return nil
+	}
+	return that.drained()
+}
+
+// drained is called once everything read from src has reached dst.
+func (that *flow) drained() error {
+	if !that.eof {
+		return that.src.ResumeRead()
+	}
+	if !that.shut {
+		that.shut = true
+		return syscall.Shutdown(that.dst.Fd, syscall.SHUT_WR)
+	}
+	return nil
+}
+
+func (that *flow) release() {
+	if that.pr >= 0 {
+		sys.CloseFd(that.pr)
+		sys.CloseFd(that.pw)
+		that.pr, that.pw = -1, -1
+	}
+}
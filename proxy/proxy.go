@@ -0,0 +1,168 @@
+/*
+proxy forwards raw TCP streams. Every accepted conn is paired with a conn dialed to the upstream
+on the same event loop, bytes move with splice(2) on Linux and through OutBuffer elsewhere.
+*/
+package proxy
+
+import (
+	"time"
+
+	"github.com/moqsien/gknet/conn"
+	"github.com/moqsien/gknet/eloop"
+	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/sys"
+)
+
+type Options struct {
+	Network       string        // network of the upstream, "tcp" if empty
+	DialTimeout   time.Duration // 0 means 3s
+	HighWater     int           // bytes queued for a peer before reading the other side stops, 0 means 1MB
+	DisableSplice bool          // copy through OutBuffer even where splice(2) is available
+}
+
+// Proxy is an iface.IEventHandler, serve it with an engine to proxy the accepted conns.
+// Conns must be plaintext, TLS on the engine is bypassed.
+type Proxy struct {
+	upstream string
+	options  Options
+}
+
+func New(upstream string, opts ...*Options) *Proxy {
+	p := &Proxy{upstream: upstream}
+	if len(opts) > 0 && opts[0] != nil {
+		p.options = *opts[0]
+	}
+	if p.options.Network == "" {
+		p.options.Network = "tcp"
+	}
+	if p.options.DialTimeout <= 0 {
+		p.options.DialTimeout = 3 * time.Second
+	}
+	if p.options.HighWater <= 0 {
+		p.options.HighWater = 1 << 20
+	}
+	return p
+}
+
+func (that *Proxy) OnAccept(c iface.RawConn) error {
+	return nil
+}
+
+// OnOpen dials the upstream, the accepted conn is not read until it is paired with it.
+func (that *Proxy) OnOpen(c *iface.Context) ([]byte, error) {
+	down := c.RawConn.(*conn.Conn)
+	loop, ok := down.Poller.Eloop.(*eloop.Eloop)
+	if !ok {
+		return nil, down.Post(down.Close)
+	}
+	if err := down.PauseRead(); err != nil {
+		return nil, down.Post(down.Close)
+	}
+	ref := down.Ref()
+	err := loop.Dial(that.options.Network, that.upstream, peerHandler{}, that.options.DialTimeout, func(up *conn.Conn, err error) {
+		that.pair(ref, up, err)
+	})
+	if err != nil {
+		return nil, down.Post(down.Close)
+	}
+	return nil, nil
+}
+
+// pair hooks the accepted conn and the upstream conn to each other, it runs in the
+// handlers of up. Each conn is hooked in its own mailbox, and read once both are.
+func (that *Proxy) pair(ref conn.Ref, up *conn.Conn, err error) {
+	if err != nil {
+		ref.Close()
+		return
+	}
+	down := ref.Conn()
+	if down == nil {
+		// the client left during the dial.
+		up.Close()
+		return
+	}
+	if err = up.PauseRead(); err != nil {
+		up.Close()
+		ref.Close()
+		return
+	}
+	p := &pair{
+		down:      down,
+		up:        up,
+		downRef:   ref,
+		upRef:     up.Ref(),
+		toUp:      newFlow(down, up, !that.options.DisableSplice),
+		toDown:    newFlow(up, down, !that.options.DisableSplice),
+		highWater: that.options.HighWater,
+	}
+	up.Hook = &side{p: p, in: p.toDown, out: p.toUp}
+	err = down.Post(func() error {
+		if !ref.Valid() {
+			p.close()
+			return nil
+		}
+		down.Hook = &side{p: p, in: p.toUp, out: p.toDown}
+		up.Post(func() error {
+			if !p.upRef.Valid() {
+				return nil
+			}
+			return up.ResumeRead()
+		})
+		return down.ResumeRead()
+	})
+	if err != nil {
+		p.close()
+	}
+}
+
+// OnTrack is not called, the hooks read the conns.
+func (that *Proxy) OnTrack(c *iface.Context) error {
+	return nil
+}
+
+func (that *Proxy) OnClose(c *iface.Context) error {
+	closePair(c)
+	return nil
+}
+
+// peerHandler handles the events of upstream conns.
+type peerHandler struct{}
+
+func (that peerHandler) OnAccept(c iface.RawConn) error {
+	return nil
+}
+
+func (that peerHandler) OnOpen(c *iface.Context) ([]byte, error) {
+	return nil, nil
+}
+
+func (that peerHandler) OnTrack(c *iface.Context) error {
+	return nil
+}
+
+func (that peerHandler) OnClose(c *iface.Context) error {
+	closePair(c)
+	return nil
+}
+
+func closePair(c *iface.Context) {
+	if c == nil {
+		return
+	}
+	if raw, ok := c.RawConn.(*conn.Conn); ok {
+		if s, ok := raw.Hook.(*side); ok {
+			s.p.close()
+		}
+	}
+}
+
+// newFlow returns a flow from src to dst, with a pipe if splice is wanted and supported.
+func newFlow(src, dst *conn.Conn, splice bool) *flow {
+	f := &flow{src: src, dst: dst, pr: -1, pw: -1}
+	if splice {
+		if r, w, err := sys.Pipe(); err == nil {
+			f.pr, f.pw = r, w
+		}
+	}
+	return f
+}
@@ -0,0 +1,287 @@
+/*
+proxyproto parses the PROXY protocol v1(text) and v2(binary) headers sent by load balancers
+such as HAProxy or AWS NLB in front of a server, see https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt.
+*/
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoHeader   = errors.New("[ProxyProto] no header")
+	ErrIncomplete = errors.New("[ProxyProto] incomplete header")
+	ErrInvalid    = errors.New("[ProxyProto] invalid header")
+)
+
+// types of v2 TLVs.
+const (
+	TLVTypeALPN      byte = 0x01
+	TLVTypeAuthority byte = 0x02
+	TLVTypeCRC32C    byte = 0x03
+	TLVTypeNoop      byte = 0x04
+	TLVTypeUniqueID  byte = 0x05
+	TLVTypeSSL       byte = 0x20
+	TLVTypeNetNS     byte = 0x30
+)
+
+// sub types of the TLVs in a PP2_TYPE_SSL TLV.
+const (
+	SSLSubTypeVersion byte = 0x21
+	SSLSubTypeCN      byte = 0x22
+	SSLSubTypeCipher  byte = 0x23
+	SSLSubTypeSigAlg  byte = 0x24
+	SSLSubTypeKeyAlg  byte = 0x25
+)
+
+// client flags of SSLInfo.
+const (
+	SSLClientSSL      byte = 0x01
+	SSLClientCertConn byte = 0x02
+	SSLClientCertSess byte = 0x04
+)
+
+const (
+	v1MaxLength = 107
+	v2HeadLen   = 16
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Header is a PROXY protocol header.
+type Header struct {
+	Version     int  // 1 or 2
+	Local       bool // a v2 LOCAL command or a v1 UNKNOWN header, the addresses of the conn stay
+	Source      net.Addr
+	Destination net.Addr
+	TLVs        []TLV // v2 only
+}
+
+// TLV returns the value of the first TLV of type t.
+func (that *Header) TLV(t byte) ([]byte, bool) {
+	return findTLV(that.TLVs, t)
+}
+
+func findTLV(tlvs []TLV, t byte) ([]byte, bool) {
+	for _, tlv := range tlvs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ALPN returns the application protocol negotiated by the load balancer.
+func (that *Header) ALPN() string {
+	v, _ := that.TLV(TLVTypeALPN)
+	return string(v)
+}
+
+// Authority returns the host name sent by the client(SNI).
+func (that *Header) Authority() string {
+	v, _ := that.TLV(TLVTypeAuthority)
+	return string(v)
+}
+
+// SSLInfo is the content of a PP2_TYPE_SSL TLV.
+type SSLInfo struct {
+	Client  byte   // SSLClient flags
+	Verify  uint32 // 0 if the client certificate was verified
+	Version string
+	CN      string
+	Cipher  string
+	SigAlg  string
+	KeyAlg  string
+	TLVs    []TLV
+}
+
+// SSL returns the TLS information of a conn terminated by the load balancer.
+func (that *Header) SSL() (*SSLInfo, bool) {
+	v, ok := that.TLV(TLVTypeSSL)
+	if !ok || len(v) < 5 {
+		return nil, false
+	}
+	info := &SSLInfo{Client: v[0], Verify: binary.BigEndian.Uint32(v[1:5])}
+	tlvs, err := parseTLVs(v[5:])
+	if err != nil {
+		return nil, false
+	}
+	info.TLVs = tlvs
+	for _, tlv := range tlvs {
+		switch tlv.Type {
+		case SSLSubTypeVersion:
+			info.Version = string(tlv.Value)
+		case SSLSubTypeCN:
+			info.CN = string(tlv.Value)
+		case SSLSubTypeCipher:
+			info.Cipher = string(tlv.Value)
+		case SSLSubTypeSigAlg:
+			info.SigAlg = string(tlv.Value)
+		case SSLSubTypeKeyAlg:
+			info.KeyAlg = string(tlv.Value)
+		}
+	}
+	return info, true
+}
+
+// Parse parses the header at the start of b and returns it with its length. ErrIncomplete
+// means b is a prefix of a header, ErrNoHeader that b does not start with one.
+func Parse(b []byte) (*Header, int, error) {
+	switch {
+	case hasPrefix(b, v2Signature):
+		if len(b) < len(v2Signature) {
+			return nil, 0, ErrIncomplete
+		}
+		return parseV2(b)
+	case hasPrefix(b, v1Prefix):
+		if len(b) < len(v1Prefix) {
+			return nil, 0, ErrIncomplete
+		}
+		return parseV1(b)
+	}
+	return nil, 0, ErrNoHeader
+}
+
+// hasPrefix reports whether b and prefix agree on their common length.
+func hasPrefix(b, prefix []byte) bool {
+	n := len(b)
+	if n > len(prefix) {
+		n = len(prefix)
+	}
+	return n > 0 && bytes.Equal(b[:n], prefix[:n])
+}
+
+func parseV1(b []byte) (*Header, int, error) {
+	end := bytes.Index(b, []byte("\r\n"))
+	if end < 0 {
+		if len(b) >= v1MaxLength {
+			return nil, 0, ErrInvalid
+		}
+		return nil, 0, ErrIncomplete
+	}
+	if end+2 > v1MaxLength {
+		return nil, 0, ErrInvalid
+	}
+	fields := strings.Split(string(b[len(v1Prefix):end]), " ")
+	h := &Header{Version: 1}
+	switch fields[0] {
+	case "UNKNOWN":
+		h.Local = true
+		return h, end + 2, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, 0, ErrInvalid
+	}
+	if len(fields) != 5 {
+		return nil, 0, ErrInvalid
+	}
+	src, dst := net.ParseIP(fields[1]), net.ParseIP(fields[2])
+	sport, err1 := strconv.ParseUint(fields[3], 10, 16)
+	dport, err2 := strconv.ParseUint(fields[4], 10, 16)
+	if src == nil || dst == nil || err1 != nil || err2 != nil ||
+		(fields[0] == "TCP4") != (src.To4() != nil) || (fields[0] == "TCP4") != (dst.To4() != nil) {
+		return nil, 0, ErrInvalid
+	}
+	h.Source = &net.TCPAddr{IP: src, Port: int(sport)}
+	h.Destination = &net.TCPAddr{IP: dst, Port: int(dport)}
+	return h, end + 2, nil
+}
+
+func parseV2(b []byte) (*Header, int, error) {
+	if len(b) < v2HeadLen {
+		return nil, 0, ErrIncomplete
+	}
+	verCmd, fam := b[12], b[13]
+	if verCmd>>4 != 2 {
+		return nil, 0, ErrInvalid
+	}
+	length := v2HeadLen + int(binary.BigEndian.Uint16(b[14:16]))
+	if len(b) < length {
+		return nil, 0, ErrIncomplete
+	}
+	h := &Header{Version: 2}
+	switch verCmd & 0xf {
+	case 0:
+		h.Local = true
+	case 1:
+	default:
+		return nil, 0, ErrInvalid
+	}
+	body := b[v2HeadLen:length]
+	var n int
+	switch fam >> 4 {
+	case 0: // AF_UNSPEC, the block is discarded
+		h.Local = true
+		n = len(body)
+	case 1, 2: // AF_INET, AF_INET6
+		size := net.IPv4len
+		if fam>>4 == 2 {
+			size = net.IPv6len
+		}
+		if n = 2*size + 4; len(body) < n {
+			return nil, 0, ErrInvalid
+		}
+		src := net.IP(append([]byte(nil), body[:size]...))
+		dst := net.IP(append([]byte(nil), body[size:2*size]...))
+		sport := int(binary.BigEndian.Uint16(body[2*size:]))
+		dport := int(binary.BigEndian.Uint16(body[2*size+2:]))
+		if fam&0xf == 2 {
+			h.Source, h.Destination = &net.UDPAddr{IP: src, Port: sport}, &net.UDPAddr{IP: dst, Port: dport}
+		} else {
+			h.Source, h.Destination = &net.TCPAddr{IP: src, Port: sport}, &net.TCPAddr{IP: dst, Port: dport}
+		}
+	case 3: // AF_UNIX
+		if n = 216; len(body) < n {
+			return nil, 0, ErrInvalid
+		}
+		network := "unix"
+		if fam&0xf == 2 {
+			network = "unixgram"
+		}
+		h.Source = &net.UnixAddr{Name: cString(body[:108]), Net: network}
+		h.Destination = &net.UnixAddr{Name: cString(body[108:216]), Net: network}
+	default:
+		return nil, 0, ErrInvalid
+	}
+	tlvs, err := parseTLVs(body[n:])
+	if err != nil {
+		return nil, 0, err
+	}
+	h.TLVs = tlvs
+	return h, length, nil
+}
+
+func parseTLVs(b []byte) (tlvs []TLV, err error) {
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, ErrInvalid
+		}
+		n := 3 + int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < n {
+			return nil, ErrInvalid
+		}
+		tlvs = append(tlvs, TLV{Type: b[0], Value: append([]byte(nil), b[3:n]...)})
+		b = b[n:]
+	}
+	return
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
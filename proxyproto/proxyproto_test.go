@@ -0,0 +1,128 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// v2Header returns a v2 header with the body.
+func v2Header(verCmd, fam byte, body ...[]byte) []byte {
+	b := bytes.Join(body, nil)
+	h := append(append([]byte(nil), v2Signature...), verCmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(h[14:], uint16(len(b)))
+	return append(h, b...)
+}
+
+func tlv(t byte, v string) []byte {
+	return append([]byte{t, byte(len(v) >> 8), byte(len(v))}, v...)
+}
+
+var (
+	inet4 = []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x1f, 0x90, 0x01, 0xbb}
+	inet6 = append(append(make([]byte, 15), 1), append(make([]byte, 15), 2, 0x1f, 0x90, 0x01, 0xbb)...)
+	unix  = append(append([]byte("/src.sock"), make([]byte, 99)...), append([]byte("/dst.sock"), make([]byte, 99)...)...)
+)
+
+func TestParse(t *testing.T) {
+	v1 := []byte("PROXY TCP4 10.0.0.1 10.0.0.2 8080 443\r\nGET")
+	v2 := v2Header(0x21, 0x11, inet4, tlv(TLVTypeALPN, "h2"))
+	cases := []struct {
+		name  string
+		in    []byte
+		err   error
+		n     int
+		src   string
+		dst   string
+		local bool
+		tlvs  int
+	}{
+		{"v1 tcp4", v1, nil, len(v1) - 3, "10.0.0.1:8080", "10.0.0.2:443", false, 0},
+		{"v1 tcp6", []byte("PROXY TCP6 ::1 ::2 8080 443\r\n"), nil, 29, "[::1]:8080", "[::2]:443", false, 0},
+		{"v1 unknown", []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"), nil, 35, "", "", true, 0},
+		{"v1 truncated", v1[:20], ErrIncomplete, 0, "", "", false, 0},
+		{"v1 prefix", []byte("PROX"), ErrIncomplete, 0, "", "", false, 0},
+		{"v1 too long", append([]byte("PROXY TCP4 "), bytes.Repeat([]byte("1"), 100)...), ErrInvalid, 0, "", "", false, 0},
+		{"v1 line too long", append(append([]byte("PROXY UNKNOWN "), bytes.Repeat([]byte("1"), 100)...), "\r\n"...), ErrInvalid, 0, "", "", false, 0},
+		{"v1 bad protocol", []byte("PROXY UDP4 10.0.0.1 10.0.0.2 1 2\r\n"), ErrInvalid, 0, "", "", false, 0},
+		{"v1 missing port", []byte("PROXY TCP4 10.0.0.1 10.0.0.2 1\r\n"), ErrInvalid, 0, "", "", false, 0},
+		{"v1 bad port", []byte("PROXY TCP4 10.0.0.1 10.0.0.2 1 65536\r\n"), ErrInvalid, 0, "", "", false, 0},
+		{"v1 family mismatch", []byte("PROXY TCP4 ::1 10.0.0.2 1 2\r\n"), ErrInvalid, 0, "", "", false, 0},
+		{"v2 inet", v2, nil, len(v2), "10.0.0.1:8080", "10.0.0.2:443", false, 1},
+		{"v2 inet6", v2Header(0x21, 0x21, inet6), nil, 52, "[::1]:8080", "[::2]:443", false, 0},
+		{"v2 udp", v2Header(0x21, 0x12, inet4), nil, 28, "10.0.0.1:8080", "10.0.0.2:443", false, 0},
+		{"v2 unix", v2Header(0x21, 0x31, unix), nil, 232, "/src.sock", "/dst.sock", false, 0},
+		{"v2 local", v2Header(0x20, 0x00), nil, 16, "", "", true, 0},
+		{"v2 unspec", v2Header(0x21, 0x00, []byte{1, 2, 3}), nil, 19, "", "", true, 0},
+		{"v2 signature", v2[:8], ErrIncomplete, 0, "", "", false, 0},
+		{"v2 head truncated", v2[:14], ErrIncomplete, 0, "", "", false, 0},
+		{"v2 body truncated", v2[:len(v2)-1], ErrIncomplete, 0, "", "", false, 0},
+		{"v2 version", v2Header(0x11, 0x11, inet4), ErrInvalid, 0, "", "", false, 0},
+		{"v2 command", v2Header(0x22, 0x11, inet4), ErrInvalid, 0, "", "", false, 0},
+		{"v2 family", v2Header(0x21, 0x41, inet4), ErrInvalid, 0, "", "", false, 0},
+		{"v2 short addresses", v2Header(0x21, 0x21, inet4), ErrInvalid, 0, "", "", false, 0},
+		{"v2 short tlv", v2Header(0x21, 0x11, inet4, []byte{TLVTypeALPN, 0}), ErrInvalid, 0, "", "", false, 0},
+		{"v2 tlv overflow", v2Header(0x21, 0x11, inet4, []byte{TLVTypeALPN, 0, 5, 'h', '2'}), ErrInvalid, 0, "", "", false, 0},
+		{"no header", []byte("GET / HTTP/1.1\r\n"), ErrNoHeader, 0, "", "", false, 0},
+		{"empty", nil, ErrNoHeader, 0, "", "", false, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h, n, err := Parse(c.in)
+			if err != c.err {
+				t.Fatalf("got error %v, want %v", err, c.err)
+			}
+			if err != nil {
+				return
+			}
+			if n != c.n || h.Local != c.local || len(h.TLVs) != c.tlvs {
+				t.Fatalf("got length %d local %v with %d TLVs", n, h.Local, len(h.TLVs))
+			}
+			var src, dst string
+			if h.Source != nil {
+				src, dst = h.Source.String(), h.Destination.String()
+			}
+			if src != c.src || dst != c.dst {
+				t.Fatalf("got %s -> %s, want %s -> %s", src, dst, c.src, c.dst)
+			}
+		})
+	}
+}
+
+func TestHeaderTLVs(t *testing.T) {
+	ssl := append([]byte{SSLClientSSL | SSLClientCertConn, 0, 0, 0, 0},
+		bytes.Join([][]byte{tlv(SSLSubTypeVersion, "TLSv1.3"), tlv(SSLSubTypeCN, "client")}, nil)...)
+	cases := []struct {
+		name      string
+		tlvs      [][]byte
+		alpn      string
+		authority string
+		ssl       bool
+		version   string
+		cn        string
+	}{
+		{"none", nil, "", "", false, "", ""},
+		{"alpn and authority", [][]byte{tlv(TLVTypeALPN, "h2"), tlv(TLVTypeAuthority, "example.com")}, "h2", "example.com", false, "", ""},
+		{"ssl", [][]byte{tlv(TLVTypeSSL, string(ssl))}, "", "", true, "TLSv1.3", "client"},
+		{"ssl too short", [][]byte{tlv(TLVTypeSSL, "\x01\x00")}, "", "", false, "", ""},
+		{"ssl bad sub tlv", [][]byte{tlv(TLVTypeSSL, "\x01\x00\x00\x00\x00\x21\x00\x09TLS")}, "", "", false, "", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h, _, err := Parse(v2Header(0x21, 0x11, inet4, bytes.Join(c.tlvs, nil)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.ALPN() != c.alpn || h.Authority() != c.authority {
+				t.Fatalf("got ALPN %q authority %q", h.ALPN(), h.Authority())
+			}
+			info, ok := h.SSL()
+			if ok != c.ssl {
+				t.Fatalf("SSL() ok %v, want %v", ok, c.ssl)
+			}
+			if ok && (info.Version != c.version || info.CN != c.cn || info.Client&SSLClientCertConn == 0) {
+				t.Fatalf("got %+v", info)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package socket
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"github.com/moqsien/gknet/sys"
+	"github.com/moqsien/gknet/utils"
+)
+
+// ParseAddr turns address into a *net.TCPAddr or *net.UnixAddr without a DNS lookup,
+// literal is false when the host is a name, which LookupAddr resolves.
+func ParseAddr(network, address string) (addr net.Addr, literal bool, err error) {
+	switch network {
+	case "unix":
+		return &net.UnixAddr{Name: address, Net: network}, true, nil
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, false, net.UnknownNetworkError(network)
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, false, err
+	}
+	if host != "" {
+		if _, err = netip.ParseAddr(host); err != nil {
+			return nil, false, nil
+		}
+	}
+	a, err := net.ResolveTCPAddr(network, address)
+	if err != nil {
+		return nil, false, err
+	}
+	if a.IP == nil {
+		// no host dials the local system, like net.Dial.
+		a.IP = net.IPv4(127, 0, 0, 1)
+		if network == "tcp6" {
+			a.IP = net.IPv6loopback
+		}
+	}
+	return a, true, nil
+}
+
+// LookupAddr resolves the host name of address, it blocks until ctx is done.
+func LookupAddr(ctx context.Context, network, address string) (net.Addr, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		v4 := ip.IP.To4() != nil
+		if (network == "tcp4" && !v4) || (network == "tcp6" && v4) {
+			continue
+		}
+		addr, _, err := ParseAddr(network, net.JoinHostPort(ip.String(), port))
+		return addr, err
+	}
+	return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+}
+
+// Connect starts connecting a nonblocking socket to addr, which ParseAddr or LookupAddr
+// returned. The fd is writable once the connection is done, see sys.Connected.
+func Connect(addr net.Addr) (fd int, err error) {
+	var (
+		family int
+		sa     syscall.Sockaddr
+	)
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		if ip4 := a.IP.To4(); ip4 != nil {
+			sa4 := &syscall.SockaddrInet4{Port: a.Port}
+			copy(sa4.Addr[:], ip4)
+			family, sa = syscall.AF_INET, sa4
+		} else {
+			sa6 := &syscall.SockaddrInet6{Port: a.Port}
+			copy(sa6.Addr[:], a.IP.To16())
+			if a.Zone != "" {
+				if ifi, err := net.InterfaceByName(a.Zone); err == nil {
+					sa6.ZoneId = uint32(ifi.Index)
+				}
+			}
+			family, sa = syscall.AF_INET6, sa6
+		}
+	case *net.UnixAddr:
+		family, sa = syscall.AF_UNIX, &syscall.SockaddrUnix{Name: a.Name}
+	default:
+		return -1, syscall.EAFNOSUPPORT
+	}
+	if fd, err = sys.Socket(family, syscall.SOCK_STREAM, 0); err != nil {
+		return -1, err
+	}
+	if err = sys.Connect(fd, sa); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// LocalAddr returns the address fd is bound to.
+func LocalAddr(fd int) (net.Addr, error) {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return nil, utils.SysError("getsockname", err)
+	}
+	return SockaddrToTCPOrUnixAddr(sa), nil
+}
@@ -0,0 +1,93 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/moqsien/gknet/iface"
+	"github.com/moqsien/gknet/sys"
+	"github.com/moqsien/gknet/utils/errs"
+)
+
+// ListenConfig sets the options of a listener before it is bound, zero values keep the
+// defaults of the system. Options the platform lacks(TCP_DEFER_ACCEPT, IP_FREEBIND and
+// IP_TRANSPARENT on macOS) are skipped, the TCP ones are skipped for UDP too.
+type ListenConfig struct {
+	FastOpen    int  // TCP_FASTOPEN queue length
+	DeferAccept int  // TCP_DEFER_ACCEPT in seconds, the loop is woken once data has arrived
+	Backlog     int  // of listen(2), net.Listen takes somaxconn
+	V6Only      bool // IPV6_V6ONLY on every IPv6 socket, "tcp" on "[::]:80" then takes no IPv4 conns
+	ReusePort   bool
+	FreeBind    bool
+	Transparent bool
+}
+
+func (that *ListenConfig) Listen(network, address string) (gl iface.IListener, err error) {
+	lc := net.ListenConfig{Control: that.control}
+	var file *os.File
+	if strings.Contains(network, "udp") {
+		var l net.PacketConn
+		if l, err = lc.ListenPacket(context.Background(), network, address); err != nil {
+			return nil, err
+		}
+		if file, err = ResolveFile(l); err != nil {
+			return nil, err
+		}
+		return &GkListener{fd: -1, file: file, addr: l.LocalAddr(), isUDP: true}, nil
+	}
+	var l net.Listener
+	if l, err = lc.Listen(context.Background(), network, address); err != nil {
+		return nil, err
+	}
+	if file, err = ResolveFile(l); err != nil {
+		return nil, err
+	}
+	if that.Backlog > 0 {
+		if err = sys.SetListenBacklog(int(file.Fd()), that.Backlog); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &GkListener{fd: -1, file: file, addr: l.Addr()}, nil
+}
+
+func (that *ListenConfig) control(network, _ string, rc syscall.RawConn) error {
+	var err error
+	if cerr := rc.Control(func(s uintptr) { err = that.apply(network, int(s)) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+func (that *ListenConfig) apply(network string, fd int) error {
+	tcp := strings.HasPrefix(network, "tcp")
+	var setters []func() error
+	if that.ReusePort {
+		setters = append(setters, func() error { return sys.SetReusePort(fd) })
+	}
+	if that.V6Only {
+		setters = append(setters, func() error { return sys.SetV6Only(fd, true) })
+	}
+	if that.FreeBind {
+		setters = append(setters, func() error { return sys.SetFreeBind(fd, true) })
+	}
+	if that.Transparent {
+		setters = append(setters, func() error { return sys.SetTransparent(fd, true) })
+	}
+	if tcp && that.FastOpen > 0 {
+		setters = append(setters, func() error { return sys.SetFastOpen(fd, that.FastOpen) })
+	}
+	if tcp && that.DeferAccept > 0 {
+		setters = append(setters, func() error { return sys.SetDeferAccept(fd, that.DeferAccept) })
+	}
+	for _, set := range setters {
+		if err := set(); err != nil && !errors.Is(err, errs.ErrUnsupportedSockOpt) {
+			return err
+		}
+	}
+	return nil
+}
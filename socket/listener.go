@@ -4,7 +4,6 @@ import (
 	"errors"
 	"net"
 	"os"
-	"strings"
 
 	"github.com/moqsien/gknet/iface"
 )
@@ -66,46 +65,7 @@ func ResolveFile(ln interface{}) (file *os.File, err error) {
 }
 
 func Listen(network, address string) (gl iface.IListener, err error) {
-	if strings.Contains(network, "udp") {
-		var addr *net.UDPAddr
-		addr, err = net.ResolveUDPAddr(network, address)
-		if err != nil {
-			return nil, err
-		}
-		var l *net.UDPConn
-		l, err = net.ListenUDP(network, addr)
-		if err != nil {
-			return nil, err
-		}
-		var file *os.File
-		file, err = ResolveFile(l)
-		if err != nil {
-			return nil, err
-		}
-		gl = &GkListener{
-			fd:    -1,
-			file:  file,
-			addr:  l.LocalAddr(),
-			isUDP: true,
-		}
-		return
-	}
-	var l net.Listener
-	l, err = net.Listen(network, address)
-	if err != nil {
-		return nil, err
-	}
-	var file *os.File
-	file, err = ResolveFile(l)
-	if err != nil {
-		return nil, err
-	}
-	gl = &GkListener{
-		fd:   -1,
-		file: file,
-		addr: l.Addr(),
-	}
-	return
+	return (&ListenConfig{}).Listen(network, address)
 }
 
 func AdaptListener(l net.Listener) (gl iface.IListener, err error) {
@@ -134,3 +94,18 @@ func AdaptUDPConn(c *net.UDPConn) (gl iface.IListener, err error) {
 	}
 	return
 }
+
+type dispatchListener struct {
+	iface.IListener
+	mode iface.DispatchMode
+}
+
+func (that *dispatchListener) DispatchMode() iface.DispatchMode {
+	return that.mode
+}
+
+// WithDispatchMode returns ln with a DispatchMode of its own, the engine serving it runs the
+// handlers of its conns in mode whatever Options.DispatchMode is.
+func WithDispatchMode(ln iface.IListener, mode iface.DispatchMode) iface.IListener {
+	return &dispatchListener{IListener: ln, mode: mode}
+}
@@ -28,6 +28,9 @@ type WaitCallback func(fd int, events uint32, trigger bool, wg *sync.WaitGroup)
 
 type DoError func(err error) error
 
+// WakeCallback is called each time the poll wait returns n events.
+type WakeCallback func(n int)
+
 const (
 	MaxPollSize         = 1024
 	MinPollSize         = 32
@@ -83,6 +86,16 @@ func SetSendBufferSize(fd, size int) error {
 	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, size))
 }
 
+// SetLinger sets SO_LINGER, closing the fd with 0 secs sends a RST instead of a FIN.
+// Negative secs turn it off.
+func SetLinger(fd, secs int) error {
+	l := &syscall.Linger{Onoff: 1, Linger: int32(secs)}
+	if secs < 0 {
+		l.Onoff, l.Linger = 0, 0
+	}
+	return utils.SysError("setsockopt", syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, l))
+}
+
 func HandleEvents(events uint32, handler EventHandler) (err error) {
 	if events&ClosedFdEvents != 0 { // only for darwin.
 		err = handler.Close()
@@ -96,7 +109,8 @@ func HandleEvents(events uint32, handler EventHandler) (err error) {
 		}
 	}
 
-	if events&InEvents != 0 {
+	// a half-close is read as the end of the input.
+	if events&(InEvents|RdHupEvents) != 0 {
 		err = handler.ReadFromFd()
 		if err != nil {
 			return
@@ -14,6 +14,13 @@ const (
 	SO_REUSEPORT  = 0x200
 )
 
+const (
+	TCP_CORK          = syscall.TCP_NOPUSH
+	TCP_KEEPCNT       = 0x102
+	TCP_NOTSENT_LOWAT = 0x201
+	TCP_FASTOPEN      = 0x105
+)
+
 const (
 	EVFilterClosed = -0xd
 	EVFilterWrite  = syscall.EVFILT_WRITE
@@ -24,6 +31,7 @@ const (
 	InEvents       uint32 = 0x2
 	OutEvents      uint32 = 0x4
 	ClosedFdEvents uint32 = 0x8
+	RdHupEvents    uint32 = 0x10 // EV_EOF of the read filter, the rest can be read
 	InAndOutEvents uint32 = InEvents | OutEvents
 	NoneEvents     uint32 = 0
 )
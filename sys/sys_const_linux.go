@@ -14,9 +14,23 @@ const (
 )
 
 const (
-	ErrEvents      = syscall.EPOLLERR | syscall.EPOLLHUP | syscall.EPOLLRDHUP
+	TCP_CORK          = syscall.TCP_CORK
+	TCP_KEEPCNT       = syscall.TCP_KEEPCNT
+	TCP_QUICKACK      = syscall.TCP_QUICKACK
+	TCP_USER_TIMEOUT  = 0x12
+	TCP_NOTSENT_LOWAT = 0x19
+	TCP_FASTOPEN      = 0x17
+	TCP_DEFER_ACCEPT  = syscall.TCP_DEFER_ACCEPT
+	IP_FREEBIND       = syscall.IP_FREEBIND
+	IP_TRANSPARENT    = syscall.IP_TRANSPARENT
+	IPV6_TRANSPARENT  = 0x4b
+)
+
+const (
+	ErrEvents      = syscall.EPOLLERR | syscall.EPOLLHUP
+	RdHupEvents    = syscall.EPOLLRDHUP // the peer shut down its write side, the rest can be read
 	OutEvents      = ErrEvents | syscall.EPOLLOUT
-	InEvents       = ErrEvents | syscall.EPOLLIN | syscall.EPOLLPRI
+	InEvents       = ErrEvents | RdHupEvents | syscall.EPOLLIN | syscall.EPOLLPRI
 	ClosedFdEvents = 0
 )
 
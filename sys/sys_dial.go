@@ -0,0 +1,37 @@
+package sys
+
+import (
+	"syscall"
+
+	"github.com/moqsien/gknet/utils"
+)
+
+// Connect starts connecting the nonblocking fd to sa, a connection in progress is no
+// error, Connected tells when it is done.
+func Connect(fd int, sa syscall.Sockaddr) error {
+	switch err := syscall.Connect(fd, sa); err {
+	case nil, syscall.EINPROGRESS, syscall.EINTR:
+		return nil
+	default:
+		return utils.SysError("connect", err)
+	}
+}
+
+// Connected reports whether the connection started by Connect is established, it returns
+// the error of a failed connection.
+func Connected(fd int) (bool, error) {
+	errno, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err != nil {
+		return false, utils.SysError("getsockopt", err)
+	}
+	if errno != 0 {
+		return false, utils.SysError("connect", syscall.Errno(errno))
+	}
+	if _, err = syscall.Getpeername(fd); err != nil {
+		if err == syscall.ENOTCONN {
+			return false, nil
+		}
+		return false, utils.SysError("getpeername", err)
+	}
+	return true, nil
+}
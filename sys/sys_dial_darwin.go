@@ -0,0 +1,27 @@
+//go:build darwin
+
+package sys
+
+import (
+	"syscall"
+
+	"github.com/moqsien/gknet/utils"
+)
+
+// Socket creates a nonblocking close-on-exec socket.
+func Socket(family, sotype, proto int) (int, error) {
+	syscall.ForkLock.RLock()
+	fd, err := syscall.Socket(family, sotype, proto)
+	if err == nil {
+		syscall.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return -1, utils.SysError("socket", err)
+	}
+	if err = syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return -1, utils.SysError("setnonblock", err)
+	}
+	return fd, nil
+}
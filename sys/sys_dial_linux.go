@@ -0,0 +1,18 @@
+//go:build linux
+
+package sys
+
+import (
+	"syscall"
+
+	"github.com/moqsien/gknet/utils"
+)
+
+// Socket creates a nonblocking close-on-exec socket.
+func Socket(family, sotype, proto int) (int, error) {
+	fd, err := syscall.Socket(family, sotype|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return -1, utils.SysError("socket", err)
+	}
+	return fd, nil
+}
@@ -9,8 +9,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/moqsien/processes/logger"
-
 	"github.com/moqsien/gknet/utils"
 )
 
@@ -83,6 +81,7 @@ func ModRead(pollFd, fd int) (err error) {
 	if !ok {
 		return utils.SysError(kSysMod, errors.New("fd not added!"))
 	}
+	kFilters.Store(fd, InEvents)
 	kevents := getKevents(oldEvents.(uint32), InEvents, fd)
 	_, err = syscall.Kevent(pollFd, kevents, nil, nil)
 	return utils.SysError(kSysDel, err)
@@ -93,6 +92,7 @@ func ModWrite(pollFd, fd int) (err error) {
 	if !ok {
 		return utils.SysError(kSysMod, errors.New("fd not added!"))
 	}
+	kFilters.Store(fd, OutEvents)
 	kevents := getKevents(oldEvents.(uint32), OutEvents, fd)
 	_, err = syscall.Kevent(pollFd, kevents, nil, nil)
 	return utils.SysError(kSysMod, err)
@@ -103,11 +103,27 @@ func ModReadWrite(pollFd, fd int) (err error) {
 	if !ok {
 		return utils.SysError(kSysMod, errors.New("fd not added!"))
 	}
+	kFilters.Store(fd, InAndOutEvents)
 	kevents := getKevents(oldEvents.(uint32), InAndOutEvents, fd)
 	_, err = syscall.Kevent(pollFd, kevents, nil, nil)
 	return utils.SysError(kSysMod, err)
 }
 
+// ModNone keeps fd registered without waiting for any event.
+func ModNone(pollFd, fd int) (err error) {
+	oldEvents, ok := kFilters.Load(fd)
+	if !ok {
+		return utils.SysError(kSysMod, errors.New("fd not added!"))
+	}
+	kFilters.Store(fd, NoneEvents)
+	kevents := getKevents(oldEvents.(uint32), NoneEvents, fd)
+	if len(kevents) == 0 {
+		return nil
+	}
+	_, err = syscall.Kevent(pollFd, kevents, nil, nil)
+	return utils.SysError(kSysMod, err)
+}
+
 func UnRegister(pollFd, fd int) (err error) {
 	oldEvents, ok := kFilters.Load(fd)
 	if !ok {
@@ -133,7 +149,7 @@ func shrink(size int) (newSize int, events []syscall.Kevent_t) {
 	return
 }
 
-func WaitPoll(pollFd, _pollEvFd int, w WaitCallback, doCallbackErr DoError, wg *sync.WaitGroup) error {
+func WaitPoll(pollFd, _pollEvFd int, w WaitCallback, doCallbackErr DoError, wake WakeCallback, wg *sync.WaitGroup) error {
 	size := InitPollSize
 	eventList := make([]syscall.Kevent_t, size)
 	var (
@@ -149,10 +165,12 @@ func WaitPoll(pollFd, _pollEvFd int, w WaitCallback, doCallbackErr DoError, wg *
 			runtime.Gosched()
 			continue
 		} else if err != nil {
-			logger.Errorf("error occurs in kqueue: %v", utils.SysError("kevent_wait", err))
-			return err
+			return utils.SysError("kevent_wait", err)
 		}
 		tsp = &ts
+		if wake != nil {
+			wake(n)
+		}
 
 		var evFilter int16
 		for i := 0; i < n; i++ {
@@ -163,7 +181,13 @@ func WaitPoll(pollFd, _pollEvFd int, w WaitCallback, doCallbackErr DoError, wg *
 			}
 			evFilter = ev.Filter
 			var events uint32
-			if (ev.Flags&syscall.EV_EOF != 0) || (ev.Flags&syscall.EV_ERROR != 0) {
+			switch {
+			case ev.Flags&syscall.EV_ERROR != 0:
+				events |= ClosedFdEvents
+			case ev.Flags&syscall.EV_EOF != 0 && evFilter == syscall.EVFILT_READ:
+				// a half-close, the data before it is still readable.
+				events |= InEvents | RdHupEvents
+			case ev.Flags&syscall.EV_EOF != 0:
 				events |= ClosedFdEvents
 			}
 			if evFilter == syscall.EVFILT_WRITE && ev.Flags&syscall.EV_ENABLE != 0 {
@@ -173,7 +197,8 @@ func WaitPoll(pollFd, _pollEvFd int, w WaitCallback, doCallbackErr DoError, wg *
 				events |= InEvents
 			}
 			if i != n-1 {
-				trigger, err = w(fd, events, false, wg)
+				// tasks run after the last event, the trigger must be kept till then.
+				_, err = w(fd, events, false, wg)
 			} else {
 				trigger, err = w(fd, events, trigger, wg)
 			}
@@ -182,6 +207,8 @@ func WaitPoll(pollFd, _pollEvFd int, w WaitCallback, doCallbackErr DoError, wg *
 				return err
 			}
 		}
+		// conns of one batch are handled in parallel, the next wait reports what is left.
+		wg.Wait()
 
 		if n == size && (size<<1 <= MaxPollSize) {
 			size, eventList = expand(size)
@@ -9,8 +9,6 @@ import (
 	"time"
 	"unsafe"
 
-	"github.com/moqsien/processes/logger"
-
 	"github.com/moqsien/gknet/utils"
 )
 
@@ -27,7 +25,7 @@ func ePut(event *syscall.EpollEvent) {
 }
 
 const (
-	ReadEvents      = syscall.EPOLLPRI | syscall.EPOLLIN
+	ReadEvents      = syscall.EPOLLPRI | syscall.EPOLLIN | syscall.EPOLLRDHUP
 	WriteEvents     = syscall.EPOLLOUT
 	ReadWriteEvents = ReadEvents | WriteEvents
 )
@@ -73,6 +71,11 @@ func ModReadWrite(pollFd, fd int) (err error) {
 	return epollFdHandler(pollFd, fd, syscall.EPOLL_CTL_MOD, ReadWriteEvents)
 }
 
+// ModNone keeps fd registered without waiting for any event, errors are still reported.
+func ModNone(pollFd, fd int) (err error) {
+	return epollFdHandler(pollFd, fd, syscall.EPOLL_CTL_MOD, 0)
+}
+
 func UnRegister(pollFd, fd int) (err error) {
 	return epollFdHandler(pollFd, fd, syscall.EPOLL_CTL_DEL, 0)
 }
@@ -89,7 +92,7 @@ func shrink(size int) (newSize int, events []syscall.EpollEvent) {
 	return
 }
 
-func WaitPoll(pollFd, pollEvFd int, w WaitCallback, doCallbackErr DoError, wg *sync.WaitGroup) error {
+func WaitPoll(pollFd, pollEvFd int, w WaitCallback, doCallbackErr DoError, wake WakeCallback, wg *sync.WaitGroup) error {
 	size := InitPollSize
 	events := make([]syscall.EpollEvent, size)
 	var (
@@ -104,10 +107,12 @@ func WaitPoll(pollFd, pollEvFd int, w WaitCallback, doCallbackErr DoError, wg *s
 			runtime.Gosched()
 			continue
 		} else if err != nil {
-			logger.Errorf("error occurs in epoll: %v", utils.SysError("epoll_wait", err))
-			return err
+			return utils.SysError("epoll_wait", err)
 		}
 		timeout = 0
+		if wake != nil {
+			wake(n)
+		}
 		for i := 0; i < n; i++ {
 			ev := &events[i]
 			fd := int(ev.Fd)
@@ -118,13 +123,16 @@ func WaitPoll(pollFd, pollEvFd int, w WaitCallback, doCallbackErr DoError, wg *s
 			if i == n-1 {
 				trigger, err = w(fd, ev.Events, trigger, wg)
 			} else {
-				trigger, err = w(fd, ev.Events, false, wg)
+				// tasks run after the last event, the trigger must be kept till then.
+				_, err = w(fd, ev.Events, false, wg)
 			}
 			err = doCallbackErr(err)
 			if err != nil {
 				return err
 			}
 		}
+		// conns of one batch are handled in parallel, the next wait reports what is left.
+		wg.Wait()
 
 		if n == size && (size<<1 <= MaxPollSize) {
 			size, events = expand(size)
@@ -178,6 +186,7 @@ func Accept(listenerFd int, timeout ...time.Duration) (int, syscall.Sockaddr, er
 	nfd, sock, err := syscall.Accept4(listenerFd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
 	switch err {
 	case nil:
+		SetKeepAlive(nfd, timeout...)
 		return nfd, sock, err
 	default:
 		return -1, nil, err
@@ -0,0 +1,17 @@
+//go:build amd64 && darwin
+
+package sys
+
+import "syscall"
+
+// Sendfile copies count bytes of inFd from *offset to outFd in the kernel,
+// *offset is advanced by the bytes sent. Darwin may send part of the data and
+// return EAGAIN at the same time.
+func Sendfile(outFd, inFd int, offset *int64, count int) (n int, err error) {
+	n, err = syscall.Sendfile(outFd, inFd, offset, count)
+	if n < 0 {
+		n = 0
+	}
+	*offset += int64(n)
+	return
+}
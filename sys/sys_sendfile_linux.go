@@ -0,0 +1,15 @@
+//go:build linux
+
+package sys
+
+import "syscall"
+
+// Sendfile copies count bytes of inFd from *offset to outFd in the kernel,
+// *offset is advanced by the bytes sent.
+func Sendfile(outFd, inFd int, offset *int64, count int) (n int, err error) {
+	n, err = syscall.Sendfile(outFd, inFd, offset, count)
+	if n < 0 {
+		n = 0
+	}
+	return
+}
@@ -0,0 +1,78 @@
+package sys
+
+import (
+	"syscall"
+
+	"github.com/moqsien/gknet/utils"
+)
+
+func boolint(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// SetNoDelay sets TCP_NODELAY, so small writes are not held back by the Nagle algorithm.
+func SetNoDelay(fd int, on bool) error {
+	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, boolint(on)))
+}
+
+// SetCork sets TCP_CORK(TCP_NOPUSH on macOS), partial frames are held back until it is
+// cleared.
+func SetCork(fd int, on bool) error {
+	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, TCP_CORK, boolint(on)))
+}
+
+// SetKeepAliveCount sets TCP_KEEPCNT, the unanswered probes before the conn is dropped.
+func SetKeepAliveCount(fd, n int) error {
+	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, TCP_KEEPCNT, n))
+}
+
+// SetNotSentLowat sets TCP_NOTSENT_LOWAT, the fd is writable while less than bytes of its
+// output are unsent.
+func SetNotSentLowat(fd, bytes int) error {
+	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, TCP_NOTSENT_LOWAT, bytes))
+}
+
+// isInet6 reports whether fd is an IPv6 socket, it works before bind too.
+func isInet6(fd int) (bool, error) {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return false, utils.SysError("getsockname", err)
+	}
+	_, ok := sa.(*syscall.SockaddrInet6)
+	return ok, nil
+}
+
+// SetTOS sets IP_TOS, or IPV6_TCLASS on IPv6 sockets.
+func SetTOS(fd, tos int) error {
+	v6, err := isInet6(fd)
+	if err != nil {
+		return err
+	}
+	if v6 {
+		if err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos); err != nil {
+			return utils.SysError("setsockopt", err)
+		}
+		// for the IPv4-mapped peers, not every platform takes it.
+		_ = syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		return nil
+	}
+	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TOS, tos))
+}
+
+// SetV6Only sets IPV6_V6ONLY, an IPv6 listener then takes no IPv4 conns. It does nothing
+// on other sockets.
+func SetV6Only(fd int, on bool) error {
+	v6, err := isInet6(fd)
+	if err != nil || !v6 {
+		return err
+	}
+	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, boolint(on)))
+}
+
+// SetListenBacklog changes the backlog of a listening fd.
+func SetListenBacklog(fd, backlog int) error {
+	return utils.SysError("listen", syscall.Listen(fd, backlog))
+}
@@ -0,0 +1,41 @@
+//go:build darwin
+
+package sys
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/moqsien/gknet/utils"
+	"github.com/moqsien/gknet/utils/errs"
+)
+
+// SetUserTimeout returns errs.ErrUnsupportedSockOpt, macOS has no TCP_USER_TIMEOUT.
+func SetUserTimeout(fd int, timeout time.Duration) error {
+	return errs.ErrUnsupportedSockOpt
+}
+
+// SetQuickAck returns errs.ErrUnsupportedSockOpt, macOS has no TCP_QUICKACK.
+func SetQuickAck(fd int, on bool) error {
+	return errs.ErrUnsupportedSockOpt
+}
+
+// SetFastOpen enables TCP_FASTOPEN on a listener fd, macOS has no queue length to set.
+func SetFastOpen(fd, qlen int) error {
+	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, TCP_FASTOPEN, boolint(qlen > 0)))
+}
+
+// SetDeferAccept returns errs.ErrUnsupportedSockOpt, macOS has no TCP_DEFER_ACCEPT.
+func SetDeferAccept(fd, secs int) error {
+	return errs.ErrUnsupportedSockOpt
+}
+
+// SetFreeBind returns errs.ErrUnsupportedSockOpt, macOS has no IP_FREEBIND.
+func SetFreeBind(fd int, on bool) error {
+	return errs.ErrUnsupportedSockOpt
+}
+
+// SetTransparent returns errs.ErrUnsupportedSockOpt, macOS has no IP_TRANSPARENT.
+func SetTransparent(fd int, on bool) error {
+	return errs.ErrUnsupportedSockOpt
+}
@@ -0,0 +1,51 @@
+//go:build linux
+
+package sys
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/moqsien/gknet/utils"
+)
+
+// SetUserTimeout sets TCP_USER_TIMEOUT, the conn is dropped once its output stays
+// unacknowledged for so long.
+func SetUserTimeout(fd int, timeout time.Duration) error {
+	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, TCP_USER_TIMEOUT, int(timeout/time.Millisecond)))
+}
+
+// SetQuickAck sets TCP_QUICKACK, the kernel may leave the quickack mode on its own later.
+func SetQuickAck(fd int, on bool) error {
+	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, TCP_QUICKACK, boolint(on)))
+}
+
+// SetFastOpen sets TCP_FASTOPEN on a listener fd, qlen is the queue of the pending TFO
+// requests.
+func SetFastOpen(fd, qlen int) error {
+	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, TCP_FASTOPEN, qlen))
+}
+
+// SetDeferAccept sets TCP_DEFER_ACCEPT, a conn is accepted once its first data arrives
+// or after secs.
+func SetDeferAccept(fd, secs int) error {
+	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, TCP_DEFER_ACCEPT, secs))
+}
+
+// SetFreeBind sets IP_FREEBIND, so an address not yet configured on the host can be bound.
+func SetFreeBind(fd int, on bool) error {
+	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, IP_FREEBIND, boolint(on)))
+}
+
+// SetTransparent sets IP_TRANSPARENT(IPV6_TRANSPARENT on IPv6 sockets) for transparent
+// proxying, it needs CAP_NET_ADMIN.
+func SetTransparent(fd int, on bool) error {
+	v6, err := isInet6(fd)
+	if err != nil {
+		return err
+	}
+	if v6 {
+		return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, IPV6_TRANSPARENT, boolint(on)))
+	}
+	return utils.SysError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, IP_TRANSPARENT, boolint(on)))
+}
@@ -0,0 +1,15 @@
+//go:build darwin
+
+package sys
+
+import "syscall"
+
+// Pipe is not needed without splice(2).
+func Pipe() (r, w int, err error) {
+	return -1, -1, syscall.ENOSYS
+}
+
+// Splice is only available on Linux.
+func Splice(rfd, wfd, n int) (int, error) {
+	return 0, syscall.ENOSYS
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package sys
+
+import "syscall"
+
+const (
+	spliceMove     = 0x1
+	spliceNonblock = 0x2
+)
+
+// Pipe returns a nonblocking pipe to Splice through.
+func Pipe() (r, w int, err error) {
+	var p [2]int
+	if err = syscall.Pipe2(p[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		return -1, -1, err
+	}
+	return p[0], p[1], nil
+}
+
+// Splice moves up to n bytes from rfd to wfd in the kernel, one of them must be a pipe.
+// It returns 0 and no error at the end of rfd.
+func Splice(rfd, wfd, n int) (int, error) {
+	m, err := syscall.Splice(rfd, nil, wfd, nil, n, spliceMove|spliceNonblock)
+	if m < 0 {
+		m = 0
+	}
+	return int(m), err
+}
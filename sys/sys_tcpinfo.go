@@ -0,0 +1,26 @@
+package sys
+
+import "time"
+
+// TCPInfo is a snapshot of the TCP state of a socket, fields the platform does not report
+// are zero. Darwin reports the state, timers, windows and byte counters only.
+type TCPInfo struct {
+	State         uint8
+	RTT           time.Duration // smoothed round trip time
+	RTTVar        time.Duration
+	MinRTT        time.Duration
+	RTO           time.Duration
+	SndMSS        uint32
+	SndCwnd       uint32 // congestion window in bytes
+	SndSsthresh   uint32
+	SndWnd        uint32
+	Unacked       uint32 // segments
+	Lost          uint32 // segments
+	Retransmits   uint8  // timeouts of the current segment
+	TotalRetrans  uint32 // segments retransmitted
+	BytesSent     uint64
+	BytesAcked    uint64
+	BytesReceived uint64
+	BytesRetrans  uint64
+	DeliveryRate  uint64 // bytes per second
+}
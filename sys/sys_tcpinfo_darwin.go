@@ -0,0 +1,65 @@
+//go:build darwin
+
+package sys
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const tcpConnectionInfo = 0x106 // TCP_CONNECTION_INFO
+
+// tcpConnInfo mirrors struct tcp_connection_info of netinet/tcp.h.
+type tcpConnInfo struct {
+	State            uint8
+	SndWscale        uint8
+	RcvWscale        uint8
+	pad              uint8
+	Options          uint32
+	Flags            uint32
+	Rto              uint32 // ms
+	Maxseg           uint32
+	SndSsthresh      uint32
+	SndCwnd          uint32 // bytes
+	SndWnd           uint32
+	SndSbbytes       uint32
+	RcvWnd           uint32
+	Rttcur           uint32 // ms
+	Srtt             uint32 // ms
+	Rttvar           uint32 // ms
+	tfo              uint32
+	Txpackets        uint64
+	Txbytes          uint64
+	Txretransmitbyte uint64
+	Rxpackets        uint64
+	Rxbytes          uint64
+	Rxoutoforderbyte uint64
+	Txretransmitpkts uint64
+}
+
+// GetTCPInfo reads TCP_CONNECTION_INFO of fd.
+func GetTCPInfo(fd int) (*TCPInfo, error) {
+	s := &tcpConnInfo{}
+	size := uint32(unsafe.Sizeof(*s))
+	_, _, e := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(fd), syscall.IPPROTO_TCP, tcpConnectionInfo,
+		uintptr(unsafe.Pointer(s)), uintptr(unsafe.Pointer(&size)), 0)
+	if e != 0 {
+		return nil, errnoErr(e)
+	}
+	ms := func(v uint32) time.Duration { return time.Duration(v) * time.Millisecond }
+	return &TCPInfo{
+		State:         s.State,
+		RTT:           ms(s.Srtt),
+		RTTVar:        ms(s.Rttvar),
+		RTO:           ms(s.Rto),
+		SndMSS:        s.Maxseg,
+		SndCwnd:       s.SndCwnd,
+		SndSsthresh:   s.SndSsthresh,
+		SndWnd:        s.SndWnd,
+		TotalRetrans:  uint32(s.Txretransmitpkts),
+		BytesSent:     s.Txbytes,
+		BytesReceived: s.Rxbytes,
+		BytesRetrans:  s.Txretransmitbyte,
+	}, nil
+}
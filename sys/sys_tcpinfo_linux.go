@@ -0,0 +1,41 @@
+//go:build linux
+
+package sys
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// GetTCPInfo reads TCP_INFO of fd, the fields older kernels do not know stay zero.
+func GetTCPInfo(fd int) (*TCPInfo, error) {
+	s := &state{}
+	size := uint32(unsafe.Offsetof(s.TCPCongesAlg))
+	_, _, e := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(fd), syscall.SOL_TCP, syscall.TCP_INFO,
+		uintptr(unsafe.Pointer(s)), uintptr(unsafe.Pointer(&size)), 0)
+	if e != 0 {
+		return nil, errnoErr(e)
+	}
+	us := func(v uint32) time.Duration { return time.Duration(v) * time.Microsecond }
+	return &TCPInfo{
+		State:         s.State,
+		RTT:           us(s.Rtt),
+		RTTVar:        us(s.Rttvar),
+		MinRTT:        us(s.MinRtt),
+		RTO:           us(s.Rto),
+		SndMSS:        s.SndMss,
+		SndCwnd:       s.SndCwnd * s.SndMss,
+		SndSsthresh:   s.SndSsthresh,
+		SndWnd:        s.SndWnd,
+		Unacked:       s.Unacked,
+		Lost:          s.Lost,
+		Retransmits:   s.Retransmits,
+		TotalRetrans:  s.TotalRetrans,
+		BytesSent:     s.BytesSent,
+		BytesAcked:    s.BytesAcked,
+		BytesReceived: s.BytesReceived,
+		BytesRetrans:  s.BytesRetrans,
+		DeliveryRate:  s.DeliveryRate,
+	}, nil
+}
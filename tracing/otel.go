@@ -0,0 +1,72 @@
+/*
+Package tracing turns the lifecycle of the conns of an engine into OpenTelemetry spans,
+set Options.Tracer to New(otel.Tracer("gknet")).
+*/
+package tracing
+
+import (
+	"context"
+	"net"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/moqsien/gknet/iface"
+)
+
+// Tracer starts a "gknet.conn" span for every conn, lasting until the conn is closed, and
+// a child span for each of its stages, named after the stage("gknet.open", etc.).
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+func New(tracer trace.Tracer) *Tracer {
+	return &Tracer{tracer: tracer}
+}
+
+func (that *Tracer) TraceConn(local, remote net.Addr) iface.ConnTrace {
+	attrs := append(addrAttrs(local, semconv.NetHostIPKey, semconv.NetHostPortKey, semconv.NetHostNameKey),
+		addrAttrs(remote, semconv.NetPeerIPKey, semconv.NetPeerPortKey, semconv.NetPeerNameKey)...)
+	ctx, span := that.tracer.Start(context.Background(), "gknet.conn",
+		trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+	return &connTrace{tracer: that.tracer, ctx: ctx, span: span}
+}
+
+type connTrace struct {
+	tracer trace.Tracer
+	ctx    context.Context // holds span
+	span   trace.Span
+}
+
+func (that *connTrace) Start(stage iface.TraceStage) func(error) {
+	_, span := that.tracer.Start(that.ctx, "gknet."+stage.String())
+	return func(err error) {
+		finish(span, err)
+	}
+}
+
+func (that *connTrace) End(err error) {
+	finish(that.span, err)
+}
+
+func finish(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func addrAttrs(addr net.Addr, ip, port, name attribute.Key) []attribute.KeyValue {
+	switch a := addr.(type) {
+	case nil:
+		return nil
+	case *net.TCPAddr:
+		return []attribute.KeyValue{semconv.NetTransportTCP, ip.String(a.IP.String()), port.Int(a.Port)}
+	case *net.UnixAddr:
+		return []attribute.KeyValue{semconv.NetTransportUnix, name.String(a.Name)}
+	}
+	return []attribute.KeyValue{name.String(addr.String())}
+}
@@ -0,0 +1,66 @@
+/*
+budget counts the bytes held in the buffers of all conns of an engine against a limit.
+*/
+package budget
+
+import "sync/atomic"
+
+type Budget struct {
+	limit    int64
+	used     int64
+	rejected int64
+}
+
+// New returns a Budget of limit bytes, or nil if limit is not positive. The methods of a
+// nil Budget do nothing.
+func New(limit int64) *Budget {
+	if limit <= 0 {
+		return nil
+	}
+	return &Budget{limit: limit}
+}
+
+// Add changes the bytes in use by n, which is negative when buffers are released.
+func (that *Budget) Add(n int64) {
+	if that != nil && n != 0 {
+		atomic.AddInt64(&that.used, n)
+	}
+}
+
+func (that *Budget) Used() int64 {
+	if that == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&that.used)
+}
+
+func (that *Budget) Limit() int64 {
+	if that == nil {
+		return 0
+	}
+	return that.limit
+}
+
+// Exhausted reports whether the usage has reached 15/16 of the limit, new conns are
+// rejected then so the conns already open have room left.
+func (that *Budget) Exhausted() bool {
+	if that == nil {
+		return false
+	}
+	return atomic.LoadInt64(&that.used) >= that.limit-that.limit/16
+}
+
+// Reject records a conn rejected for the budget.
+func (that *Budget) Reject() {
+	if that != nil {
+		atomic.AddInt64(&that.rejected, 1)
+	}
+}
+
+// Rejected returns the number of conns rejected for the budget.
+func (that *Budget) Rejected() int64 {
+	if that == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&that.rejected)
+}
@@ -0,0 +1,88 @@
+package cidr
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		in   []string
+		want []string
+		ok   bool
+	}{
+		{nil, nil, true},
+		{[]string{"10.0.0.0/8", "fd00::/8"}, []string{"10.0.0.0/8", "fd00::/8"}, true},
+		{[]string{"10.1.2.3/8"}, []string{"10.0.0.0/8"}, true},
+		{[]string{"10.0.0.1", "::1"}, []string{"10.0.0.1/32", "::1/128"}, true},
+		{[]string{"::ffff:10.0.0.1"}, []string{"10.0.0.1/32"}, true},
+		{[]string{"10.0.0.1", "example.com"}, nil, false},
+		{[]string{"10.0.0.0/33"}, nil, false},
+		{[]string{""}, nil, false},
+	}
+	for _, c := range cases {
+		nets, err := Parse(c.in)
+		if (err == nil) != c.ok {
+			t.Errorf("Parse(%q) returned %v", c.in, err)
+			continue
+		}
+		if !c.ok {
+			continue
+		}
+		if len(nets) != len(c.want) {
+			t.Errorf("Parse(%q) = %v, want %v", c.in, nets, c.want)
+			continue
+		}
+		for i, n := range nets {
+			if n.String() != c.want[i] {
+				t.Errorf("Parse(%q) = %v, want %v", c.in, nets, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	nets, err := Parse([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.255.0.1", true},
+		{"11.0.0.1", false},
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+		{"::ffff:10.0.0.1", true},
+		{"fd12::1", true},
+		{"fe80::1", false},
+	}
+	for _, c := range cases {
+		if got := Contains(nets, net.ParseIP(c.ip)); got != c.want {
+			t.Errorf("Contains(%s) = %v, want %v", c.ip, got, c.want)
+		}
+	}
+	if Contains(nil, net.ParseIP("10.0.0.1")) {
+		t.Error("an empty list contains an address")
+	}
+}
+
+func TestIP(t *testing.T) {
+	ip := net.ParseIP("10.0.0.1")
+	cases := []struct {
+		addr net.Addr
+		want net.IP
+	}{
+		{&net.TCPAddr{IP: ip, Port: 80}, ip},
+		{&net.UDPAddr{IP: ip, Port: 53}, ip},
+		{&net.UnixAddr{Name: "/tmp/a.sock", Net: "unix"}, nil},
+		{nil, nil},
+	}
+	for _, c := range cases {
+		if got := IP(c.addr); !got.Equal(c.want) {
+			t.Errorf("IP(%v) = %v, want %v", c.addr, got, c.want)
+		}
+	}
+}
@@ -3,7 +3,20 @@ package errs
 import "errors"
 
 var (
-	ErrAcceptSocket   = errors.New("accept a new connection error")
-	ErrEngineShutdown = errors.New("server is going to be shutdown")
-	ErrUnsupportedOp  = errors.New("unsupported operation")
+	ErrAcceptSocket       = errors.New("accept a new connection error")
+	ErrEngineShutdown     = errors.New("server is going to be shutdown")
+	ErrUnsupportedOp      = errors.New("unsupported operation")
+	ErrConnNotOpened      = errors.New("connection is not opened")
+	ErrDialTimeout        = errors.New("dial timed out")
+	ErrNoTrustedProxy     = errors.New("PROXY protocol needs trusted sources")
+	ErrWriteOverflow      = errors.New("pending output exceeds the write buffer limit")
+	ErrReadOverflow       = errors.New("unread input exceeds the read buffer limit")
+	ErrStaleConn          = errors.New("connection has been closed")
+	ErrDispatchFull       = errors.New("dispatch queue is full")
+	ErrConnDenied         = errors.New("connection is denied")
+	ErrTooManyConns       = errors.New("too many connections")
+	ErrTooManyFromIP      = errors.New("too many connections from the source")
+	ErrAcceptRate         = errors.New("accept rate limit is exceeded")
+	ErrWriteClosed        = errors.New("write side of the connection is closed")
+	ErrUnsupportedSockOpt = errors.New("socket option is not supported on this platform")
 )
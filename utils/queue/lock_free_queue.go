@@ -9,6 +9,7 @@ type TaskQueue interface {
 	Enqueue(interface{})
 	Dequeue() interface{}
 	IsEmpty() bool
+	Len() int
 }
 
 type Queue struct {
@@ -73,6 +74,10 @@ func (q *Queue) IsEmpty() bool {
 	return atomic.LoadInt32(&q.Length) == 0
 }
 
+func (q *Queue) Len() int {
+	return int(atomic.LoadInt32(&q.Length))
+}
+
 func load(p *unsafe.Pointer) (n *node) {
 	return (*node)(atomic.LoadPointer(p))
 }
@@ -0,0 +1,77 @@
+/*
+ratelimit provides a token bucket, it is not safe for concurrent use.
+*/
+package ratelimit
+
+import "time"
+
+// Bucket holds up to burst tokens and gains rate tokens per second. A zero Bucket, or one
+// with a rate not positive, allows everything.
+type Bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// Set changes the rate and the burst(rate, at least 1) and fills the bucket.
+func (that *Bucket) Set(rate float64, burst int) {
+	if burst <= 0 {
+		burst = int(rate)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	that.rate, that.burst, that.tokens, that.last = rate, float64(burst), float64(burst), time.Time{}
+}
+
+func (that *Bucket) Enabled() bool {
+	return that.rate > 0
+}
+
+func (that *Bucket) Burst() int {
+	return int(that.burst)
+}
+
+func (that *Bucket) refill(now time.Time) {
+	if !that.last.IsZero() {
+		that.tokens += now.Sub(that.last).Seconds() * that.rate
+		if that.tokens > that.burst {
+			that.tokens = that.burst
+		}
+	}
+	that.last = now
+}
+
+// Allow takes n tokens if the bucket has them.
+func (that *Bucket) Allow(n float64) bool {
+	if !that.Enabled() {
+		return true
+	}
+	that.refill(time.Now())
+	if that.tokens < n {
+		return false
+	}
+	that.tokens -= n
+	return true
+}
+
+// Take takes n tokens, running into debt if the bucket has fewer.
+func (that *Bucket) Take(n float64) {
+	if that.Enabled() {
+		that.refill(time.Now())
+		that.tokens -= n
+	}
+}
+
+// Delay returns how long it takes until the bucket has n tokens.
+func (that *Bucket) Delay(n float64) time.Duration {
+	if !that.Enabled() {
+		return 0
+	}
+	that.refill(time.Now())
+	if that.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - that.tokens) / that.rate * float64(time.Second))
+}